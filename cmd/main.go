@@ -1,16 +1,49 @@
 // Package main provides the entry point for the Aether KV key-value store application.
 // It initializes the logger, loads configuration, creates the storage engine,
-// and starts the command-line interface.
+// and starts the command-line interface, the RESP server when run as
+// "aether-kv serve", or the HTTP API when run as "aether-kv http". Run as
+// "aether-kv backup <dir>" it writes a backup of the data directory to dir,
+// and run as "aether-kv restore --until <time|sequence> <src> <dst>" it
+// rebuilds the store as of a past moment from a backup into a new data
+// directory. Run as "aether-kv dump [file]" it writes every live key to
+// JSON Lines or CSV, and run as "aether-kv load [file]" it writes such a
+// dump back in batches.
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/jassi-singh/aether-kv/internal/cli"
 	"github.com/jassi-singh/aether-kv/internal/config"
+	"github.com/jassi-singh/aether-kv/internal/dump"
 	"github.com/jassi-singh/aether-kv/internal/engine"
+	"github.com/jassi-singh/aether-kv/internal/httpapi"
+	"github.com/jassi-singh/aether-kv/internal/server"
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+const (
+	// defaultListenAddr is used by serve mode when LISTEN_ADDR is not set.
+	defaultListenAddr = "127.0.0.1:6380"
+	// defaultHTTPAddr is used by http mode when HTTP_ADDR is not set.
+	defaultHTTPAddr = "127.0.0.1:8080"
+	// progressInterval is how often load logs its progress.
+	progressInterval = 5 * time.Second
+	// shutdownTimeout bounds how long http mode waits for requests in flight.
+	shutdownTimeout = 10 * time.Second
 )
 
 func main() {
@@ -35,29 +68,363 @@ func main() {
 		"header_size", cfg.HEADER_SIZE,
 		"batch_size", cfg.BATCH_SIZE,
 		"sync_interval", cfg.SYNC_INTERVAL,
+		"sync_mode", cfg.SYNC_MODE,
+		"max_file_size", cfg.MAX_FILE_SIZE,
+		"max_file_age", cfg.MAX_FILE_AGE,
+		"listen_addr", cfg.LISTEN_ADDR,
+		"http_addr", cfg.HTTP_ADDR,
+		"backup_dir", cfg.BACKUP_DIR,
 	)
 
+	// Restore writes a new data directory and must not open the configured one
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := restore(cfg, os.Args[2:]); err != nil {
+			slog.Error("main: restore failed",
+				"error", err)
+			log.Fatalf("Restore error: %v", err)
+		}
+		return
+	}
+
 	// Initialize KV engine with dependency injection
 	kv, err := engine.NewKVEngine(cfg)
 	if err != nil {
 		slog.Error("main: failed to initialize KV engine",
 			"error", err)
+		if errors.Is(err, storage.ErrLocked) {
+			log.Fatalf("Data directory %s is open in another process%s", cfg.DATA_DIR, lockedHint(os.Args[1:]))
+		}
 		log.Fatalf("Failed to create KV engine: %v", err)
 	}
-	defer func() {
-		if err := kv.Close(); err != nil {
-			slog.Error("main: error closing KV engine",
-				"error", err)
-		}
-	}()
 
 	slog.Info("main: Aether KV started successfully")
 
-	// Start CLI handler
-	cliHandler := cli.NewHandler(kv)
-	if err := cliHandler.Run(); err != nil {
-		slog.Error("main: CLI handler error",
+	// Modes return their errors instead of exiting, so the engine is closed
+	// and its buffered writes flushed before the process exits
+	runErr := run(kv, cfg, os.Args[1:])
+	if err := kv.Close(); err != nil {
+		slog.Error("main: error closing KV engine",
 			"error", err)
-		log.Fatalf("CLI error: %v", err)
+		if runErr == nil {
+			runErr = fmt.Errorf("failed to close KV engine: %w", err)
+		}
+	}
+	if runErr != nil {
+		slog.Error("main: exiting with error",
+			"error", runErr)
+		log.Fatal(runErr)
+	}
+}
+
+// run executes the mode or subcommand named by args against kv, starting
+// the interactive CLI if there is none.
+func run(kv engine.Engine, cfg *config.Config, args []string) error {
+	mode := ""
+	if len(args) > 0 {
+		mode = args[0]
+	}
+
+	switch mode {
+	case "serve":
+		addr := cfg.LISTEN_ADDR
+		if addr == "" {
+			addr = defaultListenAddr
+		}
+		if err := serve(kv, addr, cfg.BACKUP_DIR); err != nil {
+			return fmt.Errorf("server error: %w", err)
+		}
+	case "http":
+		addr := cfg.HTTP_ADDR
+		if addr == "" {
+			addr = defaultHTTPAddr
+		}
+		if err := serveHTTP(kv, addr, cfg.BACKUP_DIR); err != nil {
+			return fmt.Errorf("HTTP server error: %w", err)
+		}
+	case "backup":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s backup <dir>", os.Args[0])
+		}
+		if err := backup(kv, args[1]); err != nil {
+			return fmt.Errorf("backup error: %w", err)
+		}
+	case "dump":
+		if err := dumpKeys(kv, args[1:]); err != nil {
+			return fmt.Errorf("dump error: %w", err)
+		}
+	case "load":
+		if err := loadKeys(kv, args[1:]); err != nil {
+			return fmt.Errorf("load error: %w", err)
+		}
+	default:
+		cliHandler := cli.NewHandler(kv)
+		if err := cliHandler.Run(); err != nil {
+			return fmt.Errorf("CLI error: %w", err)
+		}
+	}
+	return nil
+}
+
+// lockedHint returns advice on doing what the subcommand in args asked for
+// while another process has the data directory open.
+func lockedHint(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	switch args[0] {
+	case "backup":
+		return "; back up a running server with BACKUP <name> over RESP or POST /admin/backup over HTTP, which write under its BACKUP_DIR"
+	case "dump":
+		return "; stop the server first, or back it up through the server and dump the backup with DATA_DIR pointing at it"
+	case "load":
+		return "; stop the server before loading into its data directory"
+	}
+	return ""
+}
+
+// serve runs the RESP server on addr until SIGINT or SIGTERM is received,
+// then closes every connection before returning. BACKUP writes under
+// backupDir, and is disabled if it is empty.
+func serve(kv engine.Engine, addr, backupDir string) error {
+	srv := server.NewServer(kv)
+	srv.BackupDir = backupDir
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe(addr)
+	}()
+
+	if stopped, err := waitForShutdown(errCh); stopped {
+		return err
+	}
+
+	if err := srv.Close(); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, server.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// serveHTTP runs the HTTP API on addr until SIGINT or SIGTERM is received,
+// then waits up to shutdownTimeout for requests in flight before returning.
+// POST /admin/backup writes under backupDir, and is disabled if it is empty.
+func serveHTTP(kv engine.Engine, addr, backupDir string) error {
+	handler := httpapi.NewHandler(kv)
+	handler.BackupDir = backupDir
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("main: HTTP API listening",
+			"addr", addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	if stopped, err := waitForShutdown(errCh); stopped {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// backup writes a backup of kv to dir, checks it against its manifest and
+// prints a summary.
+func backup(kv engine.Engine, dir string) error {
+	manifest, err := kv.Backup(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := engine.VerifyBackup(dir); err != nil {
+		return err
+	}
+
+	var size int64
+	linked := 0
+	for _, file := range manifest.Files {
+		size += file.Size
+		if file.Linked {
+			linked++
+		}
+	}
+	fmt.Printf("Backup written to %s: %d files (%d hard-linked), %d bytes, up to sequence %d\n",
+		dir, len(manifest.Files), linked, size, manifest.LastSeq)
+	return nil
+}
+
+// restore parses the arguments of the restore subcommand and rebuilds the
+// store as of the requested point from the given backup into a new data
+// directory, using cfg for everything but the data directory.
+func restore(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	until := flags.String("until", "", "sequence number or RFC 3339 time to restore up to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *until == "" || flags.NArg() != 2 {
+		return fmt.Errorf("usage: %s restore --until <time|sequence> <src> <dst>", os.Args[0])
+	}
+
+	var point engine.RestorePoint
+	if seq, err := strconv.ParseUint(*until, 10, 64); err == nil {
+		point.Seq = seq
+	} else if t, err := time.Parse(time.RFC3339Nano, *until); err == nil {
+		point.Time = t
+	} else {
+		return fmt.Errorf("invalid --until %q: want a sequence number or an RFC 3339 time", *until)
+	}
+
+	dstCfg := *cfg
+	dstCfg.DATA_DIR = flags.Arg(1)
+	result, err := engine.Restore(&dstCfg, flags.Arg(0), point)
+	if err != nil {
+		return err
+	}
+	if result.Records == 0 {
+		fmt.Printf("Nothing was written up to %s, created empty %s\n", point, dstCfg.DATA_DIR)
+		return nil
+	}
+	fmt.Printf("Restored %d records in %d batches to %s, up to sequence %d written at %s\n",
+		result.Records, result.Batches, dstCfg.DATA_DIR, result.LastSeq, result.LastTime.Format(time.RFC3339Nano))
+	return nil
+}
+
+// dumpKeys parses the arguments of the dump subcommand and writes the live
+// keys of kv to the given file, or to stdout if there is none.
+func dumpKeys(kv engine.Engine, args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	formatName := flags.String("format", "", "jsonl or csv, by default taken from the file extension")
+	prefix := flags.String("prefix", "", "only dump keys starting with this prefix")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return fmt.Errorf("usage: %s dump [--format jsonl|csv] [--prefix p] [file]", os.Args[0])
+	}
+	format, err := dumpFormat(*formatName, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if path := flags.Arg(0); path != "" && path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		defer out.Close()
+	}
+	w := bufio.NewWriter(out)
+	result, err := dump.Dump(kv, w, format, dump.DumpOptions{
+		Prefix: *prefix,
+		Progress: func(keys int) {
+			slog.Info("main: dump progress",
+				"keys", keys)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write dump: %w", err)
+	}
+	if out != os.Stdout {
+		if err := out.Sync(); err != nil {
+			return fmt.Errorf("failed to sync dump: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Dumped %d keys to %s as of sequence %d\n", result.Keys, out.Name(), result.Seq)
+	}
+	return nil
+}
+
+// loadKeys parses the arguments of the load subcommand and writes the keys
+// read from the given file, or from stdin if there is none, to kv.
+func loadKeys(kv engine.Engine, args []string) error {
+	flags := flag.NewFlagSet("load", flag.ContinueOnError)
+	formatName := flags.String("format", "", "jsonl or csv, by default taken from the file extension")
+	batchSize := flags.Int("batch", dump.DefaultBatchSize, "records written per batch")
+	checkpoint := flags.String("checkpoint", "", "file recording progress, to resume an interrupted load")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 || *batchSize <= 0 {
+		return fmt.Errorf("usage: %s load [--format jsonl|csv] [--batch n] [--checkpoint file] [file]", os.Args[0])
+	}
+	format, err := dumpFormat(*formatName, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		if in, err = os.Open(path); err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer in.Close()
+	}
+	lastReport := time.Now()
+	stats, err := dump.Load(kv, bufio.NewReader(in), format, dump.LoadOptions{
+		BatchSize:  *batchSize,
+		Checkpoint: *checkpoint,
+		Progress: func(s dump.LoadStats) {
+			if time.Since(lastReport) < progressInterval {
+				return
+			}
+			lastReport = time.Now()
+			slog.Info("main: load progress",
+				"records", s.Records,
+				"loaded", s.Loaded,
+				"expired", s.Expired,
+				"resumed", s.Resumed)
+		},
+	})
+	if err != nil {
+		if *checkpoint != "" {
+			fmt.Fprintf(os.Stderr, "Loaded %d keys before failing, rerun with --checkpoint %s to resume\n", stats.Loaded, *checkpoint)
+		}
+		return err
+	}
+	fmt.Printf("Loaded %d keys from %d records (%d expired, %d already loaded)\n",
+		stats.Loaded, stats.Records, stats.Expired, stats.Resumed)
+	return nil
+}
+
+// dumpFormat returns the format named by name, or the one matching the
+// extension of path if name is empty, defaulting to JSON Lines.
+func dumpFormat(name, path string) (dump.Format, error) {
+	if name != "" {
+		return dump.ParseFormat(name)
+	}
+	if filepath.Ext(path) == ".csv" {
+		return dump.CSV, nil
+	}
+	return dump.JSONL, nil
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM is received or the server
+// feeding errCh stops on its own. Returns true and the server's error in
+// the latter case.
+func waitForShutdown(errCh <-chan error) (bool, error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-errCh:
+		return true, err
+	case sig := <-signals:
+		slog.Info("main: shutdown signal received",
+			"signal", sig.String())
+		return false, nil
 	}
 }
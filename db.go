@@ -0,0 +1,841 @@
+package aetherkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/engine"
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+// Limits on the size of keys and values.
+const (
+	MaxKeySize   = engine.MaxKeySize   // Largest key in bytes
+	MaxValueSize = engine.MaxValueSize // Largest value in bytes
+)
+
+// NoTTL is returned by DB.TTL for a key that never expires.
+const NoTTL = engine.NoTTL
+
+var (
+	// ErrNotFound is returned when a key does not exist, has been deleted or
+	// has expired.
+	ErrNotFound = engine.ErrKeyNotFound
+	// ErrClosed is returned when using a DB after Close, or reading from a
+	// released Snapshot.
+	ErrClosed = engine.ErrClosed
+	// ErrKeyTooLarge is returned when writing a key longer than MaxKeySize.
+	ErrKeyTooLarge = engine.ErrKeyTooLarge
+	// ErrValueTooLarge is returned when writing a value longer than MaxValueSize.
+	ErrValueTooLarge = engine.ErrValueTooLarge
+	// ErrMergeInProgress is returned by DB.Merge while another merge is running.
+	ErrMergeInProgress = engine.ErrMergeInProgress
+	// ErrSegmentsPinned is returned by DB.Merge while an open Snapshot
+	// references the files it would rewrite.
+	ErrSegmentsPinned = engine.ErrSegmentsPinned
+	// ErrSequenceUnavailable is returned when reading at a sequence number
+	// that has not been written yet, or whose overwritten values were not
+	// kept because no Snapshot needed them.
+	ErrSequenceUnavailable = engine.ErrSequenceUnavailable
+	// ErrConflict is returned by Txn.Commit when a key the transaction read
+	// was written by someone else before it committed. Nothing was written,
+	// and the transaction can be retried from the start.
+	ErrConflict = engine.ErrConflict
+	// ErrTxnDone is returned when using a Txn after Commit or Rollback.
+	ErrTxnDone = engine.ErrTxnDone
+	// ErrWatchLagged ends a Watcher whose subscriber fell so far behind that
+	// its buffer filled up. It can resume after the last event it received.
+	ErrWatchLagged = engine.ErrWatchLagged
+	// ErrWatchCompacted ends a Watcher resuming from a position whose later
+	// writes were partly discarded by a merge.
+	ErrWatchCompacted = engine.ErrWatchCompacted
+	// ErrBackupCorrupt is returned by VerifyBackup when a file of a backup
+	// is missing or does not match its recorded checksum.
+	ErrBackupCorrupt = engine.ErrBackupCorrupt
+	// ErrRestoreCompacted is returned by Restore when a merge discarded
+	// records the requested point needs.
+	ErrRestoreCompacted = engine.ErrRestoreCompacted
+	// ErrLocked is returned by Open when another DB, in this process or
+	// another one, has the directory open.
+	ErrLocked = storage.ErrLocked
+)
+
+// DB is an open database. It is safe for concurrent use.
+type DB struct {
+	engine *engine.KVEngine
+	mu     sync.RWMutex // Held for reading by every operation so Close waits for them
+	closed bool
+}
+
+// Open opens the database in dir, creating the directory if it does not
+// exist, and rebuilds the in-memory index from its files. The directory is
+// locked until Close. Returns ErrLocked if another DB has it open, or an
+// error if an option is invalid or the files cannot be read.
+func Open(dir string, opts ...Option) (*DB, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg, err := o.config(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	kv, err := engine.NewKVEngine(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", dir, err)
+	}
+	return &DB{engine: kv}, nil
+}
+
+// do runs fn unless the DB is closed, keeping Close from running until fn returns.
+func (db *DB) do(fn func() error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrClosed
+	}
+	return fn()
+}
+
+// ReadOption configures a single read.
+type ReadOption func(*readOptions)
+
+// readOptions holds the settings collected from the ReadOptions of a read.
+type readOptions struct {
+	seq    uint64
+	hasSeq bool
+}
+
+// AtSequence reads the value a key had once every write up to sequence
+// number seq was applied, ignoring later writes. Values overwritten or
+// deleted since seq are only kept while a Snapshot taken at or before their
+// replacement is open, so reading far back needs one.
+func AtSequence(seq uint64) ReadOption {
+	return func(o *readOptions) {
+		o.seq = seq
+		o.hasSeq = true
+	}
+}
+
+// Get returns the value stored under key. Returns ErrNotFound if the key
+// does not exist or has expired, and ErrSequenceUnavailable if the
+// sequence number given with AtSequence is ahead of the last write or no
+// longer readable.
+func (db *DB) Get(key string, opts ...ReadOption) (string, error) {
+	var o readOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var value string
+	err := db.do(func() (err error) {
+		if o.hasSeq {
+			value, err = db.engine.GetAt(key, o.seq)
+		} else {
+			value, err = db.engine.Get(key)
+		}
+		return err
+	})
+	return value, err
+}
+
+// GetBytes is like Get for binary keys and values. The returned slice
+// belongs to the caller. Returns ctx's error if ctx is done before the
+// value is read, including while the read waits for a merge to swap in
+// its segments.
+func (db *DB) GetBytes(ctx context.Context, key []byte) ([]byte, error) {
+	var value []byte
+	err := db.do(func() (err error) {
+		value, err = db.engine.GetBytes(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+// Put stores value under key, replacing any previous value and TTL.
+func (db *DB) Put(key string, value string) error {
+	return db.do(func() error {
+		return db.engine.Put(key, value)
+	})
+}
+
+// PutWithTTL stores value under key until ttl has passed. ttl must be positive.
+func (db *DB) PutWithTTL(key string, value string, ttl time.Duration) error {
+	return db.do(func() error {
+		return db.engine.PutWithTTL(key, value, ttl)
+	})
+}
+
+// PutOptions holds the optional settings of PutBytes.
+type PutOptions struct {
+	TTL time.Duration // Time after which the key expires; zero means never
+}
+
+// PutBytes stores value under key like Put, for binary keys and values,
+// expiring the key once opts.TTL has passed if it is set. Neither slice is
+// retained or modified, and the caller may reuse them once PutBytes
+// returns. If ctx is done while the write waits behind earlier writes,
+// nothing is written and ctx's error is returned; once the write has been
+// handed to the log, PutBytes waits for it to finish.
+func (db *DB) PutBytes(ctx context.Context, key, value []byte, opts PutOptions) error {
+	return db.do(func() error {
+		return db.engine.PutBytes(ctx, key, value, engine.PutOptions{TTL: opts.TTL})
+	})
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (db *DB) Delete(key string) error {
+	return db.do(func() error {
+		return db.engine.Delete(key)
+	})
+}
+
+// DeleteBytes is like Delete for a binary key, and gives up like PutBytes
+// once ctx is done.
+func (db *DB) DeleteBytes(ctx context.Context, key []byte) error {
+	return db.do(func() error {
+		return db.engine.DeleteBytes(ctx, key)
+	})
+}
+
+// TTL returns the time left before key expires, or NoTTL if it never
+// expires. Returns ErrNotFound if the key does not exist or has expired.
+func (db *DB) TTL(key string) (time.Duration, error) {
+	var ttl time.Duration
+	err := db.do(func() (err error) {
+		ttl, err = db.engine.TTL(key)
+		return err
+	})
+	return ttl, err
+}
+
+// Expire sets key to expire after ttl, keeping its value. A ttl of zero or
+// less deletes the key. Reports whether the key existed.
+func (db *DB) Expire(key string, ttl time.Duration) (bool, error) {
+	var ok bool
+	err := db.do(func() (err error) {
+		ok, err = db.engine.Expire(key, ttl)
+		return err
+	})
+	return ok, err
+}
+
+// GetVersion is like Get but also returns the key's version, which changes
+// with every write to the key and never repeats. Pass it to CompareAndSwap
+// or DeleteIfVersion to update the key only if nobody else has since. Keys
+// last written by a release without versions report version 0.
+func (db *DB) GetVersion(key string) (string, uint64, error) {
+	var value string
+	var version uint64
+	err := db.do(func() (err error) {
+		value, version, err = db.engine.GetVersion(key)
+		return err
+	})
+	return value, version, err
+}
+
+// Entry is a value together with the metadata of the write that stored it.
+type Entry struct {
+	Value  string
+	Seq    uint64    // Sequence number of the write, which is also the key's version
+	Time   time.Time // Wall-clock time of the write, with nanosecond resolution
+	Expiry time.Time // Time at which the key expires, zero if it never does
+}
+
+// GetEntry is like Get but returns the value together with the sequence
+// number and time of the write that stored it. Unlike times, sequence
+// numbers totally order writes: a write acknowledged before another started
+// has the lower number. Keys last written by a release without sequence
+// numbers have Seq 0 and a time in whole seconds.
+func (db *DB) GetEntry(key string) (Entry, error) {
+	var entry Entry
+	err := db.do(func() error {
+		e, err := db.engine.GetEntry(key)
+		if err != nil {
+			return err
+		}
+		entry = Entry{Value: e.Value, Seq: e.Seq, Time: e.Time, Expiry: e.Expiry}
+		return nil
+	})
+	return entry, err
+}
+
+// LastSequence returns the sequence number of the most recent write, or 0
+// for a database never written by a release with sequence numbers. Every
+// operation of a write gets the next number, and numbering resumes where it
+// left off after Open.
+func (db *DB) LastSequence() (uint64, error) {
+	var seq uint64
+	err := db.do(func() error {
+		seq = db.engine.LastSeq()
+		return nil
+	})
+	return seq, err
+}
+
+// PutIfAbsent stores value under key unless the key exists. Reports whether
+// the value was stored.
+func (db *DB) PutIfAbsent(key string, value string) (bool, error) {
+	var ok bool
+	err := db.do(func() (err error) {
+		ok, err = db.engine.PutIfAbsent(key, value)
+		return err
+	})
+	return ok, err
+}
+
+// CompareAndSwap stores value under key only if the key still has the
+// version returned by GetVersion. Reports whether the value was stored; it
+// is not if the key was written or deleted since. The check and the write
+// are atomic with respect to every other write.
+func (db *DB) CompareAndSwap(key string, version uint64, value string) (bool, error) {
+	var ok bool
+	err := db.do(func() (err error) {
+		ok, err = db.engine.CompareAndSwap(key, version, value)
+		return err
+	})
+	return ok, err
+}
+
+// DeleteIfVersion deletes key only if it still has the version returned by
+// GetVersion. Reports whether the key was deleted.
+func (db *DB) DeleteIfVersion(key string, version uint64) (bool, error) {
+	var ok bool
+	err := db.do(func() (err error) {
+		ok, err = db.engine.DeleteIfVersion(key, version)
+		return err
+	})
+	return ok, err
+}
+
+// Write atomically applies every operation in the batch: after a crash
+// either all of them or none are recovered, and readers never see part of
+// a batch.
+func (db *DB) Write(batch *Batch) error {
+	if batch == nil {
+		return errors.New("batch cannot be nil")
+	}
+	return db.do(func() error {
+		return db.engine.Write(&batch.batch)
+	})
+}
+
+// WriteContext is like Write but gives up like PutBytes once ctx is done,
+// in which case none of the batch is applied.
+func (db *DB) WriteContext(ctx context.Context, batch *Batch) error {
+	if batch == nil {
+		return errors.New("batch cannot be nil")
+	}
+	return db.do(func() error {
+		return db.engine.WriteContext(ctx, &batch.batch)
+	})
+}
+
+// Scan returns an iterator over the keys in [start, end) in ascending order.
+// An empty end means no upper bound.
+func (db *DB) Scan(start, end string) *Iterator {
+	it := &Iterator{db: db}
+	it.err = db.do(func() error {
+		it.it = db.engine.Scan(start, end)
+		return nil
+	})
+	return it
+}
+
+// ScanPrefix returns an iterator over every key starting with prefix in
+// ascending order.
+func (db *DB) ScanPrefix(prefix string) *Iterator {
+	it := &Iterator{db: db}
+	it.err = db.do(func() error {
+		it.it = db.engine.ScanPrefix(prefix)
+		return nil
+	})
+	return it
+}
+
+// NewSnapshot returns a view of every live key as of the most recent
+// write. While it is open, values that later writes overwrite or delete are
+// kept, and Merge does not rewrite the files holding them, so it must be
+// released once it is no longer needed.
+func (db *DB) NewSnapshot() (*Snapshot, error) {
+	var s *Snapshot
+	err := db.do(func() error {
+		s = &Snapshot{db: db, snapshot: db.engine.NewSnapshot()}
+		return nil
+	})
+	return s, err
+}
+
+// NewSnapshotAt returns a view of every key as of sequence number seq.
+// Returns ErrSequenceUnavailable if seq is ahead of the last write or its
+// overwritten values are no longer kept; a snapshot opened earlier keeps
+// every sequence number from its own onwards readable.
+func (db *DB) NewSnapshotAt(seq uint64) (*Snapshot, error) {
+	var s *Snapshot
+	err := db.do(func() error {
+		snapshot, err := db.engine.NewSnapshotAt(seq)
+		if err != nil {
+			return err
+		}
+		s = &Snapshot{db: db, snapshot: snapshot}
+		return nil
+	})
+	return s, err
+}
+
+// Begin starts an optimistic read-write transaction. See Txn.
+func (db *DB) Begin() (*Txn, error) {
+	var txn *Txn
+	err := db.do(func() error {
+		txn = &Txn{db: db, txn: db.engine.Begin()}
+		return nil
+	})
+	return txn, err
+}
+
+// WatchOption configures a Watcher.
+type WatchOption func(*watchOptions)
+
+// watchOptions holds the settings collected from the WatchOptions of a watch.
+type watchOptions struct {
+	after  uint64
+	resume bool
+	buffer int
+}
+
+// WatchFrom resumes a watch after sequence number seq, typically the Seq of
+// the last event a previous Watcher delivered: writes after it that are
+// already in the log are replayed first. Without it a watch starts with the
+// next write.
+func WatchFrom(seq uint64) WatchOption {
+	return func(o *watchOptions) {
+		o.after = seq
+		o.resume = true
+	}
+}
+
+// WatchBuffer sets how many events are buffered for a subscriber before it
+// counts as lagging. Default 1024.
+func WatchBuffer(n int) WatchOption {
+	return func(o *watchOptions) {
+		o.buffer = n
+	}
+}
+
+// Watch subscribes to committed puts and deletes of every key starting with
+// prefix, delivered in write order with their sequence numbers. Writers
+// never wait for a subscriber; one that falls behind is dropped with
+// ErrWatchLagged and can resume with WatchFrom. Returns
+// ErrSequenceUnavailable if the position given with WatchFrom is ahead of
+// the last write.
+func (db *DB) Watch(prefix string, opts ...WatchOption) (*Watcher, error) {
+	var w *Watcher
+	err := db.do(func() error {
+		var o watchOptions
+		for _, opt := range opts {
+			opt(&o)
+		}
+		if !o.resume {
+			// Writes racing with this are replayed from the log, not missed
+			o.after = db.engine.LastSeq()
+		}
+		watcher, err := db.engine.Watch(prefix, engine.WatchOptions{After: o.after, Buffer: o.buffer})
+		if err != nil {
+			return err
+		}
+		w = &Watcher{watcher: watcher}
+		return nil
+	})
+	return w, err
+}
+
+// Backup writes a consistent copy of the database to dir, which must not
+// exist or be empty, without stopping reads or writes. The copy holds every
+// write acknowledged before it started and can be opened with Open. Files
+// that no longer change are hard-linked where possible, and a manifest of
+// checksums is written last so VerifyBackup can check the copy.
+func (db *DB) Backup(dir string) error {
+	return db.do(func() error {
+		_, err := db.engine.Backup(dir)
+		return err
+	})
+}
+
+// VerifyBackup checks every file of the backup in dir against the checksums
+// in its manifest. Returns an error wrapping ErrBackupCorrupt if a file is
+// missing or damaged.
+func VerifyBackup(dir string) error {
+	_, err := engine.VerifyBackup(dir)
+	return err
+}
+
+// RestorePoint is the moment Restore rebuilds a database at. Exactly one of
+// its fields must be set.
+type RestorePoint struct {
+	Seq  uint64    // Keep every write up to and including this sequence number
+	Time time.Time // Keep every write up to the first one made after this time
+}
+
+// Restore rebuilds the database as it was at until into dstDir, which must
+// not exist or be empty, from srcDir: a backup, which is verified first, or
+// the directory of a database that is not open. Writes are restored in
+// whole batches, keeping their sequence numbers, so the result opens with
+// Open like any other directory. Options apply to the new directory.
+// Returns ErrRestoreCompacted if a merge discarded records until needs.
+func Restore(srcDir, dstDir string, until RestorePoint, opts ...Option) error {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cfg, err := o.config(dstDir)
+	if err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	_, err = engine.Restore(cfg, srcDir, engine.RestorePoint{Seq: until.Seq, Time: until.Time})
+	return err
+}
+
+// Merge rewrites the sealed log files with only their live records and
+// waits for it to finish. Returns ErrMergeInProgress if a merge is already
+// running and ErrSegmentsPinned if an open snapshot references the files.
+func (db *DB) Merge() error {
+	return db.do(db.engine.Merge)
+}
+
+// Stats returns a summary of the database's current state.
+func (db *DB) Stats() (Stats, error) {
+	var stats Stats
+	err := db.do(func() error {
+		s, err := db.engine.Stats()
+		if err != nil {
+			return err
+		}
+		stats = Stats{
+			Keys:          s.Keys,
+			IndexBytes:    s.KeyDirBytes,
+			Files:         s.Segments,
+			DiskBytes:     s.DiskBytes,
+			DeadBytes:     s.DeadBytes,
+			Merging:       s.Merging,
+			LastSequence:  s.LastSeq,
+			Snapshots:     s.Snapshots,
+			Retained:      s.Retained,
+			FlushFailures: s.FlushFailures,
+		}
+		if s.Cache != nil {
+			stats.CacheHits = s.Cache.Hits
+			stats.CacheMisses = s.Cache.Misses
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Health returns an error while the database cannot persist writes, such as
+// when background flushes of buffered writes are failing.
+func (db *DB) Health() error {
+	return db.do(db.engine.Health)
+}
+
+// Close waits for running operations and background work, flushes buffered
+// writes and closes the database. Returns ErrClosed if it was already closed.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrClosed
+	}
+	db.closed = true
+	return db.engine.Close()
+}
+
+// Stats summarizes the state of a database.
+type Stats struct {
+	Keys          int    // Keys in the index, including expired ones not yet reaped
+	IndexBytes    int64  // Approximate memory held by the in-memory index of keys
+	Files         int    // Log files, including the active one
+	DiskBytes     int64  // Total size of all log files
+	DeadBytes     int64  // Bytes held by overwritten, deleted or expired records
+	Merging       bool   // Whether a merge is running
+	LastSequence  uint64 // Sequence number of the most recent write
+	Snapshots     int    // Open snapshots
+	Retained      int    // Overwritten or deleted values kept for open snapshots
+	FlushFailures uint64 // Background flushes of buffered writes that have failed since Open
+	CacheHits     uint64 // Reads served from the value cache since Open
+	CacheMisses   uint64 // Reads that missed the value cache since Open
+}
+
+// Batch collects puts and deletes that DB.Write applies atomically.
+// Operations are applied in the order they were added, so a later operation
+// on the same key wins. The zero value is an empty batch. A Batch is not
+// safe for concurrent use.
+type Batch struct {
+	batch engine.WriteBatch
+}
+
+// Put queues storing value under key.
+func (b *Batch) Put(key string, value string) {
+	b.batch.Put(key, value)
+}
+
+// PutWithTTL queues storing value under key until ttl has passed, measured
+// from when the operation is queued. ttl must be positive.
+func (b *Batch) PutWithTTL(key string, value string, ttl time.Duration) error {
+	return b.batch.PutWithTTL(key, value, ttl)
+}
+
+// Delete queues removing key.
+func (b *Batch) Delete(key string) {
+	b.batch.Delete(key)
+}
+
+// Len returns the number of operations queued in the batch.
+func (b *Batch) Len() int {
+	return b.batch.Len()
+}
+
+// Reset empties the batch so it can be reused.
+func (b *Batch) Reset() {
+	b.batch.Reset()
+}
+
+// Txn is an optimistic read-write transaction. Writes are buffered until
+// Commit and reads see them; every key read is checked again at Commit,
+// which writes everything atomically only if none of those keys changed in
+// the meantime and returns ErrConflict otherwise. No locks are held while
+// a transaction runs, so conflicting transactions are retried rather than
+// waited for:
+//
+//	for {
+//		txn, err := db.Begin()
+//		...
+//		stock, err := txn.Get("stock:42")
+//		...
+//		txn.Put("stock:42", decrement(stock))
+//		txn.Put("order:7", "reserved")
+//		if err := txn.Commit(); !errors.Is(err, aetherkv.ErrConflict) {
+//			return err
+//		}
+//	}
+//
+// A Txn is not safe for concurrent use.
+type Txn struct {
+	db  *DB
+	txn *engine.Txn
+}
+
+// Get returns the value of key as the transaction sees it: its own write if
+// it made one, else the current value. Returns ErrNotFound if the key does
+// not exist; Commit then fails with ErrConflict if it was created since.
+func (t *Txn) Get(key string) (string, error) {
+	var value string
+	err := t.db.do(func() (err error) {
+		value, err = t.txn.Get(key)
+		return err
+	})
+	return value, err
+}
+
+// Put buffers storing value under key.
+func (t *Txn) Put(key string, value string) error {
+	return t.txn.Put(key, value)
+}
+
+// PutWithTTL buffers storing value under key until ttl has passed, measured
+// from when the write is buffered. ttl must be positive.
+func (t *Txn) PutWithTTL(key string, value string, ttl time.Duration) error {
+	return t.txn.PutWithTTL(key, value, ttl)
+}
+
+// Delete buffers removing key.
+func (t *Txn) Delete(key string) error {
+	return t.txn.Delete(key)
+}
+
+// Commit writes the transaction's buffered writes atomically if no key it
+// read has changed since, and returns ErrConflict without writing anything
+// otherwise. The transaction is finished either way.
+func (t *Txn) Commit() error {
+	return t.db.do(t.txn.Commit)
+}
+
+// Rollback discards the transaction's buffered writes. It is safe to call
+// after Commit, so it can be deferred.
+func (t *Txn) Rollback() {
+	t.txn.Rollback()
+}
+
+// Event is a committed put or delete of a single key, as delivered by a
+// Watcher.
+type Event = engine.Event
+
+// EventKind tells whether an Event stored or deleted its key.
+type EventKind = engine.EventKind
+
+// Kinds of Event. Expired keys produce an EventDelete once reaped.
+const (
+	EventPut    = engine.EventPut
+	EventDelete = engine.EventDelete
+)
+
+// Watcher delivers the committed writes of the keys under a prefix in
+// sequence order, without gaps or duplicates:
+//
+//	w, err := db.Watch("user:", aetherkv.WatchFrom(checkpoint))
+//	...
+//	defer w.Close()
+//	for ev := range w.Events() {
+//		apply(ev)
+//		checkpoint = ev.Seq
+//	}
+//	if errors.Is(w.Err(), aetherkv.ErrWatchLagged) {
+//		// Watch again from checkpoint
+//	}
+//
+// A Watcher must be closed once it is no longer needed. Closing the DB ends
+// every watch with ErrClosed.
+type Watcher struct {
+	watcher *engine.Watcher
+}
+
+// Events returns the channel events are delivered on. It is closed once the
+// watch ends, after which Err reports why.
+func (w *Watcher) Events() <-chan Event {
+	return w.watcher.Events()
+}
+
+// Err returns why the watch ended: ErrWatchLagged, ErrWatchCompacted,
+// ErrClosed or nil after Close. It must only be called once the events
+// channel has been closed.
+func (w *Watcher) Err() error {
+	return w.watcher.Err()
+}
+
+// Close ends the watch and closes its events channel. It is safe to call
+// more than once.
+func (w *Watcher) Close() {
+	w.watcher.Close()
+}
+
+// Iterator walks a range of keys in ascending order, reading values only
+// when asked. An Iterator over a DB may or may not see keys written after
+// it was created; one over a Snapshot always sees the snapshot's contents.
+// An Iterator is not safe for concurrent use.
+//
+//	it := db.ScanPrefix("user:")
+//	defer it.Close()
+//	for it.Next() {
+//		value, err := it.Value()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	db  *DB
+	it  *engine.Iterator
+	err error
+}
+
+// Next advances to the next key. Returns false once the range is exhausted,
+// the iterator is closed or an error occurred.
+func (it *Iterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	return it.it.Next()
+}
+
+// Key returns the current key. It must only be called after Next returned true.
+func (it *Iterator) Key() string {
+	return it.it.Key()
+}
+
+// Value reads the value of the current key. Returns ErrNotFound if a DB
+// iterator's key was deleted or expired after it was scanned.
+func (it *Iterator) Value() (string, error) {
+	var value string
+	err := it.db.do(func() (err error) {
+		value, err = it.it.Value()
+		return releasedError(err)
+	})
+	return value, err
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator. Next returns false afterwards.
+func (it *Iterator) Close() {
+	if it.it != nil {
+		it.it.Close()
+	}
+}
+
+// Snapshot is a view of every live key as of a sequence number, unaffected
+// by later writes, deletes and expiries. Taking one copies nothing, and
+// writers are never blocked by it. It is safe for concurrent use and must
+// be released once it is no longer needed.
+type Snapshot struct {
+	db       *DB
+	snapshot *engine.Snapshot
+}
+
+// Sequence returns the sequence number the snapshot reads at.
+func (s *Snapshot) Sequence() uint64 {
+	return s.snapshot.Seq()
+}
+
+// Get returns the value key had as of the snapshot's sequence number. Returns
+// ErrNotFound if it was not live then and ErrClosed once the snapshot or
+// its DB has been closed.
+func (s *Snapshot) Get(key string) (string, error) {
+	var value string
+	err := s.db.do(func() (err error) {
+		value, err = s.snapshot.Get(key)
+		return releasedError(err)
+	})
+	return value, err
+}
+
+// Len returns the number of keys in the snapshot. It counts them one by
+// one, so it takes time proportional to their number.
+func (s *Snapshot) Len() int {
+	return s.snapshot.Len()
+}
+
+// Scan returns an iterator over the snapshot's keys in [start, end) in
+// ascending order. An empty end means no upper bound.
+func (s *Snapshot) Scan(start, end string) *Iterator {
+	return &Iterator{db: s.db, it: s.snapshot.Scan(start, end)}
+}
+
+// ScanPrefix returns an iterator over the snapshot's keys starting with
+// prefix in ascending order.
+func (s *Snapshot) ScanPrefix(prefix string) *Iterator {
+	return &Iterator{db: s.db, it: s.snapshot.ScanPrefix(prefix)}
+}
+
+// Release frees the values kept only for the snapshot so Merge may rewrite
+// the files holding them.
+// Reads from it fail with ErrClosed afterwards. It is safe to call more
+// than once.
+func (s *Snapshot) Release() {
+	s.snapshot.Release()
+}
+
+// releasedError reports reads from a released snapshot as ErrClosed.
+func releasedError(err error) error {
+	if errors.Is(err, engine.ErrSnapshotReleased) {
+		return fmt.Errorf("snapshot released: %w", ErrClosed)
+	}
+	return err
+}
@@ -0,0 +1,482 @@
+package aetherkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestDB opens a database in a temporary directory and closes it when
+// the test ends.
+func openTestDB(t *testing.T, opts ...Option) *DB {
+	t.Helper()
+	db, err := Open(t.TempDir(), opts...)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestOpen_Options(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "valid options", opts: []Option{
+			WithBufferSize(1 << 20),
+			WithSyncMode(SyncBatch),
+			WithSyncInterval(time.Second),
+			WithMaxFileSize(1 << 10),
+			WithMaxFileAge(time.Hour),
+			WithMergeThreshold(1 << 20),
+			WithReapInterval(0),
+			WithMmap(true),
+			WithCacheSize(1 << 20),
+		}},
+		{name: "unknown sync mode", opts: []Option{WithSyncMode(SyncMode(42))}, wantErr: true},
+		{name: "zero buffer size", opts: []Option{WithBufferSize(0)}, wantErr: true},
+		{name: "sync interval below a second", opts: []Option{WithSyncInterval(time.Millisecond)}, wantErr: true},
+		{name: "negative max file size", opts: []Option{WithMaxFileSize(-1)}, wantErr: true},
+		{name: "max file size above 4 GiB", opts: []Option{WithMaxFileSize(1 << 32)}, wantErr: true},
+		{name: "negative cache size", opts: []Option{WithCacheSize(-1)}, wantErr: true},
+		{name: "negative reap interval", opts: []Option{WithReapInterval(-time.Second)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(t.TempDir(), tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if db != nil {
+				db.Close()
+			}
+		})
+	}
+
+	if _, err := Open(""); err == nil {
+		t.Error("Open() with empty directory succeeded, want error")
+	}
+}
+
+func TestOpen_Locked(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if second, err := Open(dir); !errors.Is(err, ErrLocked) {
+		if second != nil {
+			second.Close()
+		}
+		t.Fatalf("second Open() error = %v, want %v", err, ErrLocked)
+	}
+
+	// Closing releases the directory for the next opener
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() after Close() error = %v", err)
+	}
+	reopened.Close()
+}
+
+func TestDB_Operations(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if err := db.Put("user:1", "alice"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := db.PutWithTTL("session", "token", time.Hour); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+
+	var batch Batch
+	batch.Put("user:2", "bob")
+	batch.Put("user:3", "carol")
+	batch.Delete("user:1")
+	if err := db.Write(&batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if _, err := db.Get("user:1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(user:1) error = %v, want %v", err, ErrNotFound)
+	}
+	if ttl, err := db.TTL("session"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL(session) = %v, %v, want at most an hour", ttl, err)
+	}
+	if ttl, err := db.TTL("user:2"); err != nil || ttl != NoTTL {
+		t.Errorf("TTL(user:2) = %v, %v, want NoTTL", ttl, err)
+	}
+
+	it := db.ScanPrefix("user:")
+	var got []string
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			t.Fatalf("Value() error = %v", err)
+		}
+		got = append(got, it.Key()+"="+value)
+	}
+	it.Close()
+	if want := []string{"user:2=bob", "user:3=carol"}; it.Err() != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanPrefix(user:) = %v, %v, want %v", got, it.Err(), want)
+	}
+
+	// Data survives reopening the directory
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer db.Close()
+	if value, err := db.Get("user:3"); err != nil || value != "carol" {
+		t.Errorf("Get(user:3) after reopen = %q, %v, want carol", value, err)
+	}
+	if stats, err := db.Stats(); err != nil || stats.Keys != 3 || stats.IndexBytes <= 0 {
+		t.Errorf("Stats() = %+v, %v, want 3 keys held in memory", stats, err)
+	}
+}
+
+func TestDB_Snapshot(t *testing.T) {
+	db := openTestDB(t)
+
+	for i := 0; i < 3; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), "old"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	snapshot, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatalf("NewSnapshot() error = %v", err)
+	}
+	if err := db.Put("key0", "new"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if value, err := snapshot.Get("key0"); err != nil || value != "old" {
+		t.Errorf("snapshot Get(key0) = %q, %v, want old", value, err)
+	}
+
+	snapshot.Release()
+	if _, err := snapshot.Get("key0"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get() after Release error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestDB_ReadAtSequence(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Put("report", "draft"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	snapshot, err := db.NewSnapshot()
+	if err != nil {
+		t.Fatalf("NewSnapshot() error = %v", err)
+	}
+	defer snapshot.Release()
+	draft := snapshot.Sequence()
+
+	if err := db.Put("report", "final"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if value, err := db.Get("report", AtSequence(draft)); err != nil || value != "draft" {
+		t.Errorf("Get(report, AtSequence(%d)) = %q, %v, want draft", draft, value, err)
+	}
+	if value, err := db.Get("report"); err != nil || value != "final" {
+		t.Errorf("Get(report) = %q, %v, want final", value, err)
+	}
+
+	last, err := db.LastSequence()
+	if err != nil {
+		t.Fatalf("LastSequence() error = %v", err)
+	}
+	if _, err := db.Get("report", AtSequence(last+1)); !errors.Is(err, ErrSequenceUnavailable) {
+		t.Errorf("Get() ahead of the last write error = %v, want %v", err, ErrSequenceUnavailable)
+	}
+	at, err := db.NewSnapshotAt(draft)
+	if err != nil {
+		t.Fatalf("NewSnapshotAt(%d) error = %v", draft, err)
+	}
+	defer at.Release()
+	if value, err := at.Get("report"); err != nil || value != "draft" {
+		t.Errorf("snapshot at %d Get(report) = %q, %v, want draft", draft, value, err)
+	}
+	if stats, err := db.Stats(); err != nil || stats.Snapshots != 2 || stats.Retained != 1 {
+		t.Errorf("Stats() = %+v, %v, want 2 snapshots retaining 1 value", stats, err)
+	}
+}
+
+func TestDB_Cache(t *testing.T) {
+	db := openTestDB(t, WithCacheSize(1<<20))
+
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if value, err := db.Get("key"); err != nil || value != "value" {
+			t.Fatalf("Get(key) = %q, %v, want value", value, err)
+		}
+	}
+	if stats, err := db.Stats(); err != nil || stats.CacheHits != 2 || stats.CacheMisses != 1 {
+		t.Errorf("Stats() = %+v, %v, want 2 cache hits and 1 miss", stats, err)
+	}
+}
+
+func TestDB_Bytes(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+
+	key, value := []byte("k\x00\xff"), []byte{0, '\r', '\n', 0xff}
+	if err := db.PutBytes(ctx, key, value, PutOptions{TTL: time.Hour}); err != nil {
+		t.Fatalf("PutBytes() error = %v", err)
+	}
+	if got, err := db.GetBytes(ctx, key); err != nil || !bytes.Equal(got, value) {
+		t.Errorf("GetBytes() = %v, %v, want %v", got, err, value)
+	}
+
+	var batch Batch
+	batch.Put("other", "value")
+	expired, cancel := context.WithTimeout(ctx, -time.Second)
+	defer cancel()
+	if err := db.WriteContext(expired, &batch); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WriteContext() with expired context error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := db.Get("other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(other) after abandoned write error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := db.DeleteBytes(ctx, key); err != nil {
+		t.Fatalf("DeleteBytes() error = %v", err)
+	}
+	if _, err := db.GetBytes(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetBytes() after DeleteBytes() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDB_ConditionalWrites(t *testing.T) {
+	db := openTestDB(t)
+
+	if ok, err := db.PutIfAbsent("key", "first"); err != nil || !ok {
+		t.Fatalf("PutIfAbsent() = %v, %v, want true", ok, err)
+	}
+	_, version, err := db.GetVersion("key")
+	if err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+	if err := db.Put("key", "second"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if ok, err := db.CompareAndSwap("key", version, "lost"); err != nil || ok {
+		t.Errorf("CompareAndSwap() after concurrent Put = %v, %v, want false", ok, err)
+	}
+	value, version, err := db.GetVersion("key")
+	if err != nil || value != "second" {
+		t.Fatalf("GetVersion() = %q, %v, want second", value, err)
+	}
+	if ok, err := db.CompareAndSwap("key", version, "third"); err != nil || !ok {
+		t.Errorf("CompareAndSwap() = %v, %v, want true", ok, err)
+	}
+	if ok, err := db.DeleteIfVersion("key", version); err != nil || ok {
+		t.Errorf("DeleteIfVersion() with replaced version = %v, %v, want false", ok, err)
+	}
+	if value, err := db.Get("key"); err != nil || value != "third" {
+		t.Errorf("Get(key) = %q, %v, want third", value, err)
+	}
+}
+
+func TestDB_Sequence(t *testing.T) {
+	db := openTestDB(t)
+
+	start := time.Now()
+	if err := db.Put("first", "1"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := db.Put("second", "2"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	first, err := db.GetEntry("first")
+	if err != nil {
+		t.Fatalf("GetEntry(first) error = %v", err)
+	}
+	second, err := db.GetEntry("second")
+	if err != nil {
+		t.Fatalf("GetEntry(second) error = %v", err)
+	}
+	if first.Seq >= second.Seq || first.Time.Before(start.Truncate(time.Second)) || second.Time.Before(first.Time) {
+		t.Errorf("GetEntry() = %+v, %+v, want the first write ordered before the second", first, second)
+	}
+	if seq, err := db.LastSequence(); err != nil || seq != second.Seq {
+		t.Errorf("LastSequence() = %d, %v, want %d", seq, err, second.Seq)
+	}
+	if stats, err := db.Stats(); err != nil || stats.LastSequence != second.Seq {
+		t.Errorf("Stats().LastSequence = %d, %v, want %d", stats.LastSequence, err, second.Seq)
+	}
+}
+
+func TestDB_Txn(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Put("stock", "1"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	reserve := func(order string) (*Txn, error) {
+		txn, err := db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		if stock, err := txn.Get("stock"); err != nil || stock != "1" {
+			return nil, fmt.Errorf("Get(stock) = %q, %v", stock, err)
+		}
+		txn.Put("stock", "0")
+		txn.Put(order, "reserved")
+		return txn, nil
+	}
+
+	first, err := reserve("order:1")
+	if err != nil {
+		t.Fatalf("reserve(order:1) error = %v", err)
+	}
+	second, err := reserve("order:2")
+	if err != nil {
+		t.Fatalf("reserve(order:2) error = %v", err)
+	}
+	if err := first.Commit(); err != nil {
+		t.Fatalf("first Commit() error = %v", err)
+	}
+	if err := second.Commit(); !errors.Is(err, ErrConflict) {
+		t.Errorf("second Commit() error = %v, want %v", err, ErrConflict)
+	}
+	if _, err := db.Get("order:2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(order:2) after conflict error = %v, want %v", err, ErrNotFound)
+	}
+	if err := second.Commit(); !errors.Is(err, ErrTxnDone) {
+		t.Errorf("Commit() twice error = %v, want %v", err, ErrTxnDone)
+	}
+}
+
+func TestDB_Watch(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Put("user:1", "before"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	w, err := db.Watch("user:")
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+	if err := db.Put("order:1", "ignored"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := db.Delete("user:1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	ev := <-w.Events()
+	if ev.Kind != EventDelete || ev.Key != "user:1" || ev.Seq != 3 {
+		t.Errorf("first event = %+v, want delete of user:1 at sequence 3", ev)
+	}
+
+	// Resuming replays what was written after the position
+	resumed, err := db.Watch("", WatchFrom(1))
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer resumed.Close()
+	for _, want := range []string{"order:1", "user:1"} {
+		if ev := <-resumed.Events(); ev.Key != want {
+			t.Errorf("replayed event = %+v, want key %s", ev, want)
+		}
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for range w.Events() {
+	}
+	if err := w.Err(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Err() after Close() = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestDB_Backup(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	dir := filepath.Join(t.TempDir(), "backup")
+	if err := db.Backup(dir); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	if err := VerifyBackup(dir); err != nil {
+		t.Fatalf("VerifyBackup() error = %v", err)
+	}
+
+	backup, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() of backup error = %v", err)
+	}
+	defer backup.Close()
+	if got, err := backup.Get("key"); err != nil || got != "value" {
+		t.Errorf("Get(key) from backup = %q, %v, want value", got, err)
+	}
+
+	restoredDir := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(dir, restoredDir, RestorePoint{Seq: 1}); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	restored, err := Open(restoredDir)
+	if err != nil {
+		t.Fatalf("Open() of restored directory error = %v", err)
+	}
+	defer restored.Close()
+	if seq, err := restored.LastSequence(); err != nil || seq != 1 {
+		t.Errorf("LastSequence() of restored directory = %d, %v, want 1", seq, err)
+	}
+}
+
+func TestDB_Errors(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := db.Put(strings.Repeat("k", MaxKeySize+1), "value"); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("Put() with oversized key error = %v, want %v", err, ErrKeyTooLarge)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := db.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close() error = %v, want %v", err, ErrClosed)
+	}
+	if _, err := db.Get("key"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get() after Close error = %v, want %v", err, ErrClosed)
+	}
+	if err := db.Put("key", "value"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Put() after Close error = %v, want %v", err, ErrClosed)
+	}
+	if it := db.Scan("", ""); it.Next() || !errors.Is(it.Err(), ErrClosed) {
+		t.Errorf("Scan() after Close err = %v, want %v", it.Err(), ErrClosed)
+	}
+}
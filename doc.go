@@ -0,0 +1,44 @@
+// Package aetherkv is an embeddable, persistent key-value store built on an
+// append-only log with an in-memory ordered index, in the style of Bitcask.
+//
+// A database is a directory opened with Open and configured with functional
+// options:
+//
+//	db, err := aetherkv.Open("data", aetherkv.WithMaxFileSize(128<<20))
+//	if err != nil {
+//		return err
+//	}
+//	defer db.Close()
+//
+//	if err := db.Put("user:42", "John Doe"); err != nil {
+//		return err
+//	}
+//	value, err := db.Get("user:42")
+//	if errors.Is(err, aetherkv.ErrNotFound) {
+//		// handle a missing key
+//	}
+//
+// A DB is safe for concurrent use by multiple goroutines. Only one DB may
+// have a directory open at a time: Open holds an exclusive lock on a LOCK
+// file in the directory until Close, and fails with ErrLocked while another
+// DB, in this or any other process, holds it. The lock relies on flock and
+// is not taken on platforms without it.
+//
+// # Errors
+//
+// Errors returned by this package wrap the sentinel errors declared here
+// (ErrNotFound, ErrClosed, ErrKeyTooLarge, ...) and must be tested with
+// errors.Is. The text of an error message is not part of the API.
+//
+// # Compatibility
+//
+// This package follows semantic versioning. Within a major version, exported
+// identifiers are not removed or changed incompatibly; new functions,
+// options, methods and struct fields may be added. The packages under
+// internal/ carry no such promise and cannot be imported by other modules.
+//
+// The on-disk format is versioned with the module as well: a directory
+// written by one release can be opened by any later release of the same
+// major version. Opening a directory with an older release than the one that
+// last wrote it is not supported.
+package aetherkv
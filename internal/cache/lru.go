@@ -0,0 +1,133 @@
+// Package cache provides a size-bounded cache of decoded values for the
+// storage engine's read path.
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// entryOverhead approximates the bytes an entry costs beyond its value:
+// the list element, the map slot and the location key.
+const entryOverhead = 64
+
+// Location identifies a record by the log file and offset it was written
+// at. A record at a location never changes while its segment exists, so a
+// cached value only goes stale when a merge replaces the segment.
+type Location struct {
+	FileId uint32
+	Offset int64
+}
+
+// entry is a cached value and its location, stored in the LRU list.
+type entry struct {
+	loc   Location
+	value string
+}
+
+// Stats counts cache activity since the cache was created.
+type Stats struct {
+	Hits      uint64 `json:"hits"`      // Lookups served from the cache
+	Misses    uint64 `json:"misses"`    // Lookups that had to read from disk
+	Evictions uint64 `json:"evictions"` // Values dropped to stay within the size limit
+	Entries   int    `json:"entries"`   // Values currently cached
+	Bytes     int64  `json:"bytes"`     // Approximate memory held by cached values
+}
+
+// LRU is a least-recently-used cache of values keyed by Location and
+// bounded by the approximate memory its entries hold. It is safe for
+// concurrent use.
+type LRU struct {
+	mu       sync.Mutex
+	capacity int64                      // Upper bound of size in bytes
+	size     int64                      // Approximate bytes held by entries
+	order    *list.List                 // Entries, most recently used first
+	items    map[Location]*list.Element // Entry of each cached location
+	stats    Stats
+}
+
+// NewLRU creates a cache holding at most capacity bytes of values.
+func NewLRU(capacity int64) *LRU {
+	return &LRU{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[Location]*list.Element),
+	}
+}
+
+// Get returns the value cached for loc and marks it as recently used.
+func (c *LRU) Get(loc Location) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[loc]
+	if !ok {
+		c.stats.Misses++
+		return "", false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*entry).value, true
+}
+
+// Add caches value for loc, evicting the least recently used values until
+// the cache fits its capacity. Values larger than the whole cache are not
+// cached.
+func (c *LRU) Add(loc Location, value string) {
+	cost := int64(len(value)) + entryOverhead
+	if cost > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[loc]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[loc] = c.order.PushFront(&entry{loc: loc, value: value})
+	c.size += cost
+
+	for c.size > c.capacity {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// RemoveFiles drops every value cached for the given log files. It must be
+// called when a merge replaces segments, since the merged output reuses
+// their file ids.
+func (c *LRU) RemoveFiles(fileIds []uint32) {
+	drop := make(map[uint32]bool, len(fileIds))
+	for _, id := range fileIds {
+		drop[id] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for loc, elem := range c.items {
+		if drop[loc.FileId] {
+			c.remove(elem)
+		}
+	}
+}
+
+// Stats returns the cache's counters and current size.
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.items)
+	stats.Bytes = c.size
+	return stats
+}
+
+// remove drops a cached entry. Caller must hold c.mu.
+func (c *LRU) remove(elem *list.Element) {
+	e := c.order.Remove(elem).(*entry)
+	delete(c.items, e.loc)
+	c.size -= int64(len(e.value)) + entryOverhead
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLRU_GetAndAdd(t *testing.T) {
+	c := NewLRU(1 << 10)
+	loc := Location{FileId: 1, Offset: 42}
+
+	if _, ok := c.Get(loc); ok {
+		t.Fatal("Get() on empty cache succeeded")
+	}
+	c.Add(loc, "value")
+	if got, ok := c.Get(loc); !ok || got != "value" {
+		t.Errorf("Get() = %q, %v, want value", got, ok)
+	}
+	if got, ok := c.Get(Location{FileId: 2, Offset: 42}); ok {
+		t.Errorf("Get() for another file = %q, want miss", got)
+	}
+
+	stats := c.Stats()
+	want := Stats{Hits: 1, Misses: 2, Entries: 1, Bytes: int64(len("value")) + entryOverhead}
+	if stats != want {
+		t.Errorf("Stats() = %+v, want %+v", stats, want)
+	}
+}
+
+func TestLRU_Eviction(t *testing.T) {
+	value := strings.Repeat("v", 36)
+	c := NewLRU(3 * (36 + entryOverhead))
+
+	for i := int64(0); i < 3; i++ {
+		c.Add(Location{Offset: i}, value)
+	}
+	// Offset 0 becomes the most recently used, leaving offset 1 to be evicted
+	c.Get(Location{Offset: 0})
+	c.Add(Location{Offset: 3}, value)
+
+	for offset, want := range []bool{true, false, true, true} {
+		if _, ok := c.Get(Location{Offset: int64(offset)}); ok != want {
+			t.Errorf("Get(offset %d) cached = %v, want %v", offset, ok, want)
+		}
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Entries != 3 {
+		t.Errorf("Stats() = %+v, want 1 eviction and 3 entries", stats)
+	}
+
+	// A value larger than the whole cache is not cached
+	c.Add(Location{Offset: 4}, strings.Repeat("v", 1<<10))
+	if _, ok := c.Get(Location{Offset: 4}); ok {
+		t.Error("Get() returned a value larger than the cache")
+	}
+}
+
+func TestLRU_RemoveFiles(t *testing.T) {
+	c := NewLRU(1 << 10)
+	for id := uint32(1); id <= 3; id++ {
+		c.Add(Location{FileId: id}, "value")
+	}
+
+	c.RemoveFiles([]uint32{1, 3})
+	for id, want := range map[uint32]bool{1: false, 2: true, 3: false} {
+		if _, ok := c.Get(Location{FileId: id}); ok != want {
+			t.Errorf("Get(file %d) cached = %v, want %v", id, ok, want)
+		}
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.Bytes != int64(len("value"))+entryOverhead {
+		t.Errorf("Stats() = %+v, want 1 entry", stats)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jassi-singh/aether-kv/internal/engine"
 )
@@ -30,7 +32,7 @@ func NewHandler(e engine.Engine) *Handler {
 // an exit command is received or an error occurs.
 func (h *Handler) Run() error {
 	fmt.Println("Aether KV - Simple Key-Value Store")
-	fmt.Println("Commands: PUT <key> <value>, GET <key>, DELETE <key>, EXIT")
+	fmt.Println("Commands: PUT <key> <value>, PUTEX <key> <seconds> <value>, GET <key>, DELETE <key>, SCAN [prefix], MERGE, BACKUP <dir>, EXIT")
 	fmt.Print("> ")
 
 	for h.scanner.Scan() {
@@ -53,6 +55,10 @@ func (h *Handler) Run() error {
 			if err := h.handlePut(parts); err != nil {
 				return err
 			}
+		case "PUTEX":
+			if err := h.handlePutEx(parts); err != nil {
+				return err
+			}
 		case "GET":
 			if err := h.handleGet(parts); err != nil {
 				return err
@@ -61,6 +67,18 @@ func (h *Handler) Run() error {
 			if err := h.handleDelete(parts); err != nil {
 				return err
 			}
+		case "SCAN":
+			if err := h.handleScan(parts); err != nil {
+				return err
+			}
+		case "MERGE":
+			if err := h.handleMerge(); err != nil {
+				return err
+			}
+		case "BACKUP":
+			if err := h.handleBackup(parts); err != nil {
+				return err
+			}
 		case "EXIT", "QUIT":
 			slog.Info("cli: shutdown requested by user")
 			fmt.Println("Goodbye!")
@@ -69,7 +87,7 @@ func (h *Handler) Run() error {
 			slog.Warn("cli: unknown command received",
 				"command", command)
 			fmt.Printf("Unknown command: %s\n", command)
-			fmt.Println("Commands: PUT <key> <value>, GET <key>, DELETE <key>, EXIT")
+			fmt.Println("Commands: PUT <key> <value>, PUTEX <key> <seconds> <value>, GET <key>, DELETE <key>, SCAN [prefix], MERGE, BACKUP <dir>, EXIT")
 		}
 
 		fmt.Print("> ")
@@ -110,6 +128,43 @@ func (h *Handler) handlePut(parts []string) error {
 	return nil
 }
 
+// handlePutEx processes PUTEX commands to store key-value pairs that expire
+// after the given number of seconds.
+func (h *Handler) handlePutEx(parts []string) error {
+	if len(parts) < 4 {
+		slog.Warn("cli: invalid PUTEX command - missing arguments")
+		fmt.Println("Usage: PUTEX <key> <seconds> <value>")
+		return nil
+	}
+
+	key := parts[1]
+	seconds, err := strconv.Atoi(parts[2])
+	if err != nil || seconds <= 0 {
+		slog.Warn("cli: invalid PUTEX command - bad ttl",
+			"ttl", parts[2])
+		fmt.Println("Usage: PUTEX <key> <seconds> <value>")
+		return nil
+	}
+	value := strings.Join(parts[3:], " ")
+
+	slog.Debug("cli: executing PUTEX command",
+		"key", key,
+		"ttl_seconds", seconds,
+		"value_size", len(value))
+
+	if err := h.engine.PutWithTTL(key, value, time.Duration(seconds)*time.Second); err != nil {
+		slog.Error("cli: PUTEX command failed",
+			"key", key,
+			"value_size", len(value),
+			"error", err)
+		fmt.Printf("Error: %v\n", err)
+	} else {
+		fmt.Printf("OK\n")
+	}
+
+	return nil
+}
+
 // handleGet processes GET commands to retrieve values by key.
 func (h *Handler) handleGet(parts []string) error {
 	if len(parts) < 2 {
@@ -158,3 +213,76 @@ func (h *Handler) handleDelete(parts []string) error {
 
 	return nil
 }
+
+// handleScan processes SCAN commands to list the keys starting with an
+// optional prefix, together with their values, in sorted order.
+func (h *Handler) handleScan(parts []string) error {
+	prefix := ""
+	if len(parts) > 1 {
+		prefix = parts[1]
+	}
+
+	slog.Debug("cli: executing SCAN command",
+		"prefix", prefix)
+
+	it := h.engine.ScanPrefix(prefix)
+	defer it.Close()
+
+	count := 0
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			// Deleted or expired after it was scanned
+			slog.Debug("cli: SCAN skipped key",
+				"key", it.Key(),
+				"error", err)
+			continue
+		}
+		fmt.Printf("%s = %s\n", it.Key(), value)
+		count++
+	}
+	fmt.Printf("(%d keys)\n", count)
+
+	return nil
+}
+
+// handleMerge processes MERGE commands to compact the sealed log segments.
+func (h *Handler) handleMerge() error {
+	slog.Debug("cli: executing MERGE command")
+
+	if err := h.engine.Merge(); err != nil {
+		slog.Error("cli: MERGE command failed",
+			"error", err)
+		fmt.Printf("Error: %v\n", err)
+	} else {
+		fmt.Println("OK")
+	}
+
+	return nil
+}
+
+// handleBackup processes BACKUP commands to write a backup of the running
+// store to a directory.
+func (h *Handler) handleBackup(parts []string) error {
+	if len(parts) < 2 {
+		slog.Warn("cli: invalid BACKUP command - missing directory")
+		fmt.Println("Usage: BACKUP <dir>")
+		return nil
+	}
+
+	dir := parts[1]
+	slog.Debug("cli: executing BACKUP command",
+		"dir", dir)
+
+	manifest, err := h.engine.Backup(dir)
+	if err != nil {
+		slog.Error("cli: BACKUP command failed",
+			"dir", dir,
+			"error", err)
+		fmt.Printf("Error: %v\n", err)
+	} else {
+		fmt.Printf("OK (%d files, up to sequence %d)\n", len(manifest.Files), manifest.LastSeq)
+	}
+
+	return nil
+}
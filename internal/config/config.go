@@ -14,10 +14,20 @@ import (
 
 // Config holds all application configuration values.
 type Config struct {
-	DATA_DIR      string `yaml:"DATA_DIR"`      // Directory where log files are stored
-	HEADER_SIZE   uint32 `yaml:"HEADER_SIZE"`   // Size of record header in bytes
-	BATCH_SIZE    uint32 `yaml:"BATCH_SIZE"`    // Buffer size threshold for auto-flush
-	SYNC_INTERVAL uint32 `yaml:"SYNC_INTERVAL"` // Time interval in seconds for auto-sync
+	DATA_DIR        string `yaml:"DATA_DIR"`        // Directory where log files are stored
+	HEADER_SIZE     uint32 `yaml:"HEADER_SIZE"`     // Size of record header in bytes
+	BATCH_SIZE      uint32 `yaml:"BATCH_SIZE"`      // Buffer size threshold for auto-flush
+	SYNC_INTERVAL   uint32 `yaml:"SYNC_INTERVAL"`   // Time interval in seconds for auto-sync
+	SYNC_MODE       string `yaml:"SYNC_MODE"`       // When writes are fsynced: always, batch, periodic (default) or none
+	MAX_FILE_SIZE   uint32 `yaml:"MAX_FILE_SIZE"`   // Size in bytes at which the active log is rotated (0 disables)
+	MAX_FILE_AGE    uint32 `yaml:"MAX_FILE_AGE"`    // Age in seconds at which the active log is rotated (0 disables)
+	MERGE_THRESHOLD uint32 `yaml:"MERGE_THRESHOLD"` // Dead bytes in sealed segments that trigger a background merge (0 disables)
+	REAP_INTERVAL   uint32 `yaml:"REAP_INTERVAL"`   // Interval in seconds between sweeps that delete expired keys (0 disables)
+	MMAP_SEGMENTS   bool   `yaml:"MMAP_SEGMENTS"`   // Whether sealed segments are memory-mapped for reads
+	CACHE_SIZE      uint32 `yaml:"CACHE_SIZE"`      // Bytes of decoded values kept in the read cache (0 disables)
+	LISTEN_ADDR     string `yaml:"LISTEN_ADDR"`     // TCP address the RESP server listens on in serve mode
+	HTTP_ADDR       string `yaml:"HTTP_ADDR"`       // TCP address the HTTP API listens on in http mode
+	BACKUP_DIR      string `yaml:"BACKUP_DIR"`      // Directory under which BACKUP and POST /admin/backup write named backups (empty disables)
 }
 
 var (
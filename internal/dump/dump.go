@@ -0,0 +1,298 @@
+// Package dump writes the live keys of the storage engine to JSON Lines or
+// CSV and loads such files back. A dump reads a single snapshot, so it is
+// consistent while writes go on. Each key is written with its value, the
+// sequence number and time of the write that stored it and its expiry
+// time; keys and values that are not valid UTF-8 are base64-encoded. Loads
+// go through batched writes and can record their progress in a checkpoint
+// file to resume an interrupted load.
+package dump
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jassi-singh/aether-kv/internal/engine"
+)
+
+// Format is the file format of a dump.
+type Format string
+
+const (
+	JSONL Format = "jsonl" // One JSON object per line
+	CSV   Format = "csv"   // A header row, then one row per key
+)
+
+// EncodingBase64 marks a record whose key and value are base64-encoded.
+const EncodingBase64 = "base64"
+
+// progressInterval is the number of keys between progress reports.
+const progressInterval = 10000
+
+// csvHeader lists the columns of a CSV dump.
+var csvHeader = []string{"key", "value", "encoding", "seq", "time", "expires_at"}
+
+// ParseFormat returns the Format named by s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case JSONL, CSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown dump format %q: must be jsonl or csv", s)
+}
+
+// Record is a key as it appears in a dump.
+type Record struct {
+	Key       string     `json:"key"`
+	Value     string     `json:"value"`
+	Encoding  string     `json:"encoding,omitempty"` // EncodingBase64 if Key and Value are base64-encoded
+	Seq       uint64     `json:"seq"`                // Sequence number of the write in the source store
+	Time      time.Time  `json:"time"`               // Time of the write in the source store
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// newRecord returns the record of key, base64-encoding it and its value if
+// either is not valid UTF-8.
+func newRecord(key string, entry engine.Entry) Record {
+	r := Record{Key: key, Value: entry.Value, Seq: entry.Seq, Time: entry.Time}
+	if !utf8.ValidString(key) || !utf8.ValidString(entry.Value) {
+		r.Key = base64.StdEncoding.EncodeToString([]byte(key))
+		r.Value = base64.StdEncoding.EncodeToString([]byte(entry.Value))
+		r.Encoding = EncodingBase64
+	}
+	if !entry.Expiry.IsZero() {
+		r.ExpiresAt = &entry.Expiry
+	}
+	return r
+}
+
+// decode returns the raw key and value of r.
+func (r Record) decode() (string, string, error) {
+	switch r.Encoding {
+	case "":
+		return r.Key, r.Value, nil
+	case EncodingBase64:
+		key, err := base64.StdEncoding.DecodeString(r.Key)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64 key: %w", err)
+		}
+		value, err := base64.StdEncoding.DecodeString(r.Value)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64 value: %w", err)
+		}
+		return string(key), string(value), nil
+	}
+	return "", "", fmt.Errorf("unknown encoding %q", r.Encoding)
+}
+
+// DumpOptions holds the optional settings of Dump.
+type DumpOptions struct {
+	Prefix   string         // Only dump keys starting with Prefix
+	Progress func(keys int) // Called every few thousand keys and once done, if set
+}
+
+// DumpResult summarizes a completed dump.
+type DumpResult struct {
+	Keys int    // Keys written
+	Seq  uint64 // Sequence number of the snapshot that was dumped
+}
+
+// Dump writes every live key of kv, or those under opts.Prefix, to w in
+// format f in ascending key order. It reads a snapshot taken when it
+// starts, so writes made meanwhile are not included. Keys deleted or
+// expired before the snapshot are skipped.
+func Dump(kv engine.Engine, w io.Writer, f Format, opts DumpOptions) (DumpResult, error) {
+	enc, err := newEncoder(w, f)
+	if err != nil {
+		return DumpResult{}, err
+	}
+
+	snapshot := kv.NewSnapshot()
+	defer snapshot.Release()
+	result := DumpResult{Seq: snapshot.Seq()}
+
+	it := snapshot.ScanPrefix(opts.Prefix)
+	defer it.Close()
+	for it.Next() {
+		key := it.Key()
+		entry, err := snapshot.GetEntry(key)
+		if err != nil {
+			return result, fmt.Errorf("failed to read key %q: %w", key, err)
+		}
+		if err := enc.encode(newRecord(key, entry)); err != nil {
+			return result, fmt.Errorf("failed to write key %q: %w", key, err)
+		}
+		result.Keys++
+		if opts.Progress != nil && result.Keys%progressInterval == 0 {
+			opts.Progress(result.Keys)
+		}
+	}
+	if err := enc.flush(); err != nil {
+		return result, fmt.Errorf("failed to write dump: %w", err)
+	}
+	if opts.Progress != nil {
+		opts.Progress(result.Keys)
+	}
+
+	slog.Info("dump: completed",
+		"format", f,
+		"prefix", opts.Prefix,
+		"keys", result.Keys,
+		"seq", result.Seq)
+	return result, nil
+}
+
+// encoder writes records in a dump format.
+type encoder interface {
+	encode(r Record) error
+	flush() error
+}
+
+// newEncoder returns an encoder writing format f to w.
+func newEncoder(w io.Writer, f Format) (encoder, error) {
+	switch f {
+	case JSONL:
+		return &jsonEncoder{enc: json.NewEncoder(w)}, nil
+	case CSV:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown dump format %q", f)
+}
+
+// jsonEncoder writes one JSON object per line.
+type jsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonEncoder) encode(r Record) error {
+	return e.enc.Encode(r)
+}
+
+func (e *jsonEncoder) flush() error {
+	return nil
+}
+
+// csvEncoder writes a header row followed by one row per record.
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) encode(r Record) error {
+	if !e.header {
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.header = true
+	}
+	expiresAt := ""
+	if r.ExpiresAt != nil {
+		expiresAt = r.ExpiresAt.Format(time.RFC3339Nano)
+	}
+	return e.w.Write([]string{
+		r.Key,
+		r.Value,
+		r.Encoding,
+		strconv.FormatUint(r.Seq, 10),
+		r.Time.Format(time.RFC3339Nano),
+		expiresAt,
+	})
+}
+
+func (e *csvEncoder) flush() error {
+	if !e.header {
+		// An empty dump still has its header, so it loads as empty
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+		e.header = true
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// decoder reads records in a dump format. next returns io.EOF once the
+// input is exhausted.
+type decoder interface {
+	next() (Record, error)
+}
+
+// newDecoder returns a decoder reading format f from r.
+func newDecoder(r io.Reader, f Format) (decoder, error) {
+	switch f {
+	case JSONL:
+		return &jsonDecoder{dec: json.NewDecoder(r)}, nil
+	case CSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(csvHeader)
+		reader.ReuseRecord = true
+		return &csvDecoder{r: reader}, nil
+	}
+	return nil, fmt.Errorf("unknown dump format %q", f)
+}
+
+// jsonDecoder reads one JSON object per line.
+type jsonDecoder struct {
+	dec *json.Decoder
+}
+
+func (d *jsonDecoder) next() (Record, error) {
+	var r Record
+	err := d.dec.Decode(&r)
+	return r, err
+}
+
+// csvDecoder reads the rows following a header row.
+type csvDecoder struct {
+	r      *csv.Reader
+	header bool
+}
+
+func (d *csvDecoder) next() (Record, error) {
+	if !d.header {
+		row, err := d.r.Read()
+		if err != nil {
+			return Record{}, err
+		}
+		if strings.Join(row, ",") != strings.Join(csvHeader, ",") {
+			return Record{}, fmt.Errorf("invalid CSV header %q, want %q", row, csvHeader)
+		}
+		d.header = true
+	}
+
+	row, err := d.r.Read()
+	if err != nil {
+		return Record{}, err
+	}
+	r := Record{Key: row[0], Value: row[1], Encoding: row[2]}
+	if r.Seq, err = strconv.ParseUint(row[3], 10, 64); err != nil {
+		return Record{}, fmt.Errorf("invalid seq %q: %w", row[3], err)
+	}
+	if r.Time, err = time.Parse(time.RFC3339Nano, row[4]); err != nil {
+		return Record{}, fmt.Errorf("invalid time %q: %w", row[4], err)
+	}
+	if row[5] != "" {
+		expiresAt, err := time.Parse(time.RFC3339Nano, row[5])
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid expires_at %q: %w", row[5], err)
+		}
+		r.ExpiresAt = &expiresAt
+	}
+	return r, nil
+}
+
+// errorAt wraps err with the position of the record it occurred at.
+func errorAt(n int, err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("record %d: truncated input: %w", n, err)
+	}
+	return fmt.Errorf("record %d: %w", n, err)
+}
@@ -0,0 +1,172 @@
+package dump
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/config"
+	"github.com/jassi-singh/aether-kv/internal/engine"
+)
+
+// newEngine opens a fresh engine that is closed when the test ends.
+func newEngine(t *testing.T) *engine.KVEngine {
+	t.Helper()
+	cfg := &config.Config{
+		DATA_DIR:      t.TempDir(),
+		HEADER_SIZE:   21,
+		BATCH_SIZE:    4096,
+		SYNC_INTERVAL: 5,
+	}
+	kv, err := engine.NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	t.Cleanup(func() { kv.Close() })
+	return kv
+}
+
+// syncCounter counts the syncs requested from the engine it wraps.
+type syncCounter struct {
+	engine.Engine
+	syncs int
+}
+
+func (s *syncCounter) Sync() error {
+	s.syncs++
+	return s.Engine.Sync()
+}
+
+func TestDumpLoad(t *testing.T) {
+	src := newEngine(t)
+	binary := "\xff\x00raw"
+	if err := src.Put("user:1", "alice, \"the first\"\nline two"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := src.Put("user:2", binary); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := src.PutWithTTL("session:1", "token", time.Hour); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+	if err := src.Put("deleted", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := src.Delete("deleted"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	for _, f := range []Format{JSONL, CSV} {
+		t.Run(string(f), func(t *testing.T) {
+			var buf bytes.Buffer
+			result, err := Dump(src, &buf, f, DumpOptions{})
+			if err != nil {
+				t.Fatalf("Dump() error = %v", err)
+			}
+			if result.Keys != 3 || result.Seq != src.LastSeq() {
+				t.Errorf("Dump() = %+v, want 3 keys at sequence %d", result, src.LastSeq())
+			}
+			if !strings.Contains(buf.String(), EncodingBase64) {
+				t.Errorf("Dump() did not base64-encode a binary value:\n%s", buf.String())
+			}
+
+			dst := newEngine(t)
+			stats, err := Load(dst, &buf, f, LoadOptions{BatchSize: 2})
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if stats.Records != 3 || stats.Loaded != 3 {
+				t.Errorf("Load() = %+v, want 3 records loaded", stats)
+			}
+			for _, key := range []string{"user:1", "user:2", "session:1"} {
+				want, _ := src.Get(key)
+				if got, err := dst.Get(key); err != nil || got != want {
+					t.Errorf("Get(%s) after load = %q, %v, want %q", key, got, err, want)
+				}
+			}
+			if ttl, err := dst.TTL("session:1"); err != nil || ttl <= 0 || ttl > time.Hour {
+				t.Errorf("TTL(session:1) after load = %v, %v, want up to an hour", ttl, err)
+			}
+			if ttl, err := dst.TTL("user:1"); err != nil || ttl != engine.NoTTL {
+				t.Errorf("TTL(user:1) after load = %v, %v, want %v", ttl, err, engine.NoTTL)
+			}
+			if _, err := dst.Get("deleted"); !errors.Is(err, engine.ErrKeyNotFound) {
+				t.Errorf("Get(deleted) after load error = %v, want %v", err, engine.ErrKeyNotFound)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	if result, err := Dump(src, &buf, JSONL, DumpOptions{Prefix: "user:"}); err != nil || result.Keys != 2 {
+		t.Errorf("Dump() with prefix = %+v, %v, want 2 keys", result, err)
+	}
+}
+
+func TestLoad_Expired(t *testing.T) {
+	past := time.Now().Add(-time.Minute).Format(time.RFC3339Nano)
+	input := fmt.Sprintf("key,value,encoding,seq,time,expires_at\n"+
+		"live,value,,1,%[1]s,\n"+
+		"gone,value,,2,%[1]s,%[1]s\n", past)
+
+	kv := newEngine(t)
+	stats, err := Load(kv, strings.NewReader(input), CSV, LoadOptions{})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if stats.Loaded != 1 || stats.Expired != 1 {
+		t.Errorf("Load() = %+v, want 1 loaded and 1 expired", stats)
+	}
+	if _, err := kv.Get("gone"); !errors.Is(err, engine.ErrKeyNotFound) {
+		t.Errorf("Get(gone) error = %v, want %v", err, engine.ErrKeyNotFound)
+	}
+
+	if _, err := Load(kv, strings.NewReader("key,value\n"), CSV, LoadOptions{}); err == nil {
+		t.Errorf("Load() with an invalid header error = nil, want an error")
+	}
+}
+
+func TestLoad_Resume(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&input, "{\"key\":\"key%d\",\"value\":\"v%d\",\"seq\":%d,\"time\":\"2024-01-01T00:00:00Z\"}\n", i, i, i+1)
+	}
+	// A record that cannot be decoded interrupts the first run
+	broken := input.String() + "{\"key\":\"bad\",\"value\":\"!\",\"encoding\":\"base64\"}\n"
+	checkpointPath := filepath.Join(t.TempDir(), "load.checkpoint")
+
+	kv := &syncCounter{Engine: newEngine(t)}
+	var batches int
+	opts := LoadOptions{
+		BatchSize:  4,
+		Checkpoint: checkpointPath,
+		Progress:   func(LoadStats) { batches++ },
+	}
+	if _, err := Load(kv, strings.NewReader(broken), JSONL, opts); err == nil {
+		t.Fatalf("Load() of invalid input error = nil, want an error")
+	}
+	if batches != 2 || kv.syncs != 2 {
+		t.Errorf("Load() reported %d batches and synced %d times before failing, want 2 of each", batches, kv.syncs)
+	}
+	if got, err := readCheckpoint(checkpointPath); err != nil || got != 8 {
+		t.Errorf("readCheckpoint() = %d, %v, want 8", got, err)
+	}
+
+	stats, err := Load(kv, strings.NewReader(input.String()), JSONL, opts)
+	if err != nil {
+		t.Fatalf("Load() resuming error = %v", err)
+	}
+	if stats.Resumed != 8 || stats.Loaded != 2 {
+		t.Errorf("Load() resuming = %+v, want 8 resumed and 2 loaded", stats)
+	}
+	if got := kv.GetKeyDirSize(); got != 10 {
+		t.Errorf("GetKeyDirSize() = %d, want 10", got)
+	}
+	if _, err := os.Stat(checkpointPath); !os.IsNotExist(err) {
+		t.Errorf("checkpoint after a completed load: Stat() error = %v, want not exist", err)
+	}
+}
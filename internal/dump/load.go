@@ -0,0 +1,204 @@
+package dump
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/engine"
+)
+
+// DefaultBatchSize is the number of records Load writes per batch unless
+// told otherwise.
+const DefaultBatchSize = 1000
+
+// LoadOptions holds the optional settings of Load.
+type LoadOptions struct {
+	BatchSize  int               // Records written per batch, DefaultBatchSize if not positive
+	Checkpoint string            // File recording progress to resume from, if set
+	Progress   func(s LoadStats) // Called after every batch, if set
+}
+
+// LoadStats summarizes the progress of a load.
+type LoadStats struct {
+	Records int // Records read from the input, including skipped ones
+	Loaded  int // Keys written by this run
+	Expired int // Records skipped because they had already expired
+	Resumed int // Records skipped because a previous run loaded them
+}
+
+// checkpoint is the progress of a load as recorded in its checkpoint file.
+type checkpoint struct {
+	Records int `json:"records"` // Records of the input handled by committed batches
+}
+
+// Load writes the records read from r in format f to kv in batches of
+// opts.BatchSize, each applied atomically. Keys keep their values and
+// expiry times; records that have expired by the time they are read are
+// skipped. The original sequence numbers and times are not kept, since
+// every write gets a new one.
+//
+// If opts.Checkpoint is set, every batch is synced and the number of
+// records handled is then written to the checkpoint, so it never gets ahead
+// of durable writes whatever the sync mode. A load given an existing
+// checkpoint skips that many records first, so an interrupted load of the
+// same input can be resumed. The checkpoint is removed once the load
+// completes.
+func Load(kv engine.Engine, r io.Reader, f Format, opts LoadOptions) (LoadStats, error) {
+	var stats LoadStats
+	dec, err := newDecoder(r, f)
+	if err != nil {
+		return stats, err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	resume := 0
+	if opts.Checkpoint != "" {
+		if resume, err = readCheckpoint(opts.Checkpoint); err != nil {
+			return stats, err
+		}
+	}
+
+	start := time.Now()
+	batch := engine.NewWriteBatch()
+	for {
+		record, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, errorAt(stats.Records+1, err)
+		}
+		stats.Records++
+		if stats.Records <= resume {
+			stats.Resumed++
+			continue
+		}
+
+		key, value, err := record.decode()
+		if err != nil {
+			return stats, errorAt(stats.Records, err)
+		}
+		if record.ExpiresAt == nil {
+			batch.Put(key, value)
+		} else if ttl := time.Until(*record.ExpiresAt); ttl <= 0 {
+			stats.Expired++
+		} else if err := batch.PutWithTTL(key, value, ttl); err != nil {
+			return stats, errorAt(stats.Records, err)
+		}
+
+		if batch.Len() >= batchSize {
+			if err := flushBatch(kv, batch, &stats, opts); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := flushBatch(kv, batch, &stats, opts); err != nil {
+		return stats, err
+	}
+	if stats.Records < resume {
+		return stats, fmt.Errorf("checkpoint %s is past the end of the input at record %d, want at least %d records",
+			opts.Checkpoint, stats.Records, resume)
+	}
+
+	if opts.Checkpoint != "" {
+		if err := os.Remove(opts.Checkpoint); err != nil && !os.IsNotExist(err) {
+			return stats, fmt.Errorf("failed to remove checkpoint %s: %w", opts.Checkpoint, err)
+		}
+	}
+
+	slog.Info("load: completed",
+		"format", f,
+		"records", stats.Records,
+		"loaded", stats.Loaded,
+		"expired", stats.Expired,
+		"resumed", stats.Resumed,
+		"duration", time.Since(start))
+	return stats, nil
+}
+
+// flushBatch writes batch to kv, makes it durable and records the progress
+// in the checkpoint if there is one, and empties the batch. A batch holding
+// only skipped records still moves the checkpoint.
+func flushBatch(kv engine.Engine, batch *engine.WriteBatch, stats *LoadStats, opts LoadOptions) error {
+	if batch.Len() > 0 {
+		if err := kv.Write(batch); err != nil {
+			return fmt.Errorf("failed to write batch ending at record %d: %w", stats.Records, err)
+		}
+		stats.Loaded += batch.Len()
+		batch.Reset()
+	}
+	if opts.Checkpoint != "" {
+		// A checkpoint past writes lost in a crash would skip them on resume
+		if err := kv.Sync(); err != nil {
+			return fmt.Errorf("failed to sync batch ending at record %d: %w", stats.Records, err)
+		}
+		if err := writeCheckpoint(opts.Checkpoint, checkpoint{Records: stats.Records}); err != nil {
+			return err
+		}
+	}
+	if opts.Progress != nil {
+		opts.Progress(*stats)
+	}
+	return nil
+}
+
+// readCheckpoint returns the number of records handled according to the
+// checkpoint at path, or 0 if there is none.
+func readCheckpoint(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read checkpoint %s: %w", path, err)
+	}
+	var c checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return 0, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	if c.Records < 0 {
+		return 0, fmt.Errorf("invalid checkpoint %s: negative record count %d", path, c.Records)
+	}
+
+	slog.Info("load: resuming from checkpoint",
+		"checkpoint", path,
+		"records", c.Records)
+	return c.Records, nil
+}
+
+// writeCheckpoint replaces the checkpoint at path via a synced temporary
+// file, so a crash leaves either the old or the new checkpoint.
+func writeCheckpoint(path string, c checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint %s: %w", tmp, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write checkpoint %s: %w", tmp, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync checkpoint %s: %w", tmp, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close checkpoint %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to install checkpoint %s: %w", path, err)
+	}
+	return nil
+}
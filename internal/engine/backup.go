@@ -0,0 +1,276 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+// ErrBackupCorrupt is returned by VerifyBackup when a file of a backup is
+// missing or does not match the size and checksum in its manifest.
+var ErrBackupCorrupt = errors.New("backup corrupt")
+
+// ErrBackupDirNotEmpty is returned by Backup and Restore when the target
+// directory already holds files.
+var ErrBackupDirNotEmpty = errors.New("directory not empty")
+
+// ErrInvalidBackupName is returned by BackupPath for a name that is not a
+// single plain path element.
+var ErrInvalidBackupName = errors.New("invalid backup name")
+
+const (
+	// BackupManifestName is the file describing the contents of a backup.
+	BackupManifestName = "backup.json"
+	// backupFormat is the version of the manifest layout.
+	backupFormat = 1
+)
+
+// BackupManifest lists the files of a backup with their checksums, so a
+// backup can be verified on its own.
+type BackupManifest struct {
+	Format    int          `json:"format"`
+	CreatedAt time.Time    `json:"created_at"`
+	LastSeq   uint64       `json:"last_seq"` // Sequence number of the last write in the backup
+	Files     []BackupFile `json:"files"`
+}
+
+// BackupFile describes a single file of a backup.
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	Linked bool   `json:"linked"` // Hard-linked to the data directory rather than copied
+}
+
+// Backup writes a consistent copy of the database to dstDir, which must not
+// exist or be empty, while reads and writes go on. Buffered writes are
+// flushed first and the copy ends with the last complete write, so dstDir
+// can be opened as a data directory of its own. Sealed segments and their
+// hint files never change, so they are hard-linked where the file system
+// allows it and copied otherwise; the active log is copied up to its size
+// at the time of the backup. Merges and hint writers wait until the backup
+// is done. A manifest with the size and SHA-256 of every file is written
+// last, so a backup without one is incomplete.
+func (e *KVEngine) Backup(dstDir string) (*BackupManifest, error) {
+	if e.closed.Load() {
+		return nil, ErrClosed
+	}
+	if err := createEmptyDir(dstDir); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	e.sealMu.Lock()
+	defer e.sealMu.Unlock()
+
+	// Appends stop while the active file is flushed, so it ends with a
+	// commit marker and every write up to seq is in it or a sealed segment
+	e.writeMu.Lock()
+	active, activeId, activeSize, err := e.file.OpenActive()
+	segments := e.file.Segments()
+	seq := e.visibleSeq.Load()
+	e.writeMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open active file: %w", err)
+	}
+	defer active.Close()
+
+	manifest := &BackupManifest{
+		Format:    backupFormat,
+		CreatedAt: start,
+		LastSeq:   seq,
+	}
+	for _, fileId := range segments {
+		if fileId == activeId {
+			continue
+		}
+		file, err := backupLink(e.cfg.DATA_DIR, dstDir, storage.SegmentFileName(fileId))
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, file)
+
+		// A hint not written yet is rebuilt by scanning the segment
+		file, err = backupLink(e.cfg.DATA_DIR, dstDir, storage.HintFileName(fileId))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	file, err := backupCopy(io.NewSectionReader(active, 0, activeSize), dstDir, storage.ActiveFileName)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Files = append(manifest.Files, file)
+
+	if err := writeManifest(dstDir, manifest); err != nil {
+		return nil, err
+	}
+
+	slog.Info("backup: completed",
+		"dir", dstDir,
+		"files", len(manifest.Files),
+		"last_seq", seq,
+		"duration", time.Since(start))
+	return manifest, nil
+}
+
+// VerifyBackup checks every file listed in the manifest of the backup in
+// dir against its recorded size and checksum. Returns the manifest, or an
+// error wrapping ErrBackupCorrupt on the first mismatch.
+func VerifyBackup(dir string) (*BackupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, BackupManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w: %v", ErrBackupCorrupt, err)
+	}
+	if manifest.Format != backupFormat {
+		return nil, fmt.Errorf("unsupported backup format %d", manifest.Format)
+	}
+
+	for _, want := range manifest.Files {
+		f, err := os.Open(filepath.Join(dir, want.Name))
+		if err != nil {
+			return nil, fmt.Errorf("backup file %s: %w: %v", want.Name, ErrBackupCorrupt, err)
+		}
+		size, sum, err := checksum(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup file %s: %w", want.Name, err)
+		}
+		if size != want.Size || sum != want.SHA256 {
+			return nil, fmt.Errorf("backup file %s has %d bytes with checksum %s, want %d bytes with checksum %s: %w",
+				want.Name, size, sum, want.Size, want.SHA256, ErrBackupCorrupt)
+		}
+	}
+	return &manifest, nil
+}
+
+// BackupPath returns the directory of the backup called name under root,
+// for backups requested over the network by name rather than by path. The
+// name must be a single path element: not empty, absolute, . or .., and
+// without separators, so it never resolves outside root.
+func BackupPath(root, name string) (string, error) {
+	if !filepath.IsLocal(name) || name == "." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%q: %w", name, ErrInvalidBackupName)
+	}
+	return filepath.Join(root, name), nil
+}
+
+// createEmptyDir creates dir, which may already exist only if it is empty.
+func createEmptyDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory %s: %w", dir, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backup directory %s: %w", dir, err)
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("backup directory %s: %w", dir, ErrBackupDirNotEmpty)
+	}
+	return nil
+}
+
+// backupLink hard-links the file name of srcDir into dstDir, copying it
+// instead if the link fails, for example across file systems. The returned
+// error satisfies os.IsNotExist if the source does not exist.
+func backupLink(srcDir, dstDir, name string) (BackupFile, error) {
+	src := filepath.Join(srcDir, name)
+	if err := os.Link(src, filepath.Join(dstDir, name)); err == nil {
+		f, err := os.Open(src)
+		if err != nil {
+			return BackupFile{}, fmt.Errorf("failed to read %s: %w", src, err)
+		}
+		defer f.Close()
+		size, sum, err := checksum(f)
+		if err != nil {
+			return BackupFile{}, fmt.Errorf("failed to read %s: %w", src, err)
+		}
+		return BackupFile{Name: name, Size: size, SHA256: sum, Linked: true}, nil
+	} else if os.IsNotExist(err) {
+		return BackupFile{}, err
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return BackupFile{}, err
+	}
+	defer f.Close()
+	return backupCopy(f, dstDir, name)
+}
+
+// backupCopy writes everything read from r to the file name in dstDir and
+// syncs it.
+func backupCopy(r io.Reader, dstDir, name string) (BackupFile, error) {
+	path := filepath.Join(dstDir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return BackupFile{}, fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	size, sum, err := checksum(io.TeeReader(r, f))
+	if err != nil {
+		return BackupFile{}, fmt.Errorf("failed to copy %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return BackupFile{}, fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return BackupFile{Name: name, Size: size, SHA256: sum}, nil
+}
+
+// writeManifest writes the manifest of the backup in dir via a synced
+// temporary file and syncs the directory, completing the backup.
+func writeManifest(dir string, manifest *BackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode backup manifest: %w", err)
+	}
+	tmp, err := backupCopy(bytes.NewReader(data), dir, BackupManifestName+".tmp")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, BackupManifestName)
+	if err := os.Rename(filepath.Join(dir, tmp.Name), path); err != nil {
+		return fmt.Errorf("failed to install backup manifest %s: %w", path, err)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open backup directory %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync backup directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// checksum reads r to the end and returns its size and hex SHA-256.
+func checksum(r io.Reader) (int64, string, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return n, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
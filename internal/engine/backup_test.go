@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKVEngine_Backup(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	// Several sealed segments, and writes still in the tail buffer
+	for i := 0; i < 20; i++ {
+		if err := engine.Put(fmt.Sprintf("key%02d", i), "value"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if err := engine.Delete("key00"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	seq := engine.LastSeq()
+
+	dir := filepath.Join(t.TempDir(), "backup")
+	manifest, err := engine.Backup(dir)
+	if err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	if manifest.LastSeq != seq || len(manifest.Files) < 3 {
+		t.Errorf("Backup() = %d files up to sequence %d, want several up to %d", len(manifest.Files), manifest.LastSeq, seq)
+	}
+	if err := engine.Put("after", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	// Neither later writes nor a merge of the linked segments affect it
+	if _, err := VerifyBackup(dir); err != nil {
+		t.Fatalf("VerifyBackup() error = %v", err)
+	}
+	if _, err := engine.Backup(dir); err == nil {
+		t.Errorf("Backup() into a non-empty directory error = nil, want an error")
+	}
+
+	restoredCfg := *cfg
+	restoredCfg.DATA_DIR = dir
+	restored, err := NewKVEngine(&restoredCfg)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	if got := restored.LastSeq(); got != seq {
+		t.Errorf("LastSeq() of backup = %d, want %d", got, seq)
+	}
+	if got, err := restored.Get("key19"); err != nil || got != "value" {
+		t.Errorf("Get(key19) from backup = %q, %v, want value", got, err)
+	}
+	for _, key := range []string{"key00", "after"} {
+		if _, err := restored.Get(key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Get(%s) from backup error = %v, want %v", key, err, ErrKeyNotFound)
+		}
+	}
+	if err := restored.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Opening the backup appended nothing, so damage is still detected
+	if _, err := VerifyBackup(dir); err != nil {
+		t.Fatalf("VerifyBackup() after open error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "active.log"), []byte("garbage"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := VerifyBackup(dir); !errors.Is(err, ErrBackupCorrupt) {
+		t.Errorf("VerifyBackup() of a damaged backup error = %v, want %v", err, ErrBackupCorrupt)
+	}
+}
+
+func TestBackupPath(t *testing.T) {
+	root := filepath.Join("var", "backups")
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "2024-06-01", want: filepath.Join(root, "2024-06-01")},
+		{name: "nightly.1", want: filepath.Join(root, "nightly.1")},
+		{name: "", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "..", wantErr: true},
+		{name: "/etc", wantErr: true},
+		{name: "../data", wantErr: true},
+		{name: "a/b", wantErr: true},
+		{name: `a\b`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := BackupPath(root, tt.name)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidBackupName) {
+				t.Errorf("BackupPath(%q) error = %v, want %v", tt.name, err, ErrInvalidBackupName)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("BackupPath(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/format"
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+// batchOp is a single put or delete queued in a WriteBatch.
+type batchOp struct {
+	key     []byte
+	value   []byte
+	expiry  uint64 // Unix time in milliseconds at which the value expires (0 never expires)
+	delete  bool
+	cond    condition // Requirement on the key's version checked before the batch is written
+	version uint64    // Version the key must have under condVersion and condExpired
+}
+
+// WriteBatch collects puts and deletes that are written atomically by
+// KVEngine.Write. Operations are applied in the order they were added, so a
+// later operation on the same key wins. A WriteBatch is not safe for
+// concurrent use.
+type WriteBatch struct {
+	ops   []batchOp
+	reads []batchOp // Conditions on keys checked before any operation, which write nothing
+}
+
+// NewWriteBatch creates and returns a new empty write batch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+// Put queues storing value under key.
+func (b *WriteBatch) Put(key string, value string) {
+	b.ops = append(b.ops, batchOp{key: []byte(key), value: []byte(value)})
+}
+
+// PutWithTTL queues storing value under key until ttl has passed, measured
+// from when the operation is queued.
+func (b *WriteBatch) PutWithTTL(key string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl %v for key %s: must be positive", ttl, key)
+	}
+	b.ops = append(b.ops, batchOp{key: []byte(key), value: []byte(value), expiry: expiryAfter(ttl)})
+	return nil
+}
+
+// Delete queues removing key.
+func (b *WriteBatch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: []byte(key), delete: true})
+}
+
+// Len returns the number of operations queued in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.ops)
+}
+
+// Reset empties the batch so it can be reused.
+func (b *WriteBatch) Reset() {
+	b.ops = b.ops[:0]
+	b.reads = b.reads[:0]
+}
+
+// Write atomically applies every operation in the batch. The records are
+// appended as one contiguous run followed by a single commit marker, so after
+// a crash recovery restores either the whole batch or none of it. The keyDir
+// is only updated once the append succeeded, and readers never observe part
+// of a batch. Returns an error if encoding or I/O fails.
+func (e *KVEngine) Write(batch *WriteBatch) error {
+	return e.WriteContext(context.Background(), batch)
+}
+
+// WriteContext is like Write but gives up once ctx is done while waiting for
+// earlier writes. A batch abandoned this way is never applied; once its
+// append has started, WriteContext waits for the outcome.
+func (e *KVEngine) WriteContext(ctx context.Context, batch *WriteBatch) error {
+	if batch == nil {
+		return errors.New("batch cannot be nil")
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	fileId, offset, err := e.write(ctx, batch)
+	if err != nil {
+		return fmt.Errorf("failed to write batch: %w", err)
+	}
+
+	slog.Info("write: success",
+		"operations", batch.Len(),
+		"file_id", fileId,
+		"offset", offset)
+
+	e.maybeMerge()
+	return nil
+}
+
+// write appends the batch with its commit marker and applies it to the
+// keyDir, returning once it is as durable as the sync mode promises.
+// Returns the file id and offset of the first record. If ctx is done before
+// the batch is appended, nothing is written and the context's error is
+// returned. Returns ErrClosed once the engine is closed.
+func (e *KVEngine) write(ctx context.Context, batch *WriteBatch) (uint32, int64, error) {
+	if e.closed.Load() {
+		return 0, 0, ErrClosed
+	}
+	if e.syncMode == storage.SyncBatch {
+		return e.groupCommit(ctx, batch)
+	}
+
+	if err := e.writeMu.LockContext(ctx); err != nil {
+		return 0, 0, err
+	}
+	defer e.writeMu.Unlock()
+	return e.appendBatch(batch)
+}
+
+// encodeBatch encodes the records of a batch followed by their commit marker,
+// numbering them with the sequence numbers following seq. It returns the
+// encoded bytes and a hint per record with offsets relative to the start of
+// the batch.
+func (e *KVEngine) encodeBatch(batch *WriteBatch, seq uint64) ([]byte, []*format.Hint, error) {
+	timestamp := uint64(time.Now().UnixNano())
+
+	data := make([]byte, 0)
+	hints := make([]*format.Hint, len(batch.ops))
+	for i, op := range batch.ops {
+		if len(op.key) > MaxKeySize {
+			return nil, nil, fmt.Errorf("key of %d bytes exceeds %d: %w", len(op.key), MaxKeySize, ErrKeyTooLarge)
+		}
+		if len(op.value) > MaxValueSize {
+			return nil, nil, fmt.Errorf("value of %d bytes for key %s exceeds %d: %w", len(op.value), op.key, MaxValueSize, ErrValueTooLarge)
+		}
+
+		record := &format.Record{
+			Timestamp: timestamp,
+			Keysize:   uint32(len(op.key)),
+			Valuesize: uint32(len(op.value)),
+			Flag:      format.FlagNormal,
+			Seq:       seq + uint64(i) + 1,
+			Key:       op.key,
+			Value:     op.value,
+		}
+		if op.delete {
+			record.Valuesize = 0
+			record.Flag = format.FlagTombstone
+			record.Value = nil
+		} else if op.expiry != 0 {
+			record.Flag = format.FlagExpiring
+			record.Expiry = op.expiry
+		}
+
+		encoded, err := record.Encode(e.cfg.HEADER_SIZE)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode record for key %s: %w", op.key, err)
+		}
+
+		hints[i] = &format.Hint{
+			Timestamp: timestamp,
+			Keysize:   record.Keysize,
+			Size:      uint32(len(encoded)),
+			Offset:    int64(len(data)),
+			Expiry:    record.Expiry,
+			Seq:       record.Seq,
+			Flag:      record.Flag,
+			Key:       record.Key,
+		}
+		data = append(data, encoded...)
+	}
+
+	commitData, err := e.encodeCommit(len(batch.ops), data, seq+uint64(len(batch.ops)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(data, commitData...), hints, nil
+}
+
+// appendBatch appends a batch on its own, syncs it as the sync mode
+// requires and applies it to the keyDir.
+// Caller must hold e.writeMu.
+func (e *KVEngine) appendBatch(batch *WriteBatch) (uint32, int64, error) {
+	req := &commitRequest{batch: batch}
+	e.appendGroup([]*commitRequest{req})
+	return req.fileId, req.offset, req.err
+}
+
+// encodeCommit encodes the commit marker that terminates every write. It
+// carries the number of records it commits and the checksum of their encoded
+// bytes, so recovery can tell a complete batch from a torn one, and seq, the
+// highest sequence number assigned so far. Appending the records and their
+// commit in one call keeps them in the same segment.
+func (e *KVEngine) encodeCommit(count int, records []byte, seq uint64) ([]byte, error) {
+	commitRecord := format.NewCommitRecord(uint64(time.Now().UnixNano()), uint32(count), crc32.ChecksumIEEE(records), seq)
+	commitData, err := commitRecord.Encode(e.cfg.HEADER_SIZE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode commit record: %w", err)
+	}
+	return commitData, nil
+}
@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jassi-singh/aether-kv/internal/format"
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+func TestKVEngine_Write(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	if err := engine.Put("stale", "value"); err != nil {
+		t.Fatalf("Failed to put key: %v", err)
+	}
+
+	batch := NewWriteBatch()
+	batch.Put("a", "1")
+	batch.Put("b", "2")
+	batch.Delete("stale")
+	batch.Put("a", "3") // Later operations on the same key win
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("KVEngine.Write() error = %v", err)
+	}
+	if err := engine.Write(NewWriteBatch()); err != nil {
+		t.Errorf("KVEngine.Write() with empty batch error = %v", err)
+	}
+	if err := engine.Write(nil); err == nil {
+		t.Error("KVEngine.Write() with nil batch expected error, got nil")
+	}
+
+	check := func(e *KVEngine) {
+		t.Helper()
+		if got, err := e.Get("a"); err != nil || got != "3" {
+			t.Errorf("Get(a) = %q, %v, want %q", got, err, "3")
+		}
+		if got, err := e.Get("b"); err != nil || got != "2" {
+			t.Errorf("Get(b) = %q, %v, want %q", got, err, "2")
+		}
+		if _, err := e.Get("stale"); err == nil {
+			t.Error("Get(stale) succeeded after batch delete")
+		}
+	}
+	check(engine)
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+
+	reopened, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer reopened.Close()
+	check(reopened)
+}
+
+func TestKVEngine_RecoverTornBatch(t *testing.T) {
+	cfg := setupTestConfig(t)
+	path := filepath.Join(cfg.DATA_DIR, storage.ActiveFileName)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Put("before", "value"); err != nil {
+		t.Fatalf("Failed to put key: %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat log: %v", err)
+	}
+	committed := stat.Size()
+
+	engine, err = NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	batch := NewWriteBatch()
+	for i := 0; i < 5; i++ {
+		batch.Put(fmt.Sprintf("key%d", i), "value")
+	}
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("KVEngine.Write() error = %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+
+	// Cut the log in the middle of the batch, as a crash would
+	if err := os.Truncate(path, committed+60); err != nil {
+		t.Fatalf("Failed to truncate log: %v", err)
+	}
+
+	engine, err = NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to recover engine: %v", err)
+	}
+	if size := engine.GetKeyDirSize(); size != 1 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 1)
+	}
+	// The torn tail is dropped, so new writes stay readable after recovery
+	if err := engine.Put("after", "value"); err != nil {
+		t.Fatalf("Failed to put key: %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+
+	reopened, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer reopened.Close()
+	for _, key := range []string{"before", "after"} {
+		if got, err := reopened.Get(key); err != nil || got != "value" {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, "value")
+		}
+	}
+	if _, err := reopened.Get("key0"); err == nil {
+		t.Error("Get(key0) succeeded for a key from a torn batch")
+	}
+}
+
+func TestKVEngine_RecoverMismatchedCommit(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	// A commit claiming more records than precede it must not commit them
+	record := &format.Record{
+		Timestamp: 1,
+		Keysize:   3,
+		Valuesize: 5,
+		Flag:      format.FlagNormal,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+	}
+	data, err := record.Encode(cfg.HEADER_SIZE)
+	if err != nil {
+		t.Fatalf("Failed to encode record: %v", err)
+	}
+	commit, err := format.NewCommitRecord(1, 2, 0, 0).Encode(cfg.HEADER_SIZE)
+	if err != nil {
+		t.Fatalf("Failed to encode commit: %v", err)
+	}
+	if _, _, err := engine.file.Append(append(data, commit...)); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+
+	if err := engine.RecoverKeyDir(); err != nil {
+		t.Fatalf("RecoverKeyDir() error = %v", err)
+	}
+	if size := engine.GetKeyDirSize(); size != 0 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 0)
+	}
+}
@@ -0,0 +1,77 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+	"unsafe"
+)
+
+// PutOptions holds the optional settings of PutBytes.
+type PutOptions struct {
+	TTL time.Duration // Time after which the key expires (0 never expires)
+}
+
+// GetBytes is like Get for binary keys and values. The returned slice
+// belongs to the caller. Returns ctx's error if ctx is done before the
+// value is read, including while the read waits for a merge to swap in
+// its segments.
+func (e *KVEngine) GetBytes(ctx context.Context, key []byte) ([]byte, error) {
+	return get[[]byte](ctx, e, bytesToString(key))
+}
+
+// PutBytes is like Put for binary keys and values, with a TTL set through
+// opts. Neither slice is retained, and neither is copied beyond encoding
+// the record. If ctx is done while the write waits behind earlier writes,
+// nothing is written and ctx's error is returned; once its append has
+// started, PutBytes waits for the outcome.
+func (e *KVEngine) PutBytes(ctx context.Context, key, value []byte, opts PutOptions) error {
+	if opts.TTL < 0 {
+		return fmt.Errorf("invalid ttl %v for key %s: must not be negative", opts.TTL, key)
+	}
+	op := batchOp{key: key, value: value}
+	if opts.TTL > 0 {
+		op.expiry = expiryAfter(opts.TTL)
+	}
+
+	fileId, offset, err := e.write(ctx, &WriteBatch{ops: []batchOp{op}})
+	if err != nil {
+		return fmt.Errorf("failed to put key %s: %w", key, err)
+	}
+
+	slog.Info("put: success",
+		"key", bytesToString(key),
+		"file_id", fileId,
+		"offset", offset,
+		"key_size", len(key),
+		"value_size", len(value),
+		"ttl", opts.TTL)
+
+	e.maybeMerge()
+	return nil
+}
+
+// DeleteBytes is like Delete for a binary key, and gives up like PutBytes
+// once ctx is done.
+func (e *KVEngine) DeleteBytes(ctx context.Context, key []byte) error {
+	fileId, offset, err := e.write(ctx, &WriteBatch{ops: []batchOp{{key: key, delete: true}}})
+	if err != nil {
+		return fmt.Errorf("failed to delete key %s: %w", key, err)
+	}
+
+	slog.Info("delete: success",
+		"key", bytesToString(key),
+		"file_id", fileId,
+		"offset", offset)
+
+	e.maybeMerge()
+	return nil
+}
+
+// bytesToString returns b as a string without copying it. The string
+// shares b's memory, so it may only be used while b is left unmodified and
+// must not be retained; the keyDir copies the keys it stores.
+func bytesToString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
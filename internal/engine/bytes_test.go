@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKVEngine_Bytes(t *testing.T) {
+	cfg := setupTestConfig(t)
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+	ctx := context.Background()
+
+	key := []byte("bin\x00key\xff")
+	value := []byte{0, 1, 2, 0xfe, 0xff, '\n', ' '}
+	if err := engine.PutBytes(ctx, key, value, PutOptions{}); err != nil {
+		t.Fatalf("PutBytes() error = %v", err)
+	}
+	// The caller may reuse its buffers once PutBytes returns
+	want := bytes.Clone(value)
+	value[0] = 'x'
+	stored := bytes.Clone(key)
+	key[0] = 'x'
+
+	got, err := engine.GetBytes(ctx, stored)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("GetBytes() = %v, %v, want %v", got, err, want)
+	}
+	if s, err := engine.Get(string(stored)); err != nil || s != string(want) {
+		t.Errorf("Get() = %q, %v, want %q", s, err, want)
+	}
+
+	if err := engine.PutBytes(ctx, []byte("session"), []byte("token"), PutOptions{TTL: time.Hour}); err != nil {
+		t.Fatalf("PutBytes() with ttl error = %v", err)
+	}
+	if ttl, err := engine.TTL("session"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL(session) = %v, %v, want at most an hour", ttl, err)
+	}
+	if err := engine.PutBytes(ctx, []byte("session"), nil, PutOptions{TTL: -time.Second}); err == nil {
+		t.Error("PutBytes() with negative ttl succeeded")
+	}
+
+	if err := engine.DeleteBytes(ctx, stored); err != nil {
+		t.Fatalf("DeleteBytes() error = %v", err)
+	}
+	if _, err := engine.GetBytes(ctx, stored); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("GetBytes() after DeleteBytes() error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := engine.GetBytes(cancelled, []byte("session")); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetBytes() with cancelled context error = %v, want %v", err, context.Canceled)
+	}
+
+	// A read cancelled while a merge holds the segments must not go on to
+	// read the value once the merge is done
+	waiting, cancel := context.WithCancel(ctx)
+	engine.swapMu.Lock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := engine.GetBytes(waiting, []byte("session"))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	engine.swapMu.Unlock()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("GetBytes() cancelled while waiting for a merge error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestKVEngine_WriteDeadline(t *testing.T) {
+	for _, mode := range []string{"periodic", "batch"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := setupTestConfig(t)
+			cfg.SYNC_MODE = mode
+			engine, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer engine.Close()
+
+			// A slow write holds the lock until the deadline has passed
+			engine.writeMu.Lock()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			err = engine.PutBytes(ctx, []byte("key"), []byte("value"), PutOptions{})
+			engine.writeMu.Unlock()
+
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("PutBytes() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if len(engine.commitQueue) != 0 {
+				t.Errorf("commit queue holds %d batches after the deadline, want 0", len(engine.commitQueue))
+			}
+
+			// The abandoned write is not applied by the next one
+			if err := engine.Put("other", "value"); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if _, err := engine.Get("key"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Get(key) error = %v, want %v", err, ErrKeyNotFound)
+			}
+		})
+	}
+}
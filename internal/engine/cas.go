@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// condition is a requirement on the version of a key that an operation of a
+// batch checks before the batch is written.
+type condition uint8
+
+const (
+	condNone    condition = iota // Unconditional
+	condAbsent                   // The key must not exist
+	condVersion                  // The key must exist at the operation's version
+	condExpired                  // The key must still hold the operation's version, expired
+	condPresent                  // The operation is dropped unless the key exists
+)
+
+// errConditionFailed is returned by write when a condition of the batch does
+// not hold. Nothing of the batch is written.
+var errConditionFailed = errors.New("condition not met")
+
+// GetVersion is like Get but also returns the key's version: the sequence
+// number of the write that stored its current value. Every write gets a
+// higher sequence number than the last, so a key never returns to an
+// earlier version. Keys last written before sequence numbers existed have
+// version 0.
+func (e *KVEngine) GetVersion(key string) (string, uint64, error) {
+	return getVersion[string](context.Background(), e, key)
+}
+
+// PutIfAbsent stores value under key unless the key exists. Returns false
+// without writing if it does, and an error if encoding or I/O fails.
+func (e *KVEngine) PutIfAbsent(key string, value string) (bool, error) {
+	fileId, offset, err := e.write(context.Background(), &WriteBatch{ops: []batchOp{
+		{key: []byte(key), value: []byte(value), cond: condAbsent},
+	}})
+	if errors.Is(err, errConditionFailed) {
+		slog.Debug("put if absent: key exists",
+			"key", key)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to put key %s: %w", key, err)
+	}
+
+	slog.Info("put if absent: success",
+		"key", key,
+		"file_id", fileId,
+		"offset", offset)
+
+	e.maybeMerge()
+	return true, nil
+}
+
+// CompareAndSwap stores value under key if the key exists at the given
+// version, as returned by GetVersion. Returns false without writing if the
+// key is missing or was written since, and an error if encoding or I/O
+// fails. The check and the write are atomic with respect to every other
+// write.
+func (e *KVEngine) CompareAndSwap(key string, version uint64, value string) (bool, error) {
+	fileId, offset, err := e.write(context.Background(), &WriteBatch{ops: []batchOp{
+		{key: []byte(key), value: []byte(value), cond: condVersion, version: version},
+	}})
+	if errors.Is(err, errConditionFailed) {
+		slog.Debug("compare and swap: version mismatch",
+			"key", key,
+			"version", version)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to swap key %s: %w", key, err)
+	}
+
+	slog.Info("compare and swap: success",
+		"key", key,
+		"version", version,
+		"file_id", fileId,
+		"offset", offset)
+
+	e.maybeMerge()
+	return true, nil
+}
+
+// DeleteIfVersion deletes key if it exists at the given version, as
+// returned by GetVersion. Returns false without writing if the key is
+// missing or was written since, and an error if encoding or I/O fails.
+func (e *KVEngine) DeleteIfVersion(key string, version uint64) (bool, error) {
+	fileId, offset, err := e.write(context.Background(), &WriteBatch{ops: []batchOp{
+		{key: []byte(key), delete: true, cond: condVersion, version: version},
+	}})
+	if errors.Is(err, errConditionFailed) {
+		slog.Debug("delete if version: version mismatch",
+			"key", key,
+			"version", version)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete key %s: %w", key, err)
+	}
+
+	slog.Info("delete if version: success",
+		"key", key,
+		"version", version,
+		"file_id", fileId,
+		"offset", offset)
+
+	e.maybeMerge()
+	return true, nil
+}
+
+// DeleteExisting deletes every given key that exists, in one atomic batch,
+// and returns how many did. Which keys exist is decided as the batch is
+// written, so a key created or deleted concurrently is counted exactly when
+// its tombstone is written. A key named more than once is counted once.
+// Returns an error if encoding or I/O fails.
+func (e *KVEngine) DeleteExisting(keys ...string) (int, error) {
+	batch := NewWriteBatch()
+	for _, key := range keys {
+		batch.ops = append(batch.ops, batchOp{key: []byte(key), delete: true, cond: condPresent})
+	}
+
+	fileId, offset, err := e.write(context.Background(), batch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete %d keys: %w", len(keys), err)
+	}
+	if batch.Len() == 0 {
+		slog.Debug("delete existing: no key exists",
+			"keys", len(keys))
+		return 0, nil
+	}
+
+	slog.Info("delete existing: success",
+		"keys", len(keys),
+		"deleted", batch.Len(),
+		"file_id", fileId,
+		"offset", offset)
+
+	e.maybeMerge()
+	return batch.Len(), nil
+}
+
+// dropAbsent removes from a batch numbered from the sequence number after
+// seq each condPresent operation whose key does not exist when it would be
+// applied, judged as checkConditions does.
+// Caller must hold e.writeMu.
+func (e *KVEngine) dropAbsent(batch *WriteBatch, seq uint64, pending map[string]uint64) {
+	kept := 0
+	for _, op := range batch.ops {
+		// The operations kept so far precede it at batch.ops[:kept]
+		batch.ops[kept] = op
+		if op.cond == condPresent {
+			if _, exists := e.versionAt(batch, kept, seq, pending); !exists {
+				continue
+			}
+		}
+		kept++
+	}
+	batch.ops = batch.ops[:kept]
+}
+
+// conditional reports whether any operation or read of the batch has a
+// condition.
+func (b *WriteBatch) conditional() bool {
+	if len(b.reads) > 0 {
+		return true
+	}
+	for _, op := range b.ops {
+		if op.cond != condNone {
+			return true
+		}
+	}
+	return false
+}
+
+// record notes in pending the version each key of the batch is left at,
+// given the batch was numbered from the sequence number after seq. Deleted
+// keys are noted with version 0, which no write is ever assigned.
+func (b *WriteBatch) record(seq uint64, pending map[string]uint64) {
+	for i, op := range b.ops {
+		if op.delete {
+			pending[string(op.key)] = 0
+		} else {
+			pending[string(op.key)] = seq + uint64(i) + 1
+		}
+	}
+}
+
+// checkConditions verifies the conditions of a batch numbered from the
+// sequence number after seq. Its reads are checked against the versions
+// keys have before the batch; each operation's condition against the
+// version its key has when the operation is applied: as left by earlier
+// operations of the batch, else by batches written ahead of it as noted in
+// pending, else as held by the keyDir. Returns errConditionFailed if any
+// does not hold.
+// Caller must hold e.writeMu, or e.swapMu when pending is nil and the batch
+// is not about to be written.
+func (e *KVEngine) checkConditions(batch *WriteBatch, seq uint64, pending map[string]uint64) error {
+	for _, read := range batch.reads {
+		version, exists := e.committedVersion(read.key, pending)
+		if err := read.check(version, exists); err != nil {
+			return err
+		}
+	}
+	for i, op := range batch.ops {
+		if op.cond == condNone || op.cond == condPresent {
+			continue
+		}
+		if op.cond == condExpired {
+			if !e.expiredAt(batch, i, pending) {
+				return fmt.Errorf("key %s no longer holds expired version %d: %w", op.key, op.version, errConditionFailed)
+			}
+			continue
+		}
+		version, exists := e.versionAt(batch, i, seq, pending)
+		if err := op.check(version, exists); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check returns errConditionFailed unless the operation's condition holds
+// for a key at version, which exists or not.
+func (op batchOp) check(version uint64, exists bool) error {
+	if op.cond == condAbsent && exists {
+		return fmt.Errorf("key %s exists: %w", op.key, errConditionFailed)
+	}
+	if op.cond == condVersion && (!exists || version != op.version) {
+		return fmt.Errorf("key %s is not at version %d: %w", op.key, op.version, errConditionFailed)
+	}
+	return nil
+}
+
+// versionAt returns the version of the key of the i-th operation of a batch
+// just before that operation is applied, and whether the key exists then.
+func (e *KVEngine) versionAt(batch *WriteBatch, i int, seq uint64, pending map[string]uint64) (uint64, bool) {
+	key := batch.ops[i].key
+	for j := i - 1; j >= 0; j-- {
+		if bytes.Equal(batch.ops[j].key, key) {
+			if batch.ops[j].delete {
+				return 0, false
+			}
+			return seq + uint64(j) + 1, true
+		}
+	}
+	return e.committedVersion(key, pending)
+}
+
+// expiredAt reports whether the key of the i-th operation of a batch still
+// holds the expired version the operation names just before that operation
+// is applied: no earlier operation of the batch and no batch written ahead
+// in the same group touches the key, and the keyDir holds that version.
+// Expired keys read as missing, so versionAt cannot tell this apart.
+func (e *KVEngine) expiredAt(batch *WriteBatch, i int, pending map[string]uint64) bool {
+	op := batch.ops[i]
+	for j := i - 1; j >= 0; j-- {
+		if bytes.Equal(batch.ops[j].key, op.key) {
+			return false
+		}
+	}
+	if _, ok := pending[bytesToString(op.key)]; ok {
+		return false
+	}
+	entry, ok := e.keyDir.Load(bytesToString(op.key))
+	return ok && entry.Seq == op.version && entry.Expired(nowMillis())
+}
+
+// committedVersion returns the version of key as left by batches written
+// ahead in the same group, as noted in pending, else as held by the keyDir,
+// and whether the key exists.
+func (e *KVEngine) committedVersion(key []byte, pending map[string]uint64) (uint64, bool) {
+	if version, ok := pending[bytesToString(key)]; ok {
+		return version, version != 0
+	}
+
+	entry, ok := e.keyDir.Load(bytesToString(key))
+	if !ok || entry.Expired(nowMillis()) {
+		return 0, false
+	}
+	return entry.Seq, true
+}
@@ -0,0 +1,304 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKVEngine_ConditionalWrites(t *testing.T) {
+	cfg := setupTestConfig(t)
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if ok, err := engine.PutIfAbsent("key", "first"); err != nil || !ok {
+		t.Fatalf("PutIfAbsent() = %v, %v, want true", ok, err)
+	}
+	if ok, err := engine.PutIfAbsent("key", "second"); err != nil || ok {
+		t.Errorf("PutIfAbsent() on existing key = %v, %v, want false", ok, err)
+	}
+	value, version, err := engine.GetVersion("key")
+	if err != nil || value != "first" || version == 0 {
+		t.Fatalf("GetVersion() = %q, %d, %v, want first at a non-zero version", value, version, err)
+	}
+
+	if ok, err := engine.CompareAndSwap("key", version+1, "stale"); err != nil || ok {
+		t.Errorf("CompareAndSwap() with wrong version = %v, %v, want false", ok, err)
+	}
+	if ok, err := engine.CompareAndSwap("missing", 0, "value"); err != nil || ok {
+		t.Errorf("CompareAndSwap() on missing key = %v, %v, want false", ok, err)
+	}
+	if ok, err := engine.CompareAndSwap("key", version, "swapped"); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() = %v, %v, want true", ok, err)
+	}
+	value, swapped, err := engine.GetVersion("key")
+	if err != nil || value != "swapped" || swapped <= version {
+		t.Errorf("GetVersion() after swap = %q, %d, %v, want swapped above version %d", value, swapped, err, version)
+	}
+
+	if ok, err := engine.DeleteIfVersion("key", version); err != nil || ok {
+		t.Errorf("DeleteIfVersion() with old version = %v, %v, want false", ok, err)
+	}
+	if ok, err := engine.DeleteIfVersion("key", swapped); err != nil || !ok {
+		t.Fatalf("DeleteIfVersion() = %v, %v, want true", ok, err)
+	}
+	if _, _, err := engine.GetVersion("key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("GetVersion() after DeleteIfVersion() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if ok, err := engine.PutIfAbsent("key", "again"); err != nil || !ok {
+		t.Errorf("PutIfAbsent() after delete = %v, %v, want true", ok, err)
+	}
+
+	// A condition sees the earlier operations of its own batch
+	batch := NewWriteBatch()
+	batch.Delete("key")
+	batch.ops = append(batch.ops, batchOp{key: []byte("key"), value: []byte("value"), cond: condAbsent})
+	if err := engine.Write(batch); err != nil {
+		t.Errorf("Write() of delete then put if absent error = %v", err)
+	}
+	batch.Reset()
+	batch.Put("other", "value")
+	batch.ops = append(batch.ops, batchOp{key: []byte("key"), value: []byte("value"), cond: condAbsent})
+	if err := engine.Write(batch); !errors.Is(err, errConditionFailed) {
+		t.Errorf("Write() with failing condition error = %v, want %v", err, errConditionFailed)
+	}
+	if _, err := engine.Get("other"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get(other) after failed batch error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestKVEngine_CompareAndSwapConcurrent(t *testing.T) {
+	for _, mode := range []string{"periodic", "batch"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := setupTestConfig(t)
+			cfg.SYNC_MODE = mode
+			engine, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer engine.Close()
+
+			if err := engine.Put("counter", "0"); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			// Every increment is a read-modify-write that must not be lost,
+			// and exactly one writer may claim the lock key
+			const workers, increments = 8, 25
+			var wg sync.WaitGroup
+			var claimed atomic.Int32
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if ok, err := engine.PutIfAbsent("lock", "held"); err != nil {
+						t.Errorf("PutIfAbsent() error = %v", err)
+					} else if ok {
+						claimed.Add(1)
+					}
+					for i := 0; i < increments; {
+						value, version, err := engine.GetVersion("counter")
+						if err != nil {
+							t.Errorf("GetVersion() error = %v", err)
+							return
+						}
+						var n int
+						fmt.Sscan(value, &n)
+						ok, err := engine.CompareAndSwap("counter", version, fmt.Sprint(n+1))
+						if err != nil {
+							t.Errorf("CompareAndSwap() error = %v", err)
+							return
+						}
+						if ok {
+							i++
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if value, err := engine.Get("counter"); err != nil || value != fmt.Sprint(workers*increments) {
+				t.Errorf("Get(counter) = %q, %v, want %d", value, err, workers*increments)
+			}
+			if n := claimed.Load(); n != 1 {
+				t.Errorf("PutIfAbsent() succeeded %d times, want 1", n)
+			}
+		})
+	}
+}
+
+func TestKVEngine_VersionsSurviveRestart(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 5; i++ {
+			if err := engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", round)); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+		}
+	}
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	versions := make(map[string]uint64)
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if _, versions[key], err = engine.GetVersion(key); err != nil {
+			t.Fatalf("GetVersion(%s) error = %v", key, err)
+		}
+	}
+	last := engine.lastSeq.Load()
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Reopen twice: first scanning the merged segments, then from their hints
+	for reopen := 0; reopen < 2; reopen++ {
+		engine, err = NewKVEngine(cfg)
+		if err != nil {
+			t.Fatalf("Failed to reopen engine: %v", err)
+		}
+		for key, want := range versions {
+			if _, version, err := engine.GetVersion(key); err != nil || version != want {
+				t.Errorf("GetVersion(%s) after reopen = %d, %v, want %d", key, version, err, want)
+			}
+		}
+		if seq := engine.lastSeq.Load(); seq != last {
+			t.Errorf("last sequence number after reopen = %d, want %d", seq, last)
+		}
+		if err := engine.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+}
+
+func TestKVEngine_ConditionsInGroupCommit(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.SYNC_MODE = "batch"
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if err := engine.Put("key", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	_, version, err := engine.GetVersion("key")
+	if err != nil {
+		t.Fatalf("GetVersion() error = %v", err)
+	}
+
+	// Queue competing swaps from the same version so one group commits both
+	engine.writeMu.Lock()
+	var wg sync.WaitGroup
+	var swapped atomic.Int32
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := engine.CompareAndSwap("key", version, fmt.Sprint(i)); err != nil {
+				t.Errorf("CompareAndSwap() error = %v", err)
+			} else if ok {
+				swapped.Add(1)
+			}
+		}()
+	}
+	for queued := 0; queued < 2; {
+		engine.commitMu.Lock()
+		queued = len(engine.commitQueue)
+		engine.commitMu.Unlock()
+	}
+	engine.writeMu.Unlock()
+	wg.Wait()
+
+	if n := swapped.Load(); n != 1 {
+		t.Errorf("CompareAndSwap() from the same version succeeded %d times, want 1", n)
+	}
+}
+
+func TestKVEngine_DeleteExisting(t *testing.T) {
+	engine, err := NewKVEngine(setupTestConfig(t))
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	engine.Put("a", "1")
+	engine.Put("b", "2")
+	batch := NewWriteBatch()
+	batch.PutWithTTL("gone", "3", time.Millisecond)
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if n, err := engine.DeleteExisting("a", "missing", "a", "gone", "b"); err != nil || n != 2 {
+		t.Errorf("DeleteExisting() = %d, %v, want 2", n, err)
+	}
+	if _, err := engine.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get(b) after DeleteExisting() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	seq := engine.LastSeq()
+	if n, err := engine.DeleteExisting("a", "missing"); err != nil || n != 0 {
+		t.Errorf("DeleteExisting() of missing keys = %d, %v, want 0", n, err)
+	}
+	if got := engine.LastSeq(); got != seq {
+		t.Errorf("LastSeq() after deleting nothing = %d, want %d", got, seq)
+	}
+}
+
+func TestKVEngine_DeleteExistingConcurrent(t *testing.T) {
+	for _, mode := range []string{"periodic", "batch"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := setupTestConfig(t)
+			cfg.SYNC_MODE = mode
+			engine, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer engine.Close()
+
+			// Each key is counted by exactly one of the deleters racing for it
+			const workers, keys = 8, 50
+			for i := 0; i < keys; i++ {
+				engine.Put(fmt.Sprintf("key%d", i), "v")
+			}
+			var wg sync.WaitGroup
+			var deleted atomic.Int32
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < keys; i++ {
+						n, err := engine.DeleteExisting(fmt.Sprintf("key%d", i), fmt.Sprintf("key%d", (i+w)%keys))
+						if err != nil {
+							t.Errorf("DeleteExisting() error = %v", err)
+							return
+						}
+						deleted.Add(int32(n))
+					}
+				}()
+			}
+			wg.Wait()
+
+			if n := deleted.Load(); n != keys {
+				t.Errorf("DeleteExisting() counted %d deletions, want %d", n, keys)
+			}
+			if n := engine.GetKeyDirSize(); n != 0 {
+				t.Errorf("GetKeyDirSize() = %d, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jassi-singh/aether-kv/internal/format"
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+// commitRequest is a batch waiting to be appended by the group commit
+// pipeline. It is encoded by whichever caller appends it, once the batch's
+// sequence numbers are known.
+type commitRequest struct {
+	batch  *WriteBatch    // Operations to write
+	data   []byte         // Encoded records followed by their commit marker, set once encoded
+	hints  []*format.Hint // Hint per record, offsets relative to the start of data
+	fileId uint32         // File the batch was appended to, set once done
+	offset int64          // Offset of the first record, set once done
+	err    error          // Set once done if the append or sync failed
+	done   chan struct{}  // Closed once the batch is durable or has failed
+}
+
+// groupCommit appends a batch under SyncBatch. Concurrent callers
+// queue their batches; whichever caller next takes writeMu appends every
+// queued batch in a single write, fsyncs once and applies them all to the
+// keyDir. Each caller returns only once its own batch is durable.
+//
+// If ctx is done while the caller waits for writeMu, its batch is withdrawn
+// from the queue and never written. A batch another caller has already
+// taken is past withdrawing, so the wait continues until it is committed.
+func (e *KVEngine) groupCommit(ctx context.Context, batch *WriteBatch) (uint32, int64, error) {
+	req := &commitRequest{batch: batch, done: make(chan struct{})}
+
+	e.commitMu.Lock()
+	e.commitQueue = append(e.commitQueue, req)
+	e.commitMu.Unlock()
+
+	if err := e.writeMu.LockContext(ctx); err != nil {
+		if e.withdraw(req) {
+			return 0, 0, err
+		}
+		<-req.done
+		return req.fileId, req.offset, req.err
+	}
+	e.commitMu.Lock()
+	group := e.commitQueue
+	e.commitQueue = nil
+	e.commitMu.Unlock()
+
+	// The queue is empty if an earlier leader already committed our batch
+	if len(group) > 0 {
+		e.appendGroup(group)
+		for _, queued := range group {
+			close(queued.done)
+		}
+	}
+	e.writeMu.Unlock()
+
+	<-req.done
+	return req.fileId, req.offset, req.err
+}
+
+// withdraw removes req from the commit queue. Returns false if a group
+// commit has already taken it.
+func (e *KVEngine) withdraw(req *commitRequest) bool {
+	e.commitMu.Lock()
+	defer e.commitMu.Unlock()
+
+	for i, queued := range e.commitQueue {
+		if queued == req {
+			e.commitQueue = append(e.commitQueue[:i], e.commitQueue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// appendGroup encodes the batches of a group with consecutive sequence
+// numbers, appends them as one contiguous write, syncs it as the sync mode
+// requires and applies the batches to the keyDir in order. A batch whose
+// conditions do not hold or that fails to encode is left out with its own
+// error; the others share the outcome of the append. A batch left empty once
+// its operations on absent keys are dropped succeeds without being
+// appended. Every request's err is set, but done is left to the caller.
+// Caller must hold e.writeMu.
+func (e *KVEngine) appendGroup(group []*commitRequest) {
+	// Conditions see the writes of batches ahead of them in the group
+	var pending map[string]uint64
+	for _, req := range group {
+		if req.batch.conditional() {
+			pending = make(map[string]uint64)
+			break
+		}
+	}
+
+	seq := e.lastSeq.Load()
+	size := 0
+	accepted := make([]*commitRequest, 0, len(group))
+	for _, req := range group {
+		if pending != nil {
+			e.dropAbsent(req.batch, seq, pending)
+			if req.batch.Len() == 0 {
+				continue
+			}
+		}
+		if err := e.checkConditions(req.batch, seq, pending); err != nil {
+			req.err = err
+			continue
+		}
+		req.data, req.hints, req.err = e.encodeBatch(req.batch, seq)
+		if req.err != nil {
+			continue
+		}
+		if pending != nil {
+			req.batch.record(seq, pending)
+		}
+		seq += uint64(req.batch.Len())
+		size += len(req.data)
+		accepted = append(accepted, req)
+	}
+	if len(accepted) == 0 {
+		return
+	}
+
+	data := make([]byte, 0, size)
+	for _, req := range accepted {
+		data = append(data, req.data...)
+	}
+
+	err := e.appendAndSync(data, accepted, seq)
+	for _, req := range accepted {
+		req.err = err
+	}
+
+	if len(accepted) > 1 {
+		slog.Debug("commit: group committed",
+			"batches", len(accepted),
+			"bytes", size)
+	}
+}
+
+// Sync makes every write acknowledged so far durable, whatever the sync
+// mode, by flushing buffered writes and fsyncing the active log. Sealed
+// segments were fsynced when they were sealed.
+func (e *KVEngine) Sync() error {
+	if e.closed.Load() {
+		return ErrClosed
+	}
+	if err := e.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	return nil
+}
+
+// appendAndSync appends data holding the batches of group back to back,
+// fsyncing it in the same call under SyncAlways and SyncBatch, then applies
+// the batches to the keyDir. seq is the last sequence number used by the
+// batches. Nothing is applied if the append or sync fails. The storage then
+// discards data, so seq is left for the next batch and committed sequence
+// numbers stay free of gaps and duplicates. Only if the storage could not
+// discard it, and refuses appends from then on, is seq consumed, since the
+// batch might still be recovered.
+// Caller must hold e.writeMu.
+func (e *KVEngine) appendAndSync(data []byte, group []*commitRequest, seq uint64) error {
+	write := e.file.Append
+	if e.syncMode == storage.SyncAlways || e.syncMode == storage.SyncBatch {
+		write = e.file.AppendSync
+	}
+	fileId, offset, err := write(data)
+	if err != nil {
+		if errors.Is(err, storage.ErrFailed) {
+			e.lastSeq.Store(seq)
+		}
+		return fmt.Errorf("failed to append batch to file: %w", err)
+	}
+	e.lastSeq.Store(seq)
+
+	e.swapMu.Lock()
+	for _, req := range group {
+		req.fileId = fileId
+		req.offset = offset
+		for _, hint := range req.hints {
+			hint.FileId = fileId
+			hint.Offset += offset
+			e.applyHint(hint)
+		}
+		offset += int64(len(req.data))
+	}
+	e.publish(group)
+	e.visibleSeq.Store(seq)
+	e.swapMu.Unlock()
+	return nil
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+// countingStorage counts the syncs issued to the storage it wraps. Each sync
+// is slowed down so concurrent writers pile up behind it.
+type countingStorage struct {
+	storage.Storage
+	syncs atomic.Int64
+}
+
+func (s *countingStorage) Sync() error {
+	s.syncs.Add(1)
+	time.Sleep(2 * time.Millisecond)
+	return s.Storage.Sync()
+}
+
+func (s *countingStorage) AppendSync(data []byte) (uint32, int64, error) {
+	s.syncs.Add(1)
+	time.Sleep(2 * time.Millisecond)
+	return s.Storage.AppendSync(data)
+}
+
+// failingStorage fails every append with err.
+type failingStorage struct {
+	storage.Storage
+	err error
+}
+
+func (s *failingStorage) Append([]byte) (uint32, int64, error)     { return 0, 0, s.err }
+func (s *failingStorage) AppendSync([]byte) (uint32, int64, error) { return 0, 0, s.err }
+
+func TestKVEngine_AppendFailureSeq(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		consumed bool
+	}{
+		{name: "discarded", err: errors.New("disk full"), consumed: false},
+		{name: "failed", err: storage.ErrFailed, consumed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := NewKVEngine(setupTestConfig(t))
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer engine.Close()
+			if err := engine.Put("a", "1"); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			before := engine.lastSeq.Load()
+
+			file := engine.file
+			engine.file = &failingStorage{Storage: file, err: tt.err}
+			if err := engine.Put("b", "2"); !errors.Is(err, tt.err) {
+				t.Errorf("Put() error = %v, want %v", err, tt.err)
+			}
+			engine.file = file
+
+			want := before
+			if tt.consumed {
+				want++
+			}
+			if got := engine.lastSeq.Load(); got != want {
+				t.Errorf("lastSeq after failed Put = %d, want %d", got, want)
+			}
+			if _, err := engine.Get("b"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Get(b) after failed Put error = %v, want %v", err, ErrKeyNotFound)
+			}
+		})
+	}
+}
+
+func TestKVEngine_SyncModes(t *testing.T) {
+	const writers, writesPerWriter = 8, 10
+	const total = writers * writesPerWriter
+
+	tests := []struct {
+		mode      string
+		wantSyncs func(n int64) bool
+	}{
+		{mode: "always", wantSyncs: func(n int64) bool { return n == total }},
+		{mode: "batch", wantSyncs: func(n int64) bool { return n >= 1 && n < total }},
+		{mode: "periodic", wantSyncs: func(n int64) bool { return n == 0 }},
+		{mode: "none", wantSyncs: func(n int64) bool { return n == 0 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg := setupTestConfig(t)
+			cfg.SYNC_MODE = tt.mode
+
+			engine, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			counter := &countingStorage{Storage: engine.file}
+			engine.file = counter
+
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < writesPerWriter; i++ {
+						if err := engine.Put(fmt.Sprintf("w%d:%d", w, i), "value"); err != nil {
+							t.Errorf("Put() error = %v", err)
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			if syncs := counter.syncs.Load(); !tt.wantSyncs(syncs) {
+				t.Errorf("%d writes issued %d syncs", total, syncs)
+			}
+			// An explicit sync reaches the file whatever the mode
+			before := counter.syncs.Load()
+			if err := engine.Sync(); err != nil || counter.syncs.Load() != before+1 {
+				t.Errorf("Sync() = %v after %d syncs, want one more sync than %d", err, counter.syncs.Load(), before)
+			}
+			if err := engine.Close(); err != nil {
+				t.Fatalf("Failed to close engine: %v", err)
+			}
+
+			reopened, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to reopen engine: %v", err)
+			}
+			defer reopened.Close()
+			if got := reopened.GetKeyDirSize(); got != total {
+				t.Errorf("GetKeyDirSize() after reopen = %d, want %d", got, total)
+			}
+			if got, err := reopened.Get("w3:7"); err != nil || got != "value" {
+				t.Errorf("Get(w3:7) = %q, %v, want value", got, err)
+			}
+		})
+	}
+
+	cfg := setupTestConfig(t)
+	cfg.SYNC_MODE = "sometimes"
+	if _, err := NewKVEngine(cfg); err == nil {
+		t.Error("NewKVEngine() with invalid sync mode succeeded, want error")
+	}
+}
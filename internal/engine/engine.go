@@ -5,52 +5,131 @@ package engine
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log/slog"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jassi-singh/aether-kv/internal/cache"
 	"github.com/jassi-singh/aether-kv/internal/config"
 	"github.com/jassi-singh/aether-kv/internal/format"
+	"github.com/jassi-singh/aether-kv/internal/index"
 	"github.com/jassi-singh/aether-kv/internal/storage"
 )
 
+// Limits on the size of keys and values. Every key is held in memory by the
+// key directory, so keys are kept far smaller than values.
+const (
+	MaxKeySize   = 64 << 10 // Largest key in bytes
+	MaxValueSize = 1 << 31  // Largest value in bytes, well within a record's 32-bit size fields
+)
+
+var (
+	// ErrKeyNotFound is returned when a key does not exist, has been deleted
+	// or has expired.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyTooLarge is returned when writing a key longer than MaxKeySize.
+	ErrKeyTooLarge = errors.New("key too large")
+	// ErrValueTooLarge is returned when writing a value longer than MaxValueSize.
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrClosed is returned by Close when the engine is already closed.
+	ErrClosed = errors.New("engine closed")
+)
+
 // Key represents a single entry in the key directory, mapping a key name
 // to its location in the log file. The key directory is an in-memory index
-// that provides fast lookups without scanning the entire log file.
-type Key struct {
-	FileId uint32 // File identifier (currently 0 for single-file implementation)
-	Size   uint32 // Total size of the record (header + key + value)
-	Offset int64  // Byte offset where the record starts in the log file
-}
-
-// NewKeyDir creates and returns a new empty key directory sync.Map.
-// The key directory maps string keys to their file location metadata.
-// sync.Map is used for thread-safe concurrent access without explicit locking.
-func NewKeyDir() *sync.Map {
-	return &sync.Map{}
+// that provides fast lookups without scanning the entire log file. Tree
+// nodes hold a packed 16-byte file id, size and offset per key; the expiry
+// and the version are kept next to the key bytes only when set, so a key
+// costs no allocation of its own.
+type Key = index.Location
+
+// NewKeyDir creates and returns a new empty key directory.
+// The key directory maps string keys to their file location metadata and
+// keeps them sorted, so keys can be scanned by range or prefix. It is
+// partitioned by key hash into B-trees with a lock each, so writers of
+// different keys rarely contend, with the key bytes packed into arena slabs
+// to keep large key sets compact.
+func NewKeyDir() index.Index[Key] {
+	return index.NewLocations(index.DefaultShards, index.DefaultDegree)
 }
 
 // Engine defines the interface for key-value storage operations.
 type Engine interface {
 	Get(key string) (string, error)
+	GetVersion(key string) (string, uint64, error)
+	GetEntry(key string) (Entry, error)
+	GetAt(key string, seq uint64) (string, error)
+	LastSeq() uint64
+	GetBytes(ctx context.Context, key []byte) ([]byte, error)
 	Put(key string, value string) error
+	PutBytes(ctx context.Context, key, value []byte, opts PutOptions) error
+	PutWithTTL(key string, value string, ttl time.Duration) error
+	TTL(key string) (time.Duration, error)
+	Expire(key string, ttl time.Duration) (bool, error)
 	Delete(key string) error
+	PutIfAbsent(key string, value string) (bool, error)
+	CompareAndSwap(key string, version uint64, value string) (bool, error)
+	DeleteIfVersion(key string, version uint64) (bool, error)
+	DeleteExisting(keys ...string) (int, error)
+	DeleteBytes(ctx context.Context, key []byte) error
+	Write(batch *WriteBatch) error
+	WriteContext(ctx context.Context, batch *WriteBatch) error
+	Sync() error
+	Scan(start, end string) *Iterator
+	ScanPrefix(prefix string) *Iterator
+	NewSnapshot() *Snapshot
+	NewSnapshotAt(seq uint64) (*Snapshot, error)
+	Begin() *Txn
+	Watch(prefix string, opts WatchOptions) (*Watcher, error)
+	Backup(dstDir string) (*BackupManifest, error)
 	Close() error
 	GetKeyDirSize() int
+	Stats() (Stats, error)
+	Health() error
 	RecoverKeyDir() error
+	Merge() error
 }
 
 // KVEngine is the main implementation of the key-value storage engine.
 // It maintains an in-memory key directory (keyDir) that maps keys to their
 // file locations and coordinates with the storage layer for persistence.
 type KVEngine struct {
-	keyDir *sync.Map       // Thread-safe in-memory index mapping keys to file locations
-	file   storage.Storage // Storage interface for file operations
-	cfg    *config.Config  // Configuration injected at initialization
+	keyDir      index.Index[Key]  // Thread-safe ordered in-memory index mapping keys to file locations
+	file        storage.Storage   // Storage interface for file operations
+	cfg         *config.Config    // Configuration injected at initialization
+	syncMode    storage.SyncMode  // When writes are fsynced before being acknowledged
+	writeMu     writeLock         // Orders log appends with their keyDir updates
+	commitMu    sync.Mutex        // Protects commitQueue
+	commitQueue []*commitRequest  // Batches waiting for the next group commit under SyncBatch
+	swapMu      sync.RWMutex      // Held for reading by Get and for writing while the keyDir or segments change
+	statsMu     sync.Mutex        // Protects deadBytes
+	deadBytes   map[uint32]int64  // Bytes per file id held by records the keyDir no longer references
+	merging     atomic.Bool       // Set while a merge is running
+	closed      atomic.Bool       // Set by Close
+	sealMu      sync.Mutex        // Serializes hint writers with merges, which replace sealed segments
+	bgWg        sync.WaitGroup    // Tracks background merges, hint writers and the reaper so Close can wait for them
+	done        chan struct{}     // Closed by Close to stop the reaper
+	snapMu      sync.Mutex        // Protects pinned and readers
+	pinned      map[uint32]int    // Retained versions referencing each segment, which Merge must not replace
+	readers     map[uint64]int    // Open snapshots by the sequence number they read at
+	cache       *cache.LRU        // Decoded values by record location, nil when CACHE_SIZE is 0
+	lastSeq     atomic.Uint64     // Highest sequence number assigned to a write, advanced under writeMu
+	visibleSeq  atomic.Uint64     // Highest sequence number applied to the keyDir, advanced under swapMu
+	watchMu     sync.Mutex        // Protects watchers
+	watchers    map[*Watcher]bool // Running watches, mapped to whether they still receive writes
+
+	// Superseded versions still readable by an open snapshot, guarded by swapMu
+	history  index.Index[*versionChain]
+	horizon  uint64 // Oldest sequence number whose versions are all still reachable
+	retained int    // Versions held in history
 }
 
 // NewKVEngine creates and initializes a new KVEngine instance.
@@ -61,7 +140,14 @@ func NewKVEngine(cfg *config.Config) (*KVEngine, error) {
 		return nil, fmt.Errorf("config cannot be nil")
 	}
 
-	slog.Info("engine: initializing KV engine")
+	syncMode, err := storage.ParseSyncMode(cfg.SYNC_MODE)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("engine: initializing KV engine",
+		"sync_mode", syncMode,
+		"cache_size", cfg.CACHE_SIZE)
 
 	file, err := storage.NewFile(cfg)
 	if err != nil {
@@ -69,14 +155,33 @@ func NewKVEngine(cfg *config.Config) (*KVEngine, error) {
 	}
 
 	engine := &KVEngine{
-		keyDir: NewKeyDir(),
-		file:   file,
-		cfg:    cfg,
+		keyDir:    NewKeyDir(),
+		file:      file,
+		cfg:       cfg,
+		syncMode:  syncMode,
+		writeMu:   newWriteLock(),
+		deadBytes: make(map[uint32]int64),
+		done:      make(chan struct{}),
+		pinned:    make(map[uint32]int),
+		readers:   make(map[uint64]int),
+		watchers:  make(map[*Watcher]bool),
+		history:   newHistory(),
+	}
+	if cfg.CACHE_SIZE > 0 {
+		engine.cache = cache.NewLRU(int64(cfg.CACHE_SIZE))
 	}
 
 	if err := engine.RecoverKeyDir(); err != nil {
+		// Release the data directory so it can be opened again
+		file.Close()
 		return nil, fmt.Errorf("failed to recover keyDir: %w", err)
 	}
+	file.SetSealHandler(engine.onSeal)
+
+	if cfg.REAP_INTERVAL > 0 {
+		engine.bgWg.Add(1)
+		go engine.runReaper(time.Duration(cfg.REAP_INTERVAL) * time.Second)
+	}
 
 	slog.Info("engine: KV engine initialized successfully")
 	return engine, nil
@@ -86,125 +191,141 @@ func NewKVEngine(cfg *config.Config) (*KVEngine, error) {
 // It first checks the in-memory key directory, then reads the record from disk.
 // Returns an error if the key is not found or if any I/O operation fails.
 func (e *KVEngine) Get(key string) (string, error) {
-	value, ok := e.keyDir.Load(key)
-	if !ok {
-		slog.Debug("get: key not found in keyDir",
-			"key", key)
-		return "", errors.New("key not found")
-	}
+	return get[string](context.Background(), e, key)
+}
 
-	keyEntry, ok := value.(*Key)
-	if !ok {
-		return "", fmt.Errorf("invalid key entry type for key %s", key)
-	}
+// valueType is the type a value is returned as: a string by Get or a byte slice
+// by GetBytes. Reading into either type copies the value out of the record
+// at most once.
+type valueType interface {
+	string | []byte
+}
 
-	slog.Debug("get: reading record from file",
-		"key", key,
-		"offset", keyEntry.Offset,
-		"size", keyEntry.Size)
+// get looks up key and returns its value as T.
+func get[T valueType](ctx context.Context, e *KVEngine, key string) (T, error) {
+	value, _, err := getVersion[T](ctx, e, key)
+	return value, err
+}
+
+// getVersion looks up key and returns its value as T together with its
+// version. Returns ctx's error if it is done once swapMu is held, before
+// the value is read from disk.
+func getVersion[T valueType](ctx context.Context, e *KVEngine, key string) (T, uint64, error) {
+	var zero T
 
-	// Check if we need to flush unflushed data before reading
-	if err := e.ensureDataFlushed(keyEntry); err != nil {
-		return "", fmt.Errorf("failed to ensure data flushed: %w", err)
+	// A merge must not swap segments between the lookup and the read
+	e.swapMu.RLock()
+	defer e.swapMu.RUnlock()
+	if err := ctx.Err(); err != nil {
+		return zero, 0, err
 	}
 
-	data, err := e.file.ReadAt(keyEntry.Offset, keyEntry.Size)
-	if err != nil {
-		return "", fmt.Errorf("failed to read data from file at offset %d: %w", keyEntry.Offset, err)
+	keyEntry, ok := e.keyDir.Load(key)
+	if !ok {
+		slog.Debug("get: key not found in keyDir",
+			"key", key)
+		return zero, 0, ErrKeyNotFound
 	}
 
-	record, err := format.Decode(data, e.cfg.HEADER_SIZE)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode record for key %s: %w", key, err)
+	if keyEntry.Expired(nowMillis()) {
+		slog.Debug("get: key expired",
+			"key", key,
+			"expiry", keyEntry.Expiry)
+		return zero, 0, ErrKeyNotFound
 	}
 
-	if record.Flag == format.FlagTombstone {
-		slog.Debug("get: record is tombstone",
-			"key", key)
-		return "", errors.New("key not found")
+	value, err := readValue[T](e, key, keyEntry)
+	if err != nil {
+		return zero, 0, err
 	}
 
 	slog.Info("get: success",
 		"key", key,
-		"value_size", len(record.Value),
-		"timestamp", record.Timestamp)
-	return string(record.Value), nil
+		"value_size", len(value))
+	return value, keyEntry.Seq, nil
 }
 
-// ensureDataFlushed checks if the data at the given offset is in the buffer
-// and flushes it to disk if necessary. This ensures reads can access the data.
-func (e *KVEngine) ensureDataFlushed(keyEntry *Key) error {
-	// Type assertion to access file-specific methods
-	file, ok := e.file.(*storage.File)
-	if !ok {
-		return nil // Not a File type, skip flush check
+// readValue reads and decodes the record at the location of keyEntry and
+// returns its value as T. The caller must keep the segment holding it from
+// being swapped out by a merge while the read is in flight.
+func readValue[T valueType](e *KVEngine, key string, keyEntry Key) (T, error) {
+	var zero T
+
+	// A record never changes at its location, so a cached value is current
+	// until a merge replaces the segment, which purges it from the cache
+	loc := cache.Location{FileId: keyEntry.FileId, Offset: keyEntry.Offset}
+	if e.cache != nil {
+		if value, ok := e.cache.Get(loc); ok {
+			return T(value), nil
+		}
 	}
 
-	shouldFlush, err := file.ShouldFlushBeforeRead(keyEntry.Offset)
+	record, err := e.readRecord(key, keyEntry)
 	if err != nil {
-		return fmt.Errorf("failed to check if flush needed: %w", err)
+		return zero, err
 	}
 
-	if shouldFlush {
-		if err := e.file.Flush(); err != nil {
-			return fmt.Errorf("failed to flush buffer: %w", err)
-		}
+	value := T(record.Value)
+	if e.cache != nil {
+		e.cache.Add(loc, string(value))
 	}
-
-	return nil
+	return value, nil
 }
 
-// Put stores a key-value pair in the database.
-// It encodes the record, appends it to the log file, and updates the
-// in-memory key directory. Returns an error if encoding or I/O fails.
-func (e *KVEngine) Put(key string, value string) error {
-	record := &format.Record{
-		Timestamp: uint64(time.Now().Unix()),
-		Keysize:   uint32(len(key)),
-		Valuesize: uint32(len(value)),
-		Flag:      format.FlagNormal,
-		Key:       []byte(key),
-		Value:     []byte(value),
-	}
+// readRecord reads and decodes the record at the location of keyEntry,
+// bypassing the cache. Returns ErrKeyNotFound if it is a tombstone. The
+// caller must keep the segment holding it from being swapped out by a
+// merge while the read is in flight.
+func (e *KVEngine) readRecord(key string, keyEntry Key) (*format.Record, error) {
+	slog.Debug("get: reading record from file",
+		"key", key,
+		"file_id", keyEntry.FileId,
+		"offset", keyEntry.Offset,
+		"size", keyEntry.Size)
 
-	data, err := record.Encode(e.cfg.HEADER_SIZE)
-	if err != nil {
-		return fmt.Errorf("failed to encode record for key %s: %w", key, err)
-	}
-	commitRecord := &format.Record{
-		Timestamp: uint64(time.Now().Unix()),
-		Keysize:   0,
-		Valuesize: 0,
-		Flag:      format.FlagCommit,
-		Key:       []byte{},
-		Value:     nil,
-	}
-	commitData, err := commitRecord.Encode(e.cfg.HEADER_SIZE)
+	// The record is decoded straight from the mapping of a mapped segment;
+	// Decode copies the key and value out of it
+	var record *format.Record
+	err := e.file.ReadView(keyEntry.FileId, keyEntry.Offset, keyEntry.Size, func(data []byte) error {
+		var err error
+		record, err = format.Decode(data, e.cfg.HEADER_SIZE)
+		if err != nil {
+			return fmt.Errorf("failed to decode record for key %s: %w", key, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to encode commit record: %w", err)
+		return nil, fmt.Errorf("failed to read record from file %d at offset %d: %w", keyEntry.FileId, keyEntry.Offset, err)
 	}
 
-	offset, err := e.file.Append(append(data, commitData...))
-	if err != nil {
-		return fmt.Errorf("failed to append data to file for key %s: %w", key, err)
+	if record.Flag == format.FlagTombstone {
+		slog.Debug("get: record is tombstone",
+			"key", key)
+		return nil, ErrKeyNotFound
 	}
+	return record, nil
+}
 
-	recordSize := record.Valuesize + record.Keysize + e.cfg.HEADER_SIZE
-	keyEntry := &Key{
-		FileId: 0, // Single file implementation
-		Size:   recordSize,
-		Offset: offset,
-	}
+// Put stores a key-value pair in the database.
+// It appends the record and its commit marker to the log file and updates
+// the in-memory key directory. Returns an error if encoding or I/O fails.
+func (e *KVEngine) Put(key string, value string) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
 
-	e.keyDir.Store(key, keyEntry)
+	fileId, offset, err := e.write(context.Background(), batch)
+	if err != nil {
+		return fmt.Errorf("failed to put key %s: %w", key, err)
+	}
 
 	slog.Info("put: success",
 		"key", key,
+		"file_id", fileId,
 		"offset", offset,
-		"record_size", recordSize,
 		"key_size", len(key),
-		"value_size", len(value),
-		"timestamp", record.Timestamp)
+		"value_size", len(value))
+
+	e.maybeMerge()
 	return nil
 }
 
@@ -212,36 +333,40 @@ func (e *KVEngine) Put(key string, value string) error {
 // The key is removed from the in-memory key directory immediately.
 // Returns an error if encoding or I/O fails.
 func (e *KVEngine) Delete(key string) error {
-	record := &format.Record{
-		Timestamp: uint64(time.Now().Unix()),
-		Keysize:   uint32(len(key)),
-		Valuesize: 0,
-		Flag:      format.FlagTombstone,
-		Key:       []byte(key),
-		Value:     nil,
-	}
+	batch := NewWriteBatch()
+	batch.Delete(key)
 
-	data, err := record.Encode(e.cfg.HEADER_SIZE)
+	fileId, offset, err := e.write(context.Background(), batch)
 	if err != nil {
-		return fmt.Errorf("failed to encode tombstone record for key %s: %w", key, err)
+		return fmt.Errorf("failed to delete key %s: %w", key, err)
 	}
 
-	offset, err := e.file.Append(data)
-	if err != nil {
-		return fmt.Errorf("failed to append tombstone to file for key %s: %w", key, err)
-	}
-
-	e.keyDir.Delete(key)
-
 	slog.Info("delete: success",
 		"key", key,
+		"file_id", fileId,
 		"offset", offset)
+
+	e.maybeMerge()
 	return nil
 }
 
-// Close gracefully shuts down the KV engine, flushing any pending writes
-// and closing the storage file. Returns an error if closing fails.
+// Close gracefully shuts down the KV engine, ending every watch and waiting
+// for any background merge, hint writer or reaper, flushing any pending writes and closing the storage file.
+// Writes, expiries and merges started after Close return ErrClosed.
+// Returns ErrClosed if the engine was already closed, or an error if closing fails.
 func (e *KVEngine) Close() error {
+	if !e.closed.CompareAndSwap(false, true) {
+		return ErrClosed
+	}
+	close(e.done)
+	e.closeWatchers()
+	e.bgWg.Wait()
+
+	// Let a write that got past the closed check finish before the file
+	// closes; any later one is refused by the file
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
 	if e.file != nil {
 		keyCount := e.GetKeyDirSize()
 
@@ -255,132 +380,278 @@ func (e *KVEngine) Close() error {
 
 // GetKeyDirSize returns the number of keys currently in the in-memory key directory.
 func (e *KVEngine) GetKeyDirSize() int {
-	count := 0
-	e.keyDir.Range(func(key, value interface{}) bool {
-		count++
-		return true
-	})
-	return count
+	return e.keyDir.Len()
 }
 
-// RecoverKeyDir rebuilds the in-memory key directory by scanning the log file
-// from the beginning. It processes all records, handling tombstones appropriately,
-// and reconstructs the key-to-offset mapping. Returns an error if recovery fails.
+// RecoverKeyDir rebuilds the in-memory key directory from every log segment,
+// oldest to newest. Sealed segments are loaded from their hint files when a
+// valid one exists; the active file and segments without a usable hint are
+// scanned record by record. Tombstones are handled appropriately and the
+// key-to-location mapping is reconstructed. Sequence numbers resume after the
+// highest one found. Returns an error if recovery fails.
 func (e *KVEngine) RecoverKeyDir() error {
-	file, ok := e.file.(*storage.File)
-	if !ok {
-		return fmt.Errorf("file interface is not a File type, cannot recover")
-	}
+	e.sealMu.Lock()
+	defer e.sealMu.Unlock()
 
-	reader := bufio.NewReader(file.GetFile())
-	count, err := e.scanLogFile(reader)
-	if err != nil {
-		return fmt.Errorf("failed to scan log file: %w", err)
+	e.statsMu.Lock()
+	e.deadBytes = make(map[uint32]int64)
+	e.statsMu.Unlock()
+
+	count := 0
+	fromHints := 0
+	maxSeq := uint64(0)
+	segments := e.file.Segments()
+	activeId := segments[len(segments)-1]
+	for _, fileId := range segments {
+		sealed := fileId != activeId
+
+		var hints []*format.Hint
+		var segment format.SegmentInfo
+		var err error
+		if sealed {
+			hints, segment, err = e.readHintFile(fileId)
+			if err == nil {
+				fromHints++
+			} else if !os.IsNotExist(err) {
+				slog.Warn("recoverKeyDir: ignoring invalid hint file",
+					"file_id", fileId,
+					"error", err)
+			}
+		}
+
+		if hints == nil {
+			hints, segment, err = e.scanSegment(fileId)
+			if err != nil {
+				return err
+			}
+			// Drop a torn tail so new appends follow the last complete write
+			if !sealed {
+				if err := e.file.TruncateActive(segment.Size); err != nil {
+					return fmt.Errorf("failed to truncate active file: %w", err)
+				}
+			}
+			// Spare the next startup the full scan of this segment
+			if sealed {
+				if err := e.writeHintFile(fileId, hints, segment.MaxSeq); err != nil {
+					slog.Warn("recoverKeyDir: failed to write hint file",
+						"file_id", fileId,
+						"error", err)
+				}
+			}
+		}
+
+		maxSeq = max(maxSeq, segment.MaxSeq)
+		for _, hint := range hints {
+			if e.applyHint(hint) {
+				count++
+			}
+			maxSeq = max(maxSeq, hint.Seq)
+		}
 	}
+	e.lastSeq.Store(maxSeq)
+	e.visibleSeq.Store(maxSeq)
+	e.horizon = maxSeq
 
 	slog.Info("recoverKeyDir: recovered keyDir",
-		"size", count)
+		"segments", len(segments),
+		"from_hints", fromHints,
+		"records", count,
+		"last_seq", maxSeq,
+		"size", e.GetKeyDirSize())
 
 	return nil
 }
 
-// scanLogFile scans the entire log file and rebuilds the key directory.
-// It processes records sequentially, handling tombstones and normal records.
-// Returns the count of recovered keys and any error encountered.
-func (e *KVEngine) scanLogFile(reader *bufio.Reader) (int, error) {
-	count := 0
-	currentOffset := int64(0)
+// scanSegment reads a whole log segment and returns its committed records
+// together with the offset just past the last commit marker and the highest
+// sequence number its commit markers carry.
+func (e *KVEngine) scanSegment(fileId uint32) ([]*format.Hint, format.SegmentInfo, error) {
+	segment, err := e.file.SegmentReader(fileId)
+	if err != nil {
+		return nil, format.SegmentInfo{}, fmt.Errorf("failed to open segment %d: %w", fileId, err)
+	}
 
-	type recordWithOffsetAndSize struct {
-		record *format.Record
-		offset int64
-		size   int
+	hints, info, err := e.scanLogFile(bufio.NewReader(segment), fileId)
+	if err != nil {
+		return nil, format.SegmentInfo{}, fmt.Errorf("failed to scan segment %d: %w", fileId, err)
 	}
+	return hints, info, nil
+}
+
+// scanLogFile scans a single log segment and returns a hint for every
+// committed record in log order, together with the offset just past the
+// last commit marker as the segment size and the highest sequence number
+// carried by a commit marker.
+func (e *KVEngine) scanLogFile(reader *bufio.Reader, fileId uint32) ([]*format.Hint, format.SegmentInfo, error) {
+	hints := make([]*format.Hint, 0)
+	info, err := e.readCommitted(reader, fileId, func(record *format.Record, offset int64, size int) {
+		hints = append(hints, &format.Hint{
+			Timestamp: record.Timestamp,
+			FileId:    fileId,
+			Keysize:   record.Keysize,
+			Size:      uint32(size),
+			Offset:    offset,
+			Expiry:    record.Expiry,
+			Seq:       record.Seq,
+			Flag:      record.Flag,
+			Key:       record.Key,
+		})
+	})
+	if err != nil {
+		return nil, format.SegmentInfo{}, err
+	}
+	return hints, info, nil
+}
+
+// readCommitted reads a single log segment and calls fn for every committed
+// record in log order with its offset and encoded size. Records are
+// buffered until the commit marker that follows them is read and only
+// passed on if the record count and checksum it carries match, so a batch
+// torn by a crash is never recovered. Returns the offset just past the last
+// commit marker as the segment size, the highest sequence number carried by
+// a commit marker and any error encountered.
+func (e *KVEngine) readCommitted(reader *bufio.Reader, fileId uint32, fn func(record *format.Record, offset int64, size int)) (format.SegmentInfo, error) {
+	return readBatches(reader, e.cfg.HEADER_SIZE, fileId, func(batch []committedRecord, _ *format.Record) {
+		for _, c := range batch {
+			fn(c.record, c.offset, len(c.raw))
+		}
+	})
+}
+
+// committedRecord is a record read back from a log segment together with
+// its encoded bytes and offset.
+type committedRecord struct {
+	record *format.Record
+	raw    []byte
+	offset int64
+}
+
+// readBatches reads a single log segment and calls fn for every batch whose
+// commit marker matches its records, with the records in log order and the
+// commit marker itself. See readCommitted.
+func readBatches(reader *bufio.Reader, headerSize uint32, fileId uint32, fn func(batch []committedRecord, commit *format.Record)) (format.SegmentInfo, error) {
+	currentOffset := int64(0)
+	committedEnd := int64(0)
+	maxSeq := uint64(0)
 
-	recordsToCommit := make([]recordWithOffsetAndSize, 0)
+	recordsToCommit := make([]committedRecord, 0)
+	batchCRC := uint32(0)
 
 	for {
-		record, recordSize, err := e.readNextRecord(reader, currentOffset)
+		record, raw, err := readNextRecord(reader, headerSize, currentOffset)
 		if err == io.EOF {
 			break // End of file reached normally
 		}
 		if err != nil {
-			return 0, fmt.Errorf("failed to read record at offset %d: %w", currentOffset, err)
+			return format.SegmentInfo{}, fmt.Errorf("failed to read record at offset %d: %w", currentOffset, err)
 		}
+		recordSize := len(raw)
 
 		if record.Flag == format.FlagCommit {
-			for _, record := range recordsToCommit {
-				if e.processRecoveredRecord(record.record, record.offset, record.size) {
-					count++
-				}
+			count, checksum, ok := record.CommitInfo()
+			if ok && (count != uint32(len(recordsToCommit)) || checksum != batchCRC) {
+				slog.Warn("recoverKeyDir: discarding batch that does not match its commit marker",
+					"file_id", fileId,
+					"offset", currentOffset,
+					"records", len(recordsToCommit),
+					"expected_records", count)
+			} else {
+				fn(recordsToCommit, record)
 			}
-			recordsToCommit = make([]recordWithOffsetAndSize, 0)
+			recordsToCommit = make([]committedRecord, 0)
+			batchCRC = 0
+			committedEnd = currentOffset + int64(recordSize)
+			maxSeq = max(maxSeq, record.CommitSeq())
 		} else {
-			recordsToCommit = append(recordsToCommit, recordWithOffsetAndSize{
-				record: record,
-				size:   recordSize,
-			})
+			batchCRC = crc32.Update(batchCRC, crc32.IEEETable, raw)
+			recordsToCommit = append(recordsToCommit, committedRecord{record: record, raw: raw, offset: currentOffset})
 		}
 
 		currentOffset += int64(recordSize)
 	}
 
-	return count, nil
+	if len(recordsToCommit) > 0 {
+		slog.Warn("recoverKeyDir: discarding uncommitted records at end of segment",
+			"file_id", fileId,
+			"records", len(recordsToCommit))
+	}
+
+	return format.SegmentInfo{Size: committedEnd, MaxSeq: maxSeq}, nil
 }
 
-// processRecoveredRecord processes a single recovered record, updating the
-// key directory appropriately based on whether it's a tombstone or normal record.
+// applyHint applies a single committed record to the key directory
+// appropriately based on whether it's a tombstone or normal record, and
+// accounts for the bytes it makes dead. A record that has already expired
+// removes the key like a tombstone would. The version it supersedes is
+// retained if an open snapshot may still read it.
 // Returns true if a key was added (not a tombstone), false otherwise.
-func (e *KVEngine) processRecoveredRecord(record *format.Record, offset int64, size int) bool {
-	key := string(record.Key)
-	if record.Flag == format.FlagTombstone {
-		slog.Debug("recoverKeyDir: tombstone record detected",
-			"key", key)
-		e.keyDir.Delete(key)
+func (e *KVEngine) applyHint(hint *format.Hint) bool {
+	key := bytesToString(hint.Key)
+	expired := hint.Expiry != 0 && nowMillis() >= hint.Expiry
+	if hint.Flag == format.FlagTombstone || expired {
+		slog.Debug("engine: tombstone or expired record applied",
+			"key", key,
+			"expired", expired)
+		e.markDead(hint.FileId, hint.Size)
+		if prev, loaded := e.keyDir.LoadAndDelete(key); loaded {
+			e.markDead(prev.FileId, prev.Size)
+			e.retain(key, prev, hint.Seq)
+		}
 		return false
 	}
 
-	e.keyDir.Store(key, &Key{
-		FileId: 0,
-		Size:   uint32(size),
-		Offset: offset,
+	prev, loaded := e.keyDir.Swap(key, Key{
+		FileId: hint.FileId,
+		Size:   hint.Size,
+		Offset: hint.Offset,
+		Expiry: hint.Expiry,
+		Seq:    hint.Seq,
 	})
+	if loaded {
+		e.markDead(prev.FileId, prev.Size)
+		e.retain(key, prev, hint.Seq)
+	}
 	return true
 }
 
 // readNextRecord reads a single record from the reader, handling incomplete
-// records at the end of the file. Returns the decoded record, its total size,
-// and any error encountered.
-func (e *KVEngine) readNextRecord(reader *bufio.Reader, currentOffset int64) (*format.Record, int, error) {
-	headerBuf := make([]byte, e.cfg.HEADER_SIZE)
-	_, err := io.ReadFull(reader, headerBuf)
+// records at the end of the file. Returns the decoded record, its encoded
+// bytes, and any error encountered.
+func readNextRecord(reader *bufio.Reader, headerSize uint32, currentOffset int64) (*format.Record, []byte, error) {
+	headerBuf := make([]byte, headerSize)
+	bytesRead, err := io.ReadFull(reader, headerBuf)
+	if err == io.ErrUnexpectedEOF {
+		slog.Warn("recoverKeyDir: incomplete header detected at end of file, stopping recovery",
+			"offset", currentOffset,
+			"bytes_read", bytesRead)
+		return nil, nil, io.EOF
+	}
 	if err != nil {
-		return nil, 0, err
+		return nil, nil, err
 	}
 
 	keySize := binary.LittleEndian.Uint32(headerBuf[12:16])
 	valSize := binary.LittleEndian.Uint32(headerBuf[16:20])
-	totalRecordSize := int(e.cfg.HEADER_SIZE) + int(keySize) + int(valSize)
 
 	bodyBuf := make([]byte, keySize+valSize)
-	bytesRead, err := io.ReadFull(reader, bodyBuf)
+	bytesRead, err = io.ReadFull(reader, bodyBuf)
 	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		// Incomplete record - file was likely truncated or write was interrupted
 		slog.Warn("recoverKeyDir: incomplete record detected at end of file, stopping recovery",
 			"offset", currentOffset,
 			"expected_body_size", keySize+valSize,
 			"bytes_read", bytesRead)
-		return nil, 0, io.EOF
+		return nil, nil, io.EOF
 	}
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to read record body: %w", err)
+		return nil, nil, fmt.Errorf("failed to read record body: %w", err)
 	}
 
 	fullRecord := append(headerBuf, bodyBuf...)
-	record, err := format.Decode(fullRecord, e.cfg.HEADER_SIZE)
+	record, err := format.Decode(fullRecord, headerSize)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to decode record: %w", err)
+		return nil, nil, fmt.Errorf("failed to decode record: %w", err)
 	}
 
-	return record, totalRecordSize, nil
+	return record, fullRecord, nil
 }
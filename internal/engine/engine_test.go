@@ -2,12 +2,17 @@
 package engine
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jassi-singh/aether-kv/internal/config"
+	"github.com/jassi-singh/aether-kv/internal/storage"
 )
 
 // setupTestConfig creates a temporary test configuration.
@@ -300,3 +305,243 @@ func TestKVEngine_RecoverKeyDir(t *testing.T) {
 		}
 	}
 }
+
+func TestKVEngine_RecoverAcrossSegments(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 128
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := engine.Put(key, fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatalf("Failed to put key: %v", err)
+		}
+	}
+	// Overwrite and delete keys that now live in older segments
+	if err := engine.Put("key0", "updated"); err != nil {
+		t.Fatalf("Failed to put key: %v", err)
+	}
+	if err := engine.Delete("key1"); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+
+	if segments := engine.file.Segments(); len(segments) < 2 {
+		t.Fatalf("expected the log to be rotated, got segments %v", segments)
+	}
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+
+	reopened, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer reopened.Close()
+
+	if size := reopened.GetKeyDirSize(); size != 19 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 19)
+	}
+	if got, err := reopened.Get("key0"); err != nil || got != "updated" {
+		t.Errorf("Get(key0) = %q, %v, want %q", got, err, "updated")
+	}
+	if _, err := reopened.Get("key1"); err == nil {
+		t.Error("Get(key1) succeeded after delete and recovery")
+	}
+	for i := 2; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		want := fmt.Sprintf("value%d", i)
+		if got, err := reopened.Get(key); err != nil || got != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+}
+
+func TestKVEngine_Stats(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 128
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := engine.Put("key", fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	if err := engine.Put("other", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Keys != 2 {
+		t.Errorf("Stats().Keys = %d, want 2", stats.Keys)
+	}
+	if stats.Segments < 2 || stats.ActiveFileId != uint32(stats.Segments-1) {
+		t.Errorf("Stats() segments = %d, active file id = %d, want several segments ending with the active file",
+			stats.Segments, stats.ActiveFileId)
+	}
+	if stats.DeadBytes <= 0 || stats.DiskBytes <= stats.DeadBytes {
+		t.Errorf("Stats() disk bytes = %d, dead bytes = %d, want some dead bytes below the disk usage",
+			stats.DiskBytes, stats.DeadBytes)
+	}
+}
+
+func TestKVEngine_LimitsAndClose(t *testing.T) {
+	engine, err := NewKVEngine(setupTestConfig(t))
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	if err := engine.Put(strings.Repeat("k", MaxKeySize+1), "value"); !errors.Is(err, ErrKeyTooLarge) {
+		t.Errorf("Put() with oversized key error = %v, want %v", err, ErrKeyTooLarge)
+	}
+	if err := engine.Put(strings.Repeat("k", MaxKeySize), "value"); err != nil {
+		t.Errorf("Put() with key of MaxKeySize error = %v", err)
+	}
+	if engine.GetKeyDirSize() != 1 {
+		t.Errorf("GetKeyDirSize() = %d, want 1", engine.GetKeyDirSize())
+	}
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := engine.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close() error = %v, want %v", err, ErrClosed)
+	}
+
+	// Nothing is accepted once closed, since it could never reach disk
+	if err := engine.Put("key", "value"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Put() after Close() error = %v, want %v", err, ErrClosed)
+	}
+	batch := NewWriteBatch()
+	batch.Delete("key")
+	if err := engine.Write(batch); !errors.Is(err, ErrClosed) {
+		t.Errorf("Write() after Close() error = %v, want %v", err, ErrClosed)
+	}
+	if _, err := engine.Expire(strings.Repeat("k", MaxKeySize), time.Hour); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expire() after Close() error = %v, want %v", err, ErrClosed)
+	}
+	if err := engine.Merge(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Merge() after Close() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestKVEngine_WritesRacingClose(t *testing.T) {
+	for _, mode := range []string{"periodic", "batch"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := setupTestConfig(t)
+			cfg.SYNC_MODE = mode
+			cfg.MAX_FILE_SIZE = 512
+			engine, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+
+			// A write either lands before Close and survives a reopen, or is
+			// refused; none is acknowledged and lost
+			var wg sync.WaitGroup
+			acked := make([]map[string]bool, 4)
+			for w := range acked {
+				acked[w] = make(map[string]bool)
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; ; i++ {
+						key := fmt.Sprintf("w%d:%d", w, i)
+						if err := engine.Put(key, "value"); err != nil {
+							if !errors.Is(err, ErrClosed) && !errors.Is(err, storage.ErrFailed) {
+								t.Errorf("Put() racing Close() error = %v", err)
+							}
+							return
+						}
+						acked[w][key] = true
+					}
+				}(w)
+			}
+			time.Sleep(20 * time.Millisecond)
+			if err := engine.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			wg.Wait()
+
+			reopened, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to reopen engine: %v", err)
+			}
+			defer reopened.Close()
+			for _, keys := range acked {
+				for key := range keys {
+					if _, err := reopened.Get(key); err != nil {
+						t.Fatalf("Get(%s) of an acknowledged write after reopen error = %v", key, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestKVEngine_Cache(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+	cfg.CACHE_SIZE = 1 << 20
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	values := make(map[string]string)
+	put := func(key, value string) {
+		t.Helper()
+		if err := engine.Put(key, value); err != nil {
+			t.Fatalf("Failed to put key: %v", err)
+		}
+		values[key] = value
+	}
+	check := func() {
+		t.Helper()
+		for key, want := range values {
+			if got, err := engine.Get(key); err != nil || got != want {
+				t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, want)
+			}
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d-0", i))
+	}
+	check()
+	check()
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Cache == nil || stats.Cache.Hits != 10 || stats.Cache.Misses != 10 {
+		t.Errorf("Stats().Cache = %+v, want 10 hits and 10 misses", stats.Cache)
+	}
+
+	// A write moves the key to a new location, so its cached value is not served
+	for round := 1; round <= 3; round++ {
+		put("key0", fmt.Sprintf("value0-%d", round))
+	}
+	check()
+
+	// The merged segments reuse the input file ids, so the oldest records'
+	// offsets now hold other records; their cached values must not be served
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("KVEngine.Merge() error = %v", err)
+	}
+	check()
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/jassi-singh/aether-kv/internal/format"
+)
+
+// onSeal is registered with storage and runs for every segment sealed by
+// rotation. It writes the segment's hint file in the background so the
+// write path never waits on it.
+func (e *KVEngine) onSeal(fileId uint32) {
+	// Close no longer waits for new background work; recovery scans the
+	// segment instead
+	if e.closed.Load() {
+		return
+	}
+	e.bgWg.Add(1)
+	go func() {
+		defer e.bgWg.Done()
+
+		e.sealMu.Lock()
+		defer e.sealMu.Unlock()
+
+		hints, segment, err := e.scanSegment(fileId)
+		if err == nil {
+			err = e.writeHintFile(fileId, hints, segment.MaxSeq)
+		}
+		if err != nil {
+			// Recovery falls back to scanning the segment
+			slog.Warn("engine: failed to write hint file for sealed segment",
+				"file_id", fileId,
+				"error", err)
+			return
+		}
+
+		slog.Debug("engine: hint file written",
+			"file_id", fileId,
+			"hints", len(hints))
+	}()
+}
+
+// readHintFile loads the hints of a sealed segment. A hint is only valid if
+// its checksum matches and it was built from a segment of the current size.
+// The returned error satisfies os.IsNotExist if the segment has no hint.
+func (e *KVEngine) readHintFile(fileId uint32) ([]*format.Hint, format.SegmentInfo, error) {
+	data, err := e.file.ReadHint(fileId)
+	if err != nil {
+		return nil, format.SegmentInfo{}, err
+	}
+
+	hints, hinted, err := format.DecodeHints(data)
+	if err != nil {
+		return nil, format.SegmentInfo{}, err
+	}
+
+	segmentSize, err := e.file.SegmentSize(fileId)
+	if err != nil {
+		return nil, format.SegmentInfo{}, err
+	}
+	if hinted.Size != segmentSize {
+		return nil, format.SegmentInfo{}, fmt.Errorf("hint built from %d bytes but segment holds %d", hinted.Size, segmentSize)
+	}
+
+	for _, hint := range hints {
+		if hint.FileId != fileId {
+			return nil, format.SegmentInfo{}, fmt.Errorf("hint for file %d found in hint file of segment %d", hint.FileId, fileId)
+		}
+	}
+	return hints, hinted, nil
+}
+
+// writeHintFile encodes the hints of a sealed segment whose commit markers
+// carry sequence numbers up to maxSeq and stores them.
+func (e *KVEngine) writeHintFile(fileId uint32, hints []*format.Hint, maxSeq uint64) error {
+	segmentSize, err := e.file.SegmentSize(fileId)
+	if err != nil {
+		return err
+	}
+	return e.file.WriteHint(fileId, format.EncodeHints(hints, format.SegmentInfo{Size: segmentSize, MaxSeq: maxSeq}))
+}
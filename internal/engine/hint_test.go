@@ -0,0 +1,154 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jassi-singh/aether-kv/internal/config"
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+// writeRotatedLog fills several segments with keys and closes the engine,
+// returning the ids of the sealed segments.
+func writeRotatedLog(t *testing.T, cfg *config.Config) []uint32 {
+	t.Helper()
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatalf("Failed to put key: %v", err)
+		}
+	}
+	if err := engine.Delete("key0"); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	segments := engine.file.Segments()
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("expected the log to be rotated, got segments %v", segments)
+	}
+	return segments[:len(segments)-1]
+}
+
+func TestKVEngine_HintFilesWrittenOnRotation(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 128
+
+	sealed := writeRotatedLog(t, cfg)
+	for _, fileId := range sealed {
+		path := filepath.Join(cfg.DATA_DIR, storage.HintFileName(fileId))
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("hint file %s missing: %v", path, err)
+		}
+	}
+
+	// Corrupt every value in the sealed segments: recovery from hints must
+	// not read them, so it still succeeds.
+	for _, fileId := range sealed {
+		path := filepath.Join(cfg.DATA_DIR, storage.SegmentFileName(fileId))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read segment: %v", err)
+		}
+		for i := range data {
+			if data[i] >= '0' && data[i] <= '9' {
+				data[i] = 'x'
+			}
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("Failed to write segment: %v", err)
+		}
+	}
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("NewKVEngine() with hint files error = %v", err)
+	}
+	defer engine.Close()
+
+	if size := engine.GetKeyDirSize(); size != 19 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 19)
+	}
+	if _, err := engine.Get("key0"); err == nil {
+		t.Error("Get(key0) succeeded after delete and recovery from hints")
+	}
+}
+
+func TestKVEngine_RecoverWithInvalidHint(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 128
+
+	sealed := writeRotatedLog(t, cfg)
+	hintPath := filepath.Join(cfg.DATA_DIR, storage.HintFileName(sealed[0]))
+	if err := os.WriteFile(hintPath, []byte("not a hint file"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt hint file: %v", err)
+	}
+	os.Remove(filepath.Join(cfg.DATA_DIR, storage.HintFileName(sealed[1])))
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer engine.Close()
+
+	if size := engine.GetKeyDirSize(); size != 19 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 19)
+	}
+	for i := 1; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		want := fmt.Sprintf("value%d", i)
+		if got, err := engine.Get(key); err != nil || got != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+
+	// The scan replaces the unusable hints with valid ones
+	for _, fileId := range sealed[:2] {
+		if _, _, err := engine.readHintFile(fileId); err != nil {
+			t.Errorf("readHintFile(%d) after recovery error = %v", fileId, err)
+		}
+	}
+}
+
+func TestKVEngine_MergeWritesHints(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 128
+
+	writeRotatedLog(t, cfg)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("KVEngine.Merge() error = %v", err)
+	}
+	segments := engine.file.Segments()
+	for _, fileId := range segments[:len(segments)-1] {
+		if _, _, err := engine.readHintFile(fileId); err != nil {
+			t.Errorf("readHintFile(%d) after merge error = %v", fileId, err)
+		}
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+
+	reopened, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer reopened.Close()
+	for i := 1; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		want := fmt.Sprintf("value%d", i)
+		if got, err := reopened.Get(key); err != nil || got != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package engine
+
+import "context"
+
+// writeLock is a mutex whose acquisition can be abandoned once a context is
+// done. It guards the write path, where a caller may otherwise wait behind
+// a slow fsync or a full reaper sweep. It must be created with
+// newWriteLock.
+type writeLock chan struct{}
+
+// newWriteLock creates an unlocked writeLock.
+func newWriteLock() writeLock {
+	return make(writeLock, 1)
+}
+
+// Lock acquires the lock, waiting as long as it takes.
+func (l writeLock) Lock() {
+	l <- struct{}{}
+}
+
+// LockContext acquires the lock unless ctx is done first, in which case it
+// returns the context's error without holding the lock.
+func (l writeLock) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Unlock releases the lock.
+func (l writeLock) Unlock() {
+	<-l
+}
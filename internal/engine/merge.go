@@ -0,0 +1,300 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/format"
+)
+
+// ErrMergeInProgress is returned by Merge when another merge is already running.
+var ErrMergeInProgress = errors.New("merge already in progress")
+
+// mergeChunkSize is the most record bytes a merge copies under one commit
+// marker.
+const mergeChunkSize = 1 << 20
+
+// mergeChunk is a run of records a merge appended under one commit marker.
+type mergeChunk struct {
+	fileId  uint32 // Output segment holding the chunk
+	records int    // Records committed by the marker
+	marker  int64  // Size of the commit marker
+}
+
+// Merge compacts every sealed segment by rewriting only the records the
+// keyDir still references into fresh segments and swapping them in. Stale
+// versions and tombstones are dropped: the merge always covers the oldest
+// segments, so nothing older remains for a tombstone to shadow. Gets and
+// Puts keep running while live records are copied; Gets only pause for the
+// final swap. Keys written during the merge keep their newer location.
+// Returns ErrSegmentsPinned without merging while a sealed segment holds a
+// superseded version an open snapshot may still read, so compaction never
+// gets ahead of the oldest snapshot, and ErrClosed once the engine is closed.
+func (e *KVEngine) Merge() error {
+	if e.closed.Load() {
+		return ErrClosed
+	}
+	if !e.merging.CompareAndSwap(false, true) {
+		return ErrMergeInProgress
+	}
+	defer e.merging.Store(false)
+
+	// Hint writers must not describe a segment this merge is replacing
+	e.sealMu.Lock()
+	defer e.sealMu.Unlock()
+
+	segments := e.file.Segments()
+	inputs := segments[:len(segments)-1]
+	if len(inputs) == 0 {
+		slog.Debug("merge: no sealed segments to merge")
+		return nil
+	}
+	if e.anyPinned(inputs) {
+		return ErrSegmentsPinned
+	}
+
+	start := time.Now()
+	// Every record of the inputs was numbered by now. The merged segments
+	// carry this floor so sequence numbers never go back after a restart,
+	// even once the records holding the highest ones are dropped.
+	floor := e.lastSeq.Load()
+	inputSet := make(map[uint32]bool, len(inputs))
+	for _, id := range inputs {
+		inputSet[id] = true
+	}
+
+	type liveRecord struct {
+		key   string
+		entry Key
+	}
+
+	// Copy in log order so each input segment is read sequentially.
+	// Expired keys are copied too: only the reaper deletes them, with a
+	// tombstone watchers are told about.
+	live := make([]liveRecord, 0)
+	e.keyDir.Ascend("", "", func(key string, entry Key) bool {
+		if inputSet[entry.FileId] {
+			live = append(live, liveRecord{key: key, entry: entry})
+		}
+		return true
+	})
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].entry.FileId != live[j].entry.FileId {
+			return live[i].entry.FileId < live[j].entry.FileId
+		}
+		return live[i].entry.Offset < live[j].entry.Offset
+	})
+
+	writer, err := e.file.NewMergeWriter(inputs)
+	if err != nil {
+		return fmt.Errorf("failed to start merge: %w", err)
+	}
+
+	// Records are copied in chunks, each appended with one commit marker
+	// covering all of its records, so the merged segments carry a marker per
+	// chunk instead of one per record
+	relocated := make([]Key, len(live))
+	chunkOf := make([]int, len(live))
+	chunks := make([]mergeChunk, 0)
+	hints := make(map[uint32][]*format.Hint)
+	outputSize := make(map[uint32]int64)
+	chunk := make([]byte, 0)
+	var chunkRecords []int
+	var chunkHints []*format.Hint
+	flush := func() error {
+		if len(chunkRecords) == 0 {
+			return nil
+		}
+		commitData, err := e.encodeCommit(len(chunkRecords), chunk, floor)
+		if err != nil {
+			return err
+		}
+		fileId, offset, err := writer.Append(append(chunk, commitData...))
+		if err != nil {
+			return fmt.Errorf("failed to write merged chunk of %d records: %w", len(chunkRecords), err)
+		}
+		for _, i := range chunkRecords {
+			relocated[i].FileId = fileId
+			relocated[i].Offset += offset
+			chunkOf[i] = len(chunks)
+		}
+		for _, hint := range chunkHints {
+			hint.FileId = fileId
+			hint.Offset += offset
+		}
+		hints[fileId] = append(hints[fileId], chunkHints...)
+		outputSize[fileId] = offset + int64(len(chunk)+len(commitData))
+		chunks = append(chunks, mergeChunk{fileId: fileId, records: len(chunkRecords), marker: int64(len(commitData))})
+
+		chunk = chunk[:0]
+		chunkRecords = chunkRecords[:0]
+		chunkHints = nil
+		return nil
+	}
+
+	for i, l := range live {
+		data, err := e.file.ReadAt(l.entry.FileId, l.entry.Offset, l.entry.Size)
+		if err != nil {
+			writer.Abort()
+			return fmt.Errorf("failed to read live record for key %s: %w", l.key, err)
+		}
+		// Never carry a corrupted record into the merged segments
+		record, err := format.Decode(data, e.cfg.HEADER_SIZE)
+		if err != nil {
+			writer.Abort()
+			return fmt.Errorf("failed to verify live record for key %s: %w", l.key, err)
+		}
+
+		// A chunk ends where the current output segment fills up, so
+		// segments still split close to MAX_FILE_SIZE
+		limit := int64(mergeChunkSize)
+		if room := writer.Room(); room >= 0 && room < limit {
+			limit = room
+		}
+		if int64(len(chunk)+len(data)) > limit {
+			if err := flush(); err != nil {
+				writer.Abort()
+				return err
+			}
+		}
+
+		// Offsets are relative to the chunk until it is appended
+		offset := int64(len(chunk))
+		chunk = append(chunk, data...)
+		chunkRecords = append(chunkRecords, i)
+		relocated[i] = Key{
+			Size:   l.entry.Size,
+			Offset: offset,
+			Expiry: l.entry.Expiry,
+			Seq:    l.entry.Seq,
+		}
+		chunkHints = append(chunkHints, &format.Hint{
+			Timestamp: record.Timestamp,
+			Keysize:   record.Keysize,
+			Size:      l.entry.Size,
+			Offset:    offset,
+			Expiry:    record.Expiry,
+			Seq:       record.Seq,
+			Flag:      record.Flag,
+			Key:       record.Key,
+		})
+	}
+	if err := flush(); err != nil {
+		writer.Abort()
+		return err
+	}
+
+	for _, fileId := range writer.Outputs() {
+		data := format.EncodeHints(hints[fileId], format.SegmentInfo{Size: outputSize[fileId], MaxSeq: floor})
+		if err := writer.WriteHint(fileId, data); err != nil {
+			writer.Abort()
+			return fmt.Errorf("failed to write hint for merged segment %d: %w", fileId, err)
+		}
+	}
+
+	e.swapMu.Lock()
+	defer e.swapMu.Unlock()
+
+	// A snapshot opened while live records were copied may have retained
+	// versions held by the inputs
+	if e.anyPinned(inputs) {
+		writer.Abort()
+		return ErrSegmentsPinned
+	}
+
+	if err := e.file.CommitMerge(writer); err != nil {
+		return fmt.Errorf("failed to commit merge: %w", err)
+	}
+	// The merged segments reuse the input file ids, so values cached at
+	// input offsets must go before readers resume
+	if e.cache != nil {
+		e.cache.RemoveFiles(inputs)
+	}
+
+	// Keys overwritten or deleted during the merge keep their newer state;
+	// their merged copy is dead on arrival, and so is the commit marker of a
+	// chunk whose records all are.
+	outputDead := make(map[uint32]int64)
+	staleInChunk := make([]int, len(chunks))
+	stale := 0
+	for i, l := range live {
+		if !e.keyDir.CompareAndSwap(l.key, l.entry, relocated[i]) {
+			outputDead[relocated[i].FileId] += int64(relocated[i].Size)
+			stale++
+			c := chunkOf[i]
+			staleInChunk[c]++
+			if staleInChunk[c] == chunks[c].records {
+				outputDead[chunks[c].fileId] += chunks[c].marker
+			}
+		}
+	}
+
+	e.statsMu.Lock()
+	for _, id := range inputs {
+		delete(e.deadBytes, id)
+	}
+	for id, n := range outputDead {
+		e.deadBytes[id] = n
+	}
+	e.statsMu.Unlock()
+
+	slog.Info("merge: completed",
+		"inputs", len(inputs),
+		"outputs", len(writer.Outputs()),
+		"live_records", len(live)-stale,
+		"stale_records", stale,
+		"chunks", len(chunks),
+		"duration", time.Since(start))
+	return nil
+}
+
+// maybeMerge starts a background merge once the dead bytes held by sealed
+// segments reach MERGE_THRESHOLD.
+func (e *KVEngine) maybeMerge() {
+	if e.cfg.MERGE_THRESHOLD == 0 || e.merging.Load() {
+		return
+	}
+	if e.sealedDeadBytes() < int64(e.cfg.MERGE_THRESHOLD) {
+		return
+	}
+
+	e.bgWg.Add(1)
+	go func() {
+		defer e.bgWg.Done()
+		err := e.Merge()
+		if errors.Is(err, ErrSegmentsPinned) {
+			slog.Debug("merge: background merge deferred until snapshots are released")
+		} else if err != nil && !errors.Is(err, ErrMergeInProgress) {
+			slog.Error("merge: background merge failed",
+				"error", err)
+		}
+	}()
+}
+
+// markDead records that size bytes in the given file are no longer
+// referenced by the keyDir and can be reclaimed by a merge.
+func (e *KVEngine) markDead(fileId uint32, size uint32) {
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	e.deadBytes[fileId] += int64(size)
+}
+
+// sealedDeadBytes returns the reclaimable bytes held by sealed segments.
+// Dead bytes in the active file only become reclaimable once it is rotated.
+func (e *KVEngine) sealedDeadBytes() int64 {
+	activeId := e.file.ActiveFileId()
+
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+
+	total := int64(0)
+	for id, n := range e.deadBytes {
+		if id != activeId {
+			total += n
+		}
+	}
+	return total
+}
@@ -0,0 +1,231 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jassi-singh/aether-kv/internal/format"
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+// segmentBytes returns the total size of all log files in the data directory.
+func segmentBytes(t *testing.T, dir string) int64 {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+storage.SegmentExt))
+	if err != nil {
+		t.Fatalf("Failed to list segments: %v", err)
+	}
+	total := int64(0)
+	for _, path := range matches {
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		total += stat.Size()
+	}
+	return total
+}
+
+func TestKVEngine_Merge(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	// Overwrite every key several times and delete a few
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if err := engine.Put(key, fmt.Sprintf("value%d-%d", i, round)); err != nil {
+				t.Fatalf("Failed to put key: %v", err)
+			}
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := engine.Delete(fmt.Sprintf("key%d", i)); err != nil {
+			t.Fatalf("Failed to delete key: %v", err)
+		}
+	}
+	if err := engine.file.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	before := segmentBytes(t, cfg.DATA_DIR)
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("KVEngine.Merge() error = %v", err)
+	}
+	after := segmentBytes(t, cfg.DATA_DIR)
+	if after >= before {
+		t.Errorf("Merge() did not reclaim space: %d bytes before, %d after", before, after)
+	}
+	if dead := engine.sealedDeadBytes(); dead != 0 {
+		t.Errorf("sealedDeadBytes() = %d after merge, want 0", dead)
+	}
+
+	check := func(e *KVEngine) {
+		t.Helper()
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key%d", i)
+			got, err := e.Get(key)
+			if i < 3 {
+				if err == nil {
+					t.Errorf("Get(%s) succeeded for a deleted key", key)
+				}
+				continue
+			}
+			want := fmt.Sprintf("value%d-4", i)
+			if err != nil || got != want {
+				t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, want)
+			}
+		}
+	}
+	check(engine)
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+
+	reopened, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer reopened.Close()
+
+	if size := reopened.GetKeyDirSize(); size != 7 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 7)
+	}
+	check(reopened)
+}
+
+func TestKVEngine_MergeConcurrentWrites(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := engine.Put(fmt.Sprintf("key%d", i), "old"); err != nil {
+			t.Fatalf("Failed to put key: %v", err)
+		}
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- engine.Merge()
+	}()
+	for i := 0; i < 50; i++ {
+		if err := engine.Put(fmt.Sprintf("key%d", i), "new"); err != nil {
+			t.Fatalf("Failed to put key: %v", err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("KVEngine.Merge() error = %v", err)
+	}
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if got, err := engine.Get(key); err != nil || got != "new" {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, got, err, "new")
+		}
+	}
+}
+
+func TestKVEngine_MergeThreshold(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 128
+	cfg.MERGE_THRESHOLD = 512
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := engine.Put("key", fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatalf("Failed to put key: %v", err)
+		}
+	}
+	engine.bgWg.Wait()
+	if err := engine.file.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	// Each put writes a record and a commit marker
+	written := int64(0)
+	for i := 0; i < 100; i++ {
+		written += 2*int64(cfg.HEADER_SIZE) + int64(len("key")+len(fmt.Sprintf("value%d", i)))
+	}
+	if size := segmentBytes(t, cfg.DATA_DIR); size >= written/2 {
+		t.Errorf("background merge did not reclaim space: %d bytes on disk, %d written", size, written)
+	}
+	if got, err := engine.Get("key"); err != nil || got != "value99" {
+		t.Errorf("Get(key) = %q, %v, want %q", got, err, "value99")
+	}
+
+	// A merge must not be started while another is running
+	engine.merging.Store(true)
+	if err := engine.Merge(); err != ErrMergeInProgress {
+		t.Errorf("Merge() error = %v, want %v", err, ErrMergeInProgress)
+	}
+	engine.merging.Store(false)
+}
+
+func TestKVEngine_MergeChunks(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 1024
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	const keys = 100
+	for i := 0; i < keys; i++ {
+		if err := engine.Put(fmt.Sprintf("key%02d", i), "value"); err != nil {
+			t.Fatalf("Failed to put key: %v", err)
+		}
+	}
+	inputs := engine.file.Segments()
+	inputs = inputs[:len(inputs)-1]
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("KVEngine.Merge() error = %v", err)
+	}
+
+	// Records share a commit marker per chunk instead of having one each
+	records, commits := 0, 0
+	for _, id := range inputs {
+		file, err := os.Open(filepath.Join(cfg.DATA_DIR, storage.SegmentFileName(id)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Failed to open merged segment %d: %v", id, err)
+		}
+		_, err = readBatches(bufio.NewReader(file), cfg.HEADER_SIZE, id, func(batch []committedRecord, _ *format.Record) {
+			records += len(batch)
+			commits++
+		})
+		file.Close()
+		if err != nil {
+			t.Fatalf("readBatches() of merged segment %d error = %v", id, err)
+		}
+	}
+	if records == 0 || commits > records/10 {
+		t.Errorf("merged segments hold %d records under %d commit markers, want a marker per chunk", records, commits)
+	}
+	if got, err := engine.Get("key42"); err != nil || got != "value" {
+		t.Errorf("Get(key42) after merge = %q, %v, want value", got, err)
+	}
+}
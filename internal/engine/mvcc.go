@@ -0,0 +1,257 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jassi-singh/aether-kv/internal/index"
+)
+
+// ErrSequenceUnavailable is returned when reading at a sequence number that
+// has not been written yet, or whose overwritten versions were no longer
+// retained because no snapshot needed them.
+var ErrSequenceUnavailable = errors.New("sequence number not available")
+
+// version is a location a key held before a later write replaced or
+// deleted it.
+type version struct {
+	entry Key    // Location of the superseded record
+	end   uint64 // Sequence number of the write that superseded it
+}
+
+// versionChain holds the superseded versions of a key that an open
+// snapshot may still read, newest first.
+type versionChain struct {
+	versions []version
+}
+
+// newHistory creates the index of superseded versions kept for snapshots.
+func newHistory() index.Index[*versionChain] {
+	return index.NewBTree[*versionChain](index.DefaultDegree)
+}
+
+// GetAt retrieves the value key had once every write up to sequence number
+// seq was applied. Overwritten versions are only retained while a snapshot
+// older than their replacement is open, so without one only sequence
+// numbers since the last overwrite or delete are readable. Returns
+// ErrSequenceUnavailable if seq is ahead of the last write or older than
+// the oldest retained version.
+func (e *KVEngine) GetAt(key string, seq uint64) (string, error) {
+	// A merge must not swap segments between the lookup and the read
+	e.swapMu.RLock()
+	defer e.swapMu.RUnlock()
+
+	if err := e.readable(seq); err != nil {
+		return "", err
+	}
+	return e.readAt(key, seq, nowMillis())
+}
+
+// readable returns ErrSequenceUnavailable unless the keyDir and the history
+// together still describe the database exactly as of seq.
+// Caller must hold e.swapMu.
+func (e *KVEngine) readable(seq uint64) error {
+	if last := e.visibleSeq.Load(); seq > last {
+		return fmt.Errorf("sequence %d is ahead of the last write %d: %w", seq, last, ErrSequenceUnavailable)
+	}
+	if seq < e.horizon {
+		return fmt.Errorf("sequence %d is older than the oldest retained %d: %w", seq, e.horizon, ErrSequenceUnavailable)
+	}
+	return nil
+}
+
+// readAt returns the value key had at seq, judging expiry at now.
+// Caller must hold e.swapMu for reading.
+func (e *KVEngine) readAt(key string, seq, now uint64) (string, error) {
+	entry, ok := e.lookupAt(key, seq)
+	if !ok || entry.Expired(now) {
+		slog.Debug("get: key not found at sequence",
+			"key", key,
+			"seq", seq)
+		return "", ErrKeyNotFound
+	}
+	return readValue[string](e, key, entry)
+}
+
+// lookupAt returns the location key had at seq: its current entry if it was
+// written by then, or else the retained version that was current at seq.
+// Caller must hold e.swapMu.
+func (e *KVEngine) lookupAt(key string, seq uint64) (Key, bool) {
+	if entry, ok := e.keyDir.Load(key); ok && entry.Seq <= seq {
+		return entry, true
+	}
+	chain, ok := e.history.Load(key)
+	if !ok {
+		return Key{}, false
+	}
+	for _, v := range chain.versions {
+		if v.entry.Seq <= seq {
+			return v.entry, seq < v.end
+		}
+	}
+	return Key{}, false
+}
+
+// ascendAt calls fn for every key in [start, end) that existed at seq, in
+// ascending order and with the location it had then, until fn returns
+// false. An empty end means no upper bound. Keys are gathered from the
+// keyDir and the history in batches of scanBatchSize, so writers wait for
+// at most one batch at a time. Caller must not hold e.swapMu.
+func (e *KVEngine) ascendAt(start, end string, seq uint64, fn func(key string, entry Key) bool) {
+	for {
+		e.swapMu.RLock()
+		current := ascendKeys(e.keyDir, start, end)
+		retained := ascendKeys(e.history, start, end)
+
+		// Only keys up to the lower bound of a full batch are known to be
+		// complete from both indexes
+		bound, more := "", false
+		if len(current) == scanBatchSize {
+			bound, more = current[len(current)-1], true
+		}
+		if len(retained) == scanBatchSize && (!more || retained[len(retained)-1] < bound) {
+			bound, more = retained[len(retained)-1], true
+		}
+
+		type found struct {
+			key   string
+			entry Key
+		}
+		batch := make([]found, 0, len(current)+len(retained))
+		i, j := 0, 0
+		for i < len(current) || j < len(retained) {
+			var key string
+			switch {
+			case j == len(retained) || (i < len(current) && current[i] < retained[j]):
+				key = current[i]
+				i++
+			case i == len(current) || retained[j] < current[i]:
+				key = retained[j]
+				j++
+			default:
+				key = current[i]
+				i++
+				j++
+			}
+			if more && key > bound {
+				break
+			}
+			if entry, ok := e.lookupAt(key, seq); ok {
+				batch = append(batch, found{key: key, entry: entry})
+			}
+		}
+		e.swapMu.RUnlock()
+
+		for _, f := range batch {
+			if !fn(f.key, f.entry) {
+				return
+			}
+		}
+		if !more {
+			return
+		}
+		start = bound + "\x00"
+	}
+}
+
+// ascendKeys returns up to scanBatchSize keys of idx in [start, end).
+func ascendKeys[V comparable](idx index.Index[V], start, end string) []string {
+	keys := make([]string, 0, scanBatchSize)
+	idx.Ascend(start, end, func(key string, _ V) bool {
+		keys = append(keys, key)
+		return len(keys) < scanBatchSize
+	})
+	return keys
+}
+
+// retain keeps prev, the location key held until the write numbered seq
+// replaced or deleted it, while a snapshot is open, pinning its segment so
+// Merge cannot drop it. Every version superseded after the oldest snapshot
+// is kept, so every sequence number from there on stays readable.
+// Otherwise no reader can ask for prev any more, and the oldest readable
+// sequence moves past it.
+// Caller must hold e.swapMu.
+func (e *KVEngine) retain(key string, prev Key, seq uint64) {
+	if oldest, ok := e.oldestReader(); !ok || seq <= oldest {
+		e.horizon = max(e.horizon, seq)
+		return
+	}
+
+	chain, ok := e.history.Load(key)
+	if !ok {
+		chain = &versionChain{}
+		e.history.Swap(key, chain)
+	}
+	chain.versions = append([]version{{entry: prev, end: seq}}, chain.versions...)
+	e.retained++
+	e.pinSegments([]uint32{prev.FileId})
+}
+
+// pruneHistory drops every retained version superseded at or before the
+// oldest open snapshot, which no snapshot can read any more, and unpins its
+// segment.
+// Caller must hold e.swapMu.
+func (e *KVEngine) pruneHistory() {
+	oldest, open := e.oldestReader()
+	empty := make([]string, 0)
+	dropped := 0
+	e.history.Ascend("", "", func(key string, chain *versionChain) bool {
+		kept := chain.versions[:0]
+		for _, v := range chain.versions {
+			if open && v.end > oldest {
+				kept = append(kept, v)
+				continue
+			}
+			e.horizon = max(e.horizon, v.end)
+			e.unpinSegments([]uint32{v.entry.FileId})
+			dropped++
+		}
+		chain.versions = kept
+		if len(kept) == 0 {
+			empty = append(empty, key)
+		}
+		return true
+	})
+	for _, key := range empty {
+		e.history.LoadAndDelete(key)
+	}
+	e.retained -= dropped
+
+	if dropped > 0 {
+		slog.Debug("snapshot: pruned retained versions",
+			"dropped", dropped,
+			"retained", e.retained)
+	}
+}
+
+// addReader registers a snapshot reading at seq.
+func (e *KVEngine) addReader(seq uint64) {
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+	e.readers[seq]++
+}
+
+// removeReader unregisters a snapshot reading at seq.
+func (e *KVEngine) removeReader(seq uint64) {
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+	e.readers[seq]--
+	if e.readers[seq] <= 0 {
+		delete(e.readers, seq)
+	}
+}
+
+// oldestReader returns the lowest sequence number an open snapshot reads
+// at, and false if no snapshot is open.
+func (e *KVEngine) oldestReader() (uint64, bool) {
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+	oldest, ok := uint64(0), false
+	for seq := range e.readers {
+		if !ok || seq < oldest {
+			oldest, ok = seq, true
+		}
+	}
+	return oldest, ok
+}
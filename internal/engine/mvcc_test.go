@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKVEngine_GetAt(t *testing.T) {
+	cfg := setupTestConfig(t)
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if err := engine.Put("a", "1"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	first := engine.LastSeq()
+	if err := engine.Put("b", "1"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := engine.Put("a", "2"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	last := engine.LastSeq()
+
+	if got, err := engine.GetAt("a", last); err != nil || got != "2" {
+		t.Errorf("GetAt(a, %d) = %q, %v, want 2", last, got, err)
+	}
+	if got, err := engine.GetAt("b", last); err != nil || got != "1" {
+		t.Errorf("GetAt(b, %d) = %q, %v, want 1", last, got, err)
+	}
+	// Without a snapshot the overwritten version was not kept
+	if _, err := engine.GetAt("a", first); !errors.Is(err, ErrSequenceUnavailable) {
+		t.Errorf("GetAt(a, %d) error = %v, want %v", first, err, ErrSequenceUnavailable)
+	}
+	if _, err := engine.GetAt("a", last+1); !errors.Is(err, ErrSequenceUnavailable) {
+		t.Errorf("GetAt(a, %d) error = %v, want %v", last+1, err, ErrSequenceUnavailable)
+	}
+	if _, err := engine.NewSnapshotAt(first); !errors.Is(err, ErrSequenceUnavailable) {
+		t.Errorf("NewSnapshotAt(%d) error = %v, want %v", first, err, ErrSequenceUnavailable)
+	}
+	if _, err := engine.GetAt("missing", last); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("GetAt(missing) error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestKVEngine_SnapshotRetainsVersions(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 1024
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	// More keys than one scan batch, so snapshot scans merge the history
+	// into the keyDir across batch boundaries
+	const n = 3 * scanBatchSize
+	for i := 0; i < n; i++ {
+		if err := engine.Put(fmt.Sprintf("key%03d", i), "v0"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	snapshot := engine.NewSnapshot()
+	defer snapshot.Release()
+
+	// Record the value of key000 after each of its writes
+	values := map[uint64]string{snapshot.Seq(): "v0"}
+	for round := 1; round <= 3; round++ {
+		value := fmt.Sprintf("v%d", round)
+		if err := engine.Put("key000", value); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+		values[engine.LastSeq()] = value
+	}
+	for i := 1; i < n; i += 2 {
+		if err := engine.Delete(fmt.Sprintf("key%03d", i)); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	if err := engine.Put("new", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	for seq, want := range values {
+		if got, err := engine.GetAt("key000", seq); err != nil || got != want {
+			t.Errorf("GetAt(key000, %d) = %q, %v, want %q", seq, got, err, want)
+		}
+	}
+	if _, err := engine.GetAt("new", snapshot.Seq()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("GetAt(new) before it was written error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if got, err := snapshot.Get("key001"); err != nil || got != "v0" {
+		t.Errorf("Snapshot.Get(key001) = %q, %v, want v0", got, err)
+	}
+	if got := snapshot.Len(); got != n {
+		t.Errorf("Snapshot.Len() = %d, want %d", got, n)
+	}
+	keys, _ := scanAll(t, snapshot.Iterator())
+	if len(keys) != n || keys[0] != "key000" || keys[n-1] != fmt.Sprintf("key%03d", n-1) {
+		t.Errorf("Snapshot.Iterator() returned %d keys from %v, want %d", len(keys), keys[:1], n)
+	}
+	if keys, _ := scanAll(t, engine.Scan("", "")); len(keys) != n/2+1 {
+		t.Errorf("Scan() returned %d keys, want %d", len(keys), n/2+1)
+	}
+
+	// A second snapshot can start anywhere the first keeps readable
+	later, err := engine.NewSnapshotAt(snapshot.Seq() + 1)
+	if err != nil {
+		t.Fatalf("NewSnapshotAt() error = %v", err)
+	}
+	if got, err := later.Get("key000"); err != nil || got != "v1" {
+		t.Errorf("later Snapshot.Get(key000) = %q, %v, want v1", got, err)
+	}
+
+	if err := engine.Merge(); !errors.Is(err, ErrSegmentsPinned) {
+		t.Errorf("Merge() with retained versions error = %v, want %v", err, ErrSegmentsPinned)
+	}
+	stats, err := engine.Stats()
+	if err != nil || stats.Snapshots != 2 || stats.Retained != n/2+3 {
+		t.Errorf("Stats() = %+v, %v, want 2 snapshots retaining %d versions", stats, err, n/2+3)
+	}
+
+	// The first snapshot alone needed the version it was taken at
+	snapshot.Release()
+	if _, err := engine.GetAt("key000", snapshot.Seq()); !errors.Is(err, ErrSequenceUnavailable) {
+		t.Errorf("GetAt() at a released snapshot error = %v, want %v", err, ErrSequenceUnavailable)
+	}
+	if got, err := later.Get("key001"); err != nil || got != "v0" {
+		t.Errorf("later Snapshot.Get(key001) after first release = %q, %v, want v0", got, err)
+	}
+
+	later.Release()
+	if stats, err := engine.Stats(); err != nil || stats.Snapshots != 0 || stats.Retained != 0 {
+		t.Errorf("Stats() after Release() = %+v, %v, want nothing retained", stats, err)
+	}
+	if err := engine.Merge(); err != nil {
+		t.Errorf("Merge() after Release() error = %v", err)
+	}
+}
+
+func TestKVEngine_SnapshotSeesExpiredKeysAfterMerge(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 128
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if err := engine.PutWithTTL("session", "token", 50*time.Millisecond); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := engine.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	snapshot := engine.NewSnapshot()
+	defer snapshot.Release()
+
+	time.Sleep(60 * time.Millisecond)
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if got, err := snapshot.Get("session"); err != nil || got != "token" {
+		t.Errorf("Snapshot.Get(session) after merge = %q, %v, want token", got, err)
+	}
+}
@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/config"
+	"github.com/jassi-singh/aether-kv/internal/format"
+	"github.com/jassi-singh/aether-kv/internal/storage"
+)
+
+// ErrRestoreCompacted is returned by Restore when a merge discarded records
+// that were live at the requested point, so the log no longer holds the
+// state of the store at that point.
+var ErrRestoreCompacted = errors.New("log compacted past restore point")
+
+// RestorePoint is the moment Restore rebuilds the store at. Exactly one of
+// its fields is set.
+type RestorePoint struct {
+	Seq  uint64    // Keep every write up to and including this sequence number
+	Time time.Time // Keep every write up to the first one made after this time
+}
+
+// String describes the restore point.
+func (p RestorePoint) String() string {
+	if p.Seq != 0 {
+		return fmt.Sprintf("sequence %d", p.Seq)
+	}
+	return p.Time.Format(time.RFC3339Nano)
+}
+
+// RestoreResult summarizes a completed restore.
+type RestoreResult struct {
+	LastSeq  uint64    // Sequence number of the last write restored
+	LastTime time.Time // Time of the last write restored
+	Batches  int       // Committed batches restored
+	Records  int       // Records restored
+}
+
+// Restore rebuilds the store as it was at until from the log files in
+// srcDir, a backup or the data directory of a store that is not running,
+// into cfg.DATA_DIR, which must not exist or be empty. Committed batches
+// are copied in log order until the first one holding a write past until,
+// so a batch is restored whole or not at all and batches torn by a crash
+// are left out. A backup is verified against its manifest first. Records
+// keep their sequence numbers, timestamps and expiry times, so keys that
+// have expired since read as missing. Returns an error wrapping
+// ErrRestoreCompacted if a merge dropped records that until needs, with the
+// earliest sequence number that can still be restored.
+func Restore(cfg *config.Config, srcDir string, until RestorePoint) (*RestoreResult, error) {
+	if (until.Seq == 0) == until.Time.IsZero() {
+		return nil, fmt.Errorf("invalid restore point: exactly one of sequence number and time must be set")
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, BackupManifestName)); err == nil {
+		if _, err := VerifyBackup(srcDir); err != nil {
+			return nil, err
+		}
+	}
+	names, err := storage.LogFiles(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := createEmptyDir(cfg.DATA_DIR); err != nil {
+		return nil, err
+	}
+
+	dst, err := storage.NewFile(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restored log: %w", err)
+	}
+
+	start := time.Now()
+	r := &restorer{cfg: cfg, dst: dst, until: until, next: 1}
+	for _, name := range names {
+		if err = r.copySegment(filepath.Join(srcDir, name)); err != nil || r.done {
+			break
+		}
+	}
+	if closeErr := dst.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close restored log: %w", closeErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r.result.LastSeq < r.exactFrom {
+		return nil, fmt.Errorf("records live at %s were dropped by a merge, restore to sequence %d or later: %w",
+			until, r.exactFrom, ErrRestoreCompacted)
+	}
+
+	slog.Info("restore: completed",
+		"src", srcDir,
+		"dir", cfg.DATA_DIR,
+		"last_seq", r.result.LastSeq,
+		"batches", r.result.Batches,
+		"records", r.result.Records,
+		"duration", time.Since(start))
+	return &r.result, nil
+}
+
+// restorer copies committed batches of a log into a new one until the
+// restore point.
+type restorer struct {
+	cfg       *config.Config
+	dst       *storage.File
+	until     RestorePoint
+	next      uint64 // Sequence number the next sequenced record should carry
+	exactFrom uint64 // Lowest last sequence number the restore is exact at
+	gap       bool   // Set once a sequence number is found missing from the current segment
+	done      bool   // Set once a batch past the restore point was read
+	err       error
+	result    RestoreResult
+}
+
+// copySegment copies the committed batches of the log file at path up to
+// the restore point.
+func (r *restorer) copySegment(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	r.gap = false
+	info, err := readBatches(bufio.NewReader(f), r.cfg.HEADER_SIZE, 0, r.copyBatch)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// A merge drops a record only once a write up to its floor supersedes
+	// it. The floor is carried by the merged segment, or, if a merge kept
+	// nothing of its inputs, reached by the segment that was active then,
+	// which is where the gap ends. The restored state is only exact once
+	// every write up to it is restored.
+	if r.gap {
+		r.exactFrom = max(r.exactFrom, info.MaxSeq)
+	}
+	return r.err
+}
+
+// copyBatch appends a committed batch to the restored log unless the
+// restore point has been reached. A batch holding a write past the restore
+// point is left out whole, except for a chunk written by a merge, whose
+// records before the restore point are still copied.
+func (r *restorer) copyBatch(batch []committedRecord, commit *format.Record) {
+	if r.done || r.err != nil {
+		return
+	}
+
+	for i, c := range batch {
+		if !r.past(c.record) {
+			continue
+		}
+		kept := 0
+		if i > 0 && mergedChunk(batch, commit) {
+			kept = i
+			r.copyRecords(batch[:kept], commit)
+		}
+		// Records dropped just before the restore point leave a gap too
+		if first := batch[kept].record.Seq; first > r.next && (r.until.Seq == 0 || r.next <= r.until.Seq) {
+			r.gap = true
+		}
+		r.done = true
+		return
+	}
+	r.copyRecords(batch, commit)
+}
+
+// copyRecords appends records to the restored log under a commit marker of
+// their own.
+func (r *restorer) copyRecords(batch []committedRecord, commit *format.Record) {
+	if r.err != nil {
+		return
+	}
+
+	// Sequence numbers of committed records have no gaps, so a missing one
+	// was dropped by a merge
+	batchSeq := uint64(0)
+	for _, c := range batch {
+		if c.record.Seq == 0 {
+			continue
+		}
+		if c.record.Seq != r.next {
+			r.gap = true
+		}
+		r.next = c.record.Seq + 1
+		batchSeq = max(batchSeq, c.record.Seq)
+	}
+
+	// The marker is rewritten so a merge floor past the restore point is
+	// not carried over
+	data := make([]byte, 0)
+	for _, c := range batch {
+		data = append(data, c.raw...)
+	}
+	marker, err := format.NewCommitRecord(commit.Timestamp, uint32(len(batch)), crc32.ChecksumIEEE(data), batchSeq).Encode(r.cfg.HEADER_SIZE)
+	if err != nil {
+		r.err = fmt.Errorf("failed to encode commit record: %w", err)
+		return
+	}
+	if _, _, err := r.dst.Append(append(data, marker...)); err != nil {
+		r.err = fmt.Errorf("failed to append restored batch: %w", err)
+		return
+	}
+
+	r.result.Batches++
+	r.result.Records += len(batch)
+	if len(batch) > 0 {
+		r.result.LastSeq = max(r.result.LastSeq, batchSeq)
+		r.result.LastTime = batch[len(batch)-1].record.Time()
+	}
+}
+
+// mergedChunk reports whether batch is a chunk of records a merge copied
+// under one commit marker rather than a single atomic write. The records of
+// a write have consecutive sequence numbers, the last of which its marker
+// carries; a merge marker carries the merge floor instead.
+func mergedChunk(batch []committedRecord, commit *format.Record) bool {
+	for i := 1; i < len(batch); i++ {
+		if batch[i].record.Seq != batch[i-1].record.Seq+1 {
+			return true
+		}
+	}
+	return commit.CommitSeq() != batch[len(batch)-1].record.Seq
+}
+
+// past reports whether record was written after the restore point.
+func (r *restorer) past(record *format.Record) bool {
+	if r.until.Seq != 0 {
+		return record.Seq > r.until.Seq
+	}
+	return record.Time().After(r.until.Time)
+}
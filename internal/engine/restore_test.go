@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/config"
+)
+
+// openRestored opens the data directory written by a restore and closes it
+// when the test ends.
+func openRestored(t *testing.T, cfg *config.Config) *KVEngine {
+	t.Helper()
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open restored directory: %v", err)
+	}
+	t.Cleanup(func() { engine.Close() })
+	return engine
+}
+
+func TestRestore(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := engine.Put(fmt.Sprintf("key%d", i), "v1"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	good := engine.LastSeq()
+	goodTime := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	// A bad deploy overwrites and deletes keys in one batch
+	batch := NewWriteBatch()
+	batch.Put("key0", "bad")
+	batch.Delete("key1")
+	batch.Put("key2", "bad")
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	src := filepath.Join(t.TempDir(), "backup")
+	if _, err := engine.Backup(src); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		until RestorePoint
+	}{
+		{"sequence", RestorePoint{Seq: good}},
+		{"mid-batch sequence", RestorePoint{Seq: good + 2}},
+		{"time", RestorePoint{Time: goodTime}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dstCfg := *cfg
+			dstCfg.DATA_DIR = filepath.Join(t.TempDir(), "restored")
+			result, err := Restore(&dstCfg, src, tt.until)
+			if err != nil {
+				t.Fatalf("Restore() error = %v", err)
+			}
+			if result.LastSeq != good || result.Records != 10 {
+				t.Errorf("Restore() = %+v, want 10 records up to sequence %d", result, good)
+			}
+
+			restored := openRestored(t, &dstCfg)
+			if got := restored.LastSeq(); got != good {
+				t.Errorf("LastSeq() of restored store = %d, want %d", got, good)
+			}
+			for _, key := range []string{"key0", "key1", "key2"} {
+				if got, err := restored.Get(key); err != nil || got != "v1" {
+					t.Errorf("Get(%s) from restored store = %q, %v, want v1", key, got, err)
+				}
+			}
+		})
+	}
+
+	dstCfg := *cfg
+	dstCfg.DATA_DIR = t.TempDir()
+	if _, err := Restore(&dstCfg, src, RestorePoint{}); err == nil {
+		t.Errorf("Restore() without a restore point error = nil, want an error")
+	}
+}
+
+func TestRestore_Compacted(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	for round := 1; round <= 3; round++ {
+		for i := 0; i < 5; i++ {
+			if err := engine.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("v%d", round)); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+		}
+	}
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if err := engine.Put("after", "merge"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	last := engine.LastSeq()
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// The first rounds were merged away, so they cannot be restored
+	dstCfg := *cfg
+	dstCfg.DATA_DIR = filepath.Join(t.TempDir(), "early")
+	_, err = Restore(&dstCfg, cfg.DATA_DIR, RestorePoint{Seq: 5})
+	if !errors.Is(err, ErrRestoreCompacted) {
+		t.Fatalf("Restore() before the merge error = %v, want %v", err, ErrRestoreCompacted)
+	}
+	// Once every round was rewritten the merged segments are exact
+	dstCfg.DATA_DIR = filepath.Join(t.TempDir(), "merged")
+	if _, err := Restore(&dstCfg, cfg.DATA_DIR, RestorePoint{Seq: 15}); err != nil {
+		t.Errorf("Restore() at the merge floor error = %v", err)
+	}
+
+	// Restoring from a stopped store's directory up to the end keeps it all
+	dstCfg.DATA_DIR = filepath.Join(t.TempDir(), "latest")
+	result, err := Restore(&dstCfg, cfg.DATA_DIR, RestorePoint{Seq: last})
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if result.LastSeq != last {
+		t.Errorf("Restore() last sequence = %d, want %d", result.LastSeq, last)
+	}
+	restored := openRestored(t, &dstCfg)
+	if got, err := restored.Get("key4"); err != nil || got != "v3" {
+		t.Errorf("Get(key4) from restored store = %q, %v, want v3", got, err)
+	}
+	if got, err := restored.Get("after"); err != nil || got != "merge" {
+		t.Errorf("Get(after) from restored store = %q, %v, want merge", got, err)
+	}
+}
+
+func TestRestore_MergedChunk(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := engine.Put(fmt.Sprintf("key%d", i), "v1"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	// Every key is live, so the merge copies every sealed record
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// A restore point inside a merged chunk keeps the records before it
+	for seq := uint64(1); seq <= 10; seq++ {
+		dstCfg := *cfg
+		dstCfg.DATA_DIR = filepath.Join(t.TempDir(), "restored")
+		result, err := Restore(&dstCfg, cfg.DATA_DIR, RestorePoint{Seq: seq})
+		if err != nil {
+			t.Fatalf("Restore() to sequence %d error = %v", seq, err)
+		}
+		if result.LastSeq != seq || result.Records != int(seq) {
+			t.Errorf("Restore() to sequence %d = %+v, want %d records", seq, result, seq)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"log/slog"
+
+	"github.com/jassi-singh/aether-kv/internal/index"
+)
+
+// scanBatchSize is the number of keys an Iterator copies out of the keyDir
+// at a time. Copying in batches keeps writers from waiting on a long scan.
+const scanBatchSize = 128
+
+// keySource is an ordered set of key locations an Iterator can walk.
+type keySource interface {
+	Ascend(start, end string, fn func(key string, entry Key) bool)
+}
+
+// valueReader loads the value of a key returned by an Iterator.
+type valueReader interface {
+	Get(key string) (string, error)
+}
+
+// Iterator walks the keys of a range in ascending order. Keys are read from
+// their source in small batches as the iterator advances, and values are
+// only read from disk when Value is called. When iterating the engine
+// itself, a key written or deleted after the scan started may or may not be
+// seen; a Snapshot iterator always sees the snapshot's contents. An
+// Iterator is not safe for concurrent use.
+type Iterator struct {
+	source    keySource   // Key locations being iterated
+	reader    valueReader // Loads values for Value
+	asOf      uint64      // Unix milliseconds expiry is judged at, 0 for the current time
+	start     string      // First key of the next batch
+	end       string      // Exclusive upper bound, empty for none
+	keys      []string    // Current batch of keys
+	pos       int         // Index of the current key in keys
+	exhausted bool        // Set once the source holds no more keys in range
+}
+
+// Scan returns an iterator over the keys in [start, end) in ascending order.
+// An empty end means no upper bound. Expired keys are skipped.
+func (e *KVEngine) Scan(start, end string) *Iterator {
+	slog.Debug("scan: starting scan",
+		"start", start,
+		"end", end)
+
+	return newIterator(e.keyDir, e, 0, start, end)
+}
+
+// ScanPrefix returns an iterator over every key starting with prefix in
+// ascending order. Expired keys are skipped.
+func (e *KVEngine) ScanPrefix(prefix string) *Iterator {
+	return e.Scan(prefix, index.PrefixEnd(prefix))
+}
+
+// newIterator returns an iterator over the keys of source in [start, end).
+func newIterator(source keySource, reader valueReader, asOf uint64, start, end string) *Iterator {
+	return &Iterator{
+		source: source,
+		reader: reader,
+		asOf:   asOf,
+		start:  start,
+		end:    end,
+		pos:    -1,
+	}
+}
+
+// Next advances the iterator to the next key. Returns false once the range
+// is exhausted or the iterator is closed.
+func (it *Iterator) Next() bool {
+	it.pos++
+	for it.pos >= len(it.keys) {
+		if it.exhausted {
+			return false
+		}
+		it.fill()
+	}
+	return true
+}
+
+// Key returns the current key. It must only be called after Next returned true.
+func (it *Iterator) Key() string {
+	return it.keys[it.pos]
+}
+
+// Value reads the value of the current key from disk. Returns an error if
+// the key has been deleted or has expired since it was scanned, or if any
+// I/O operation fails.
+func (it *Iterator) Value() (string, error) {
+	return it.reader.Get(it.keys[it.pos])
+}
+
+// Close releases the keys buffered by the iterator. Next returns false
+// afterwards.
+func (it *Iterator) Close() {
+	it.keys = nil
+	it.pos = 0
+	it.exhausted = true
+}
+
+// fill replaces the current batch with the next scanBatchSize keys in range.
+func (it *Iterator) fill() {
+	it.keys = it.keys[:0]
+	it.pos = 0
+
+	now := it.asOf
+	if now == 0 {
+		now = nowMillis()
+	}
+	visited := 0
+	last := ""
+	it.source.Ascend(it.start, it.end, func(key string, entry Key) bool {
+		if !entry.Expired(now) {
+			it.keys = append(it.keys, key)
+		}
+		last = key
+		visited++
+		return visited < scanBatchSize
+	})
+
+	if visited < scanBatchSize {
+		it.exhausted = true
+		return
+	}
+	// The smallest key sorting after last
+	it.start = last + "\x00"
+}
@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// scanAll drains an iterator and returns its keys and values in order.
+func scanAll(t *testing.T, it *Iterator) ([]string, []string) {
+	t.Helper()
+	defer it.Close()
+
+	keys := make([]string, 0)
+	values := make([]string, 0)
+	for it.Next() {
+		value, err := it.Value()
+		if err != nil {
+			t.Fatalf("Value() for key %s error = %v", it.Key(), err)
+		}
+		keys = append(keys, it.Key())
+		values = append(values, value)
+	}
+	return keys, values
+}
+
+func TestKVEngine_Scan(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	for _, key := range []string{"user:42:name", "user:7:name", "user:42:email", "order:1", "user:420:name"} {
+		if err := engine.Put(key, "v-"+key); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+	if err := engine.Delete("order:1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := engine.PutWithTTL("user:42:token", "secret", time.Millisecond); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	keys, values := scanAll(t, engine.ScanPrefix("user:42:"))
+	if want := []string{"user:42:email", "user:42:name"}; fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("ScanPrefix(user:42:) keys = %v, want %v", keys, want)
+	}
+	if want := []string{"v-user:42:email", "v-user:42:name"}; fmt.Sprint(values) != fmt.Sprint(want) {
+		t.Errorf("ScanPrefix(user:42:) values = %v, want %v", values, want)
+	}
+
+	keys, _ = scanAll(t, engine.Scan("order:", "user:5"))
+	if want := []string{"user:420:name", "user:42:email", "user:42:name"}; fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("Scan(order:, user:5) keys = %v, want %v", keys, want)
+	}
+
+	keys, _ = scanAll(t, engine.Scan("", ""))
+	if len(keys) != 4 {
+		t.Errorf("Scan() returned %d keys, want 4", len(keys))
+	}
+}
+
+func TestKVEngine_ScanAcrossBatches(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	total := 3*scanBatchSize + 5
+	for i := total - 1; i >= 0; i-- {
+		if err := engine.Put(fmt.Sprintf("key%05d", i), fmt.Sprintf("value%d", i)); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	keys, values := scanAll(t, engine.ScanPrefix("key"))
+	if len(keys) != total {
+		t.Fatalf("ScanPrefix() returned %d keys, want %d", len(keys), total)
+	}
+	for i := range keys {
+		if want := fmt.Sprintf("key%05d", i); keys[i] != want || values[i] != fmt.Sprintf("value%d", i) {
+			t.Fatalf("ScanPrefix()[%d] = %s=%s, want %s=value%d", i, keys[i], values[i], want, i)
+		}
+	}
+
+	// Closing stops the iteration early
+	it := engine.ScanPrefix("key")
+	if !it.Next() {
+		t.Fatal("Next() = false, want true")
+	}
+	it.Close()
+	if it.Next() {
+		t.Error("Next() after Close() = true, want false")
+	}
+}
@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/format"
+)
+
+// Entry is a value together with the metadata of the write that stored it.
+type Entry struct {
+	Value  string
+	Seq    uint64    // Log sequence number of the write, which is also the key's version
+	Time   time.Time // Wall-clock time of the write
+	Expiry time.Time // Time at which the key expires, zero if it never does
+}
+
+// LastSeq returns the log sequence number of the most recent write visible
+// to reads. Every record gets the next number when it is appended, so
+// sequence numbers order all writes, unlike their wall-clock times. The
+// counter is recovered on startup and never goes back.
+func (e *KVEngine) LastSeq() uint64 {
+	return e.visibleSeq.Load()
+}
+
+// GetEntry is like Get but returns the value together with the sequence
+// number and time of the write that stored it. The record is always read
+// from disk, as the cache holds values only.
+func (e *KVEngine) GetEntry(key string) (Entry, error) {
+	// A merge must not swap segments between the lookup and the read
+	e.swapMu.RLock()
+	defer e.swapMu.RUnlock()
+
+	keyEntry, ok := e.keyDir.Load(key)
+	if !ok || keyEntry.Expired(nowMillis()) {
+		slog.Debug("get: key not found in keyDir",
+			"key", key)
+		return Entry{}, ErrKeyNotFound
+	}
+
+	record, err := e.readRecord(key, keyEntry)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := newEntry(record, keyEntry)
+
+	slog.Info("get: success",
+		"key", key,
+		"seq", entry.Seq,
+		"value_size", len(entry.Value))
+	return entry, nil
+}
+
+// newEntry returns the Entry for record, read from the location keyEntry.
+func newEntry(record *format.Record, keyEntry Key) Entry {
+	entry := Entry{
+		Value: string(record.Value),
+		Seq:   record.Seq,
+		Time:  record.Time(),
+	}
+	if keyEntry.Expiry != 0 {
+		entry.Expiry = time.UnixMilli(int64(keyEntry.Expiry))
+	}
+	return entry
+}
@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKVEngine_SequenceNumbers(t *testing.T) {
+	cfg := setupTestConfig(t)
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	if seq := engine.LastSeq(); seq != 0 {
+		t.Errorf("LastSeq() of empty engine = %d, want 0", seq)
+	}
+
+	// Writes within the same second are still ordered
+	batch := NewWriteBatch()
+	batch.Put("a", "1")
+	batch.Put("b", "2")
+	batch.Delete("c")
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := engine.PutWithTTL("d", "4", time.Hour); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+	if seq := engine.LastSeq(); seq != 4 {
+		t.Errorf("LastSeq() after four operations = %d, want 4", seq)
+	}
+
+	a, err := engine.GetEntry("a")
+	if err != nil || a.Value != "1" || a.Seq != 1 || !a.Expiry.IsZero() {
+		t.Fatalf("GetEntry(a) = %+v, %v, want value 1 at seq 1 without expiry", a, err)
+	}
+	d, err := engine.GetEntry("d")
+	if err != nil || d.Seq != 4 || d.Expiry.IsZero() {
+		t.Fatalf("GetEntry(d) = %+v, %v, want seq 4 with expiry", d, err)
+	}
+	if d.Time.Before(a.Time) || time.Since(a.Time) > time.Minute {
+		t.Errorf("GetEntry() times = %v, %v, want recent times in write order", a.Time, d.Time)
+	}
+	if _, err := engine.GetEntry("c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("GetEntry(c) error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if stats, err := engine.Stats(); err != nil || stats.LastSeq != 4 {
+		t.Errorf("Stats().LastSeq = %d, %v, want 4", stats.LastSeq, err)
+	}
+
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	engine, err = NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer engine.Close()
+	if seq := engine.LastSeq(); seq != 4 {
+		t.Errorf("LastSeq() after reopen = %d, want 4", seq)
+	}
+	if reopened, err := engine.GetEntry("a"); err != nil || reopened != a {
+		t.Errorf("GetEntry(a) after reopen = %+v, %v, want %+v", reopened, err, a)
+	}
+	if err := engine.Put("e", "5"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if seq := engine.LastSeq(); seq != 5 {
+		t.Errorf("LastSeq() after write following reopen = %d, want 5", seq)
+	}
+}
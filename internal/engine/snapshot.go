@@ -0,0 +1,220 @@
+package engine
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/jassi-singh/aether-kv/internal/index"
+)
+
+var (
+	// ErrSegmentsPinned is returned by Merge when a segment it would replace
+	// still holds a version an open snapshot may read.
+	ErrSegmentsPinned = errors.New("segments are referenced by an open snapshot")
+	// ErrSnapshotReleased is returned when reading from a released snapshot.
+	ErrSnapshotReleased = errors.New("snapshot has been released")
+)
+
+// Snapshot is a view of every key as of a sequence number. Writes numbered
+// after it, and expiries after the snapshot was taken, are invisible to it.
+// Nothing is copied when it is taken: while it is open, every version a
+// later write replaces or deletes is retained in the engine's history, and
+// the segment holding it is pinned so Merge refuses to replace it until the
+// snapshot is released. A Snapshot is safe for concurrent use, and must be
+// released once it is no longer needed.
+type Snapshot struct {
+	engine    *KVEngine
+	seq       uint64       // Sequence number the snapshot reads at
+	createdAt uint64       // Unix milliseconds the snapshot was taken at
+	mu        sync.RWMutex // Held for reading by Get so Release waits for reads in flight
+	released  bool
+}
+
+// NewSnapshot takes a snapshot as of the most recent write. Batches are
+// applied to the keyDir atomically, so the snapshot holds either all or
+// none of a batch.
+func (e *KVEngine) NewSnapshot() *Snapshot {
+	// Register before another write can supersede a version
+	e.swapMu.RLock()
+	defer e.swapMu.RUnlock()
+
+	return e.openSnapshot(e.visibleSeq.Load())
+}
+
+// NewSnapshotAt takes a snapshot as of sequence number seq, which must still
+// be readable with GetAt. Returns ErrSequenceUnavailable otherwise.
+func (e *KVEngine) NewSnapshotAt(seq uint64) (*Snapshot, error) {
+	e.swapMu.RLock()
+	defer e.swapMu.RUnlock()
+
+	if err := e.readable(seq); err != nil {
+		return nil, err
+	}
+	return e.openSnapshot(seq), nil
+}
+
+// openSnapshot registers a snapshot reading at seq.
+// Caller must hold e.swapMu.
+func (e *KVEngine) openSnapshot(seq uint64) *Snapshot {
+	e.addReader(seq)
+	s := &Snapshot{
+		engine:    e,
+		seq:       seq,
+		createdAt: nowMillis(),
+	}
+
+	slog.Info("snapshot: created",
+		"seq", seq)
+	return s
+}
+
+// Seq returns the sequence number the snapshot reads at.
+func (s *Snapshot) Seq() uint64 {
+	return s.seq
+}
+
+// Get retrieves the value the key had as of the snapshot's sequence number.
+// Returns an error if the key was not live then, if the snapshot has been
+// released or if any I/O operation fails.
+func (s *Snapshot) Get(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return "", ErrSnapshotReleased
+	}
+
+	// A merge must not swap segments between the lookup and the read
+	s.engine.swapMu.RLock()
+	defer s.engine.swapMu.RUnlock()
+	return s.engine.readAt(key, s.seq, s.createdAt)
+}
+
+// GetEntry is like Get but returns the value together with the sequence
+// number, time and expiry of the write that stored it.
+func (s *Snapshot) GetEntry(key string) (Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return Entry{}, ErrSnapshotReleased
+	}
+
+	// A merge must not swap segments between the lookup and the read
+	s.engine.swapMu.RLock()
+	defer s.engine.swapMu.RUnlock()
+	keyEntry, ok := s.engine.lookupAt(key, s.seq)
+	if !ok || keyEntry.Expired(s.createdAt) {
+		return Entry{}, ErrKeyNotFound
+	}
+	record, err := s.engine.readRecord(key, keyEntry)
+	if err != nil {
+		return Entry{}, err
+	}
+	return newEntry(record, keyEntry), nil
+}
+
+// Len returns the number of keys in the snapshot, or 0 once it has been
+// released. It walks every key, so it takes time proportional to their
+// number.
+func (s *Snapshot) Len() int {
+	n := 0
+	s.Ascend("", "", func(string, Key) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Iterator returns an iterator over every key in the snapshot in ascending order.
+func (s *Snapshot) Iterator() *Iterator {
+	return s.Scan("", "")
+}
+
+// Scan returns an iterator over the snapshot's keys in [start, end) in
+// ascending order. An empty end means no upper bound.
+func (s *Snapshot) Scan(start, end string) *Iterator {
+	return newIterator(s, s, s.createdAt, start, end)
+}
+
+// ScanPrefix returns an iterator over the snapshot's keys starting with
+// prefix in ascending order.
+func (s *Snapshot) ScanPrefix(prefix string) *Iterator {
+	return s.Scan(prefix, index.PrefixEnd(prefix))
+}
+
+// Ascend calls fn for every key live in the snapshot in [start, end) in
+// ascending order until fn returns false. An empty end means no upper
+// bound. It calls nothing once the snapshot has been released.
+func (s *Snapshot) Ascend(start, end string, fn func(key string, entry Key) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return
+	}
+
+	s.engine.ascendAt(start, end, s.seq, func(key string, entry Key) bool {
+		if entry.Expired(s.createdAt) {
+			return true
+		}
+		return fn(key, entry)
+	})
+}
+
+// Release drops the versions only the snapshot still needed and unpins
+// their segments so a merge may replace them. Reads from the snapshot fail
+// afterwards. It is safe to call more than once.
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.released {
+		return
+	}
+	s.released = true
+
+	e := s.engine
+	e.swapMu.Lock()
+	e.removeReader(s.seq)
+	e.pruneHistory()
+	e.swapMu.Unlock()
+
+	slog.Info("snapshot: released",
+		"seq", s.seq)
+}
+
+// pinSegments keeps the given segments from being replaced by a merge.
+func (e *KVEngine) pinSegments(fileIds []uint32) {
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+
+	for _, id := range fileIds {
+		e.pinned[id]++
+	}
+}
+
+// unpinSegments releases pins taken by pinSegments.
+func (e *KVEngine) unpinSegments(fileIds []uint32) {
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+
+	for _, id := range fileIds {
+		e.pinned[id]--
+		if e.pinned[id] <= 0 {
+			delete(e.pinned, id)
+		}
+	}
+}
+
+// anyPinned reports whether any of the given segments holds a version
+// retained for an open snapshot.
+func (e *KVEngine) anyPinned(fileIds []uint32) bool {
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+
+	for _, id := range fileIds {
+		if e.pinned[id] > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/jassi-singh/aether-kv/internal/cache"
+)
+
+// Stats summarizes the state of the engine for monitoring.
+type Stats struct {
+	Keys           int          `json:"keys"`                       // Keys in the key directory, including expired ones not yet reaped
+	KeyDirBytes    int64        `json:"keydir_bytes"`               // Approximate memory held by the key directory
+	Segments       int          `json:"segments"`                   // Log files, including the active file
+	ActiveFileId   uint32       `json:"active_file_id"`             // File id of the active log file
+	DiskBytes      int64        `json:"disk_bytes"`                 // Total size of all log files
+	DeadBytes      int64        `json:"dead_bytes"`                 // Bytes held by records the key directory no longer references
+	Merging        bool         `json:"merging"`                    // Whether a merge is running
+	LastSeq        uint64       `json:"last_seq"`                   // Log sequence number of the most recent write
+	Snapshots      int          `json:"snapshots"`                  // Open snapshots
+	Retained       int          `json:"retained_versions"`          // Superseded versions kept for open snapshots
+	MappedSegments int          `json:"mapped_segments"`            // Sealed segments read through memory mappings
+	FlushFailures  uint64       `json:"flush_failures"`             // Background flushes that have failed since startup
+	LastFlushError string       `json:"last_flush_error,omitempty"` // Error of the latest background flush if it failed
+	Cache          *cache.Stats `json:"cache,omitempty"`            // Read cache activity, nil when the cache is disabled
+}
+
+// Stats returns a summary of the engine's current state. Returns an error
+// if the size of a log file cannot be determined.
+func (e *KVEngine) Stats() (Stats, error) {
+	// A merge must not remove segments while their sizes are read
+	e.swapMu.RLock()
+	defer e.swapMu.RUnlock()
+
+	segments := e.file.Segments()
+	stats := Stats{
+		Keys:         e.keyDir.Len(),
+		KeyDirBytes:  e.keyDir.Bytes(),
+		Segments:     len(segments),
+		ActiveFileId: segments[len(segments)-1],
+		Merging:      e.merging.Load(),
+		LastSeq:      e.visibleSeq.Load(),
+		Retained:     e.retained,
+	}
+	stats.MappedSegments = e.file.MappedSegments()
+	e.snapMu.Lock()
+	for _, n := range e.readers {
+		stats.Snapshots += n
+	}
+	e.snapMu.Unlock()
+	if e.cache != nil {
+		cacheStats := e.cache.Stats()
+		stats.Cache = &cacheStats
+	}
+
+	flush := e.file.FlushStatus()
+	stats.FlushFailures = flush.Failures
+	if flush.LastError != nil {
+		stats.LastFlushError = flush.LastError.Error()
+	}
+
+	diskBytes, err := e.file.DiskSize()
+	if err != nil {
+		return Stats{}, fmt.Errorf("failed to get size of log files: %w", err)
+	}
+	stats.DiskBytes = diskBytes
+
+	e.statsMu.Lock()
+	for _, n := range e.deadBytes {
+		stats.DeadBytes += n
+	}
+	e.statsMu.Unlock()
+
+	return stats, nil
+}
+
+// Health returns an error if the engine cannot currently persist writes,
+// which is the case while background flushes of the log are failing or
+// once the log refuses appends.
+func (e *KVEngine) Health() error {
+	status := e.file.FlushStatus()
+	if status.Failed != nil {
+		return status.Failed
+	}
+	if err := status.LastError; err != nil {
+		return fmt.Errorf("background flush failing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// PutWithTTL stores a key-value pair that expires once ttl has passed.
+// Expired keys read as not found right away; the reaper later writes
+// tombstones for them so a merge can reclaim their space.
+// Returns an error if ttl is not positive or if encoding or I/O fails.
+func (e *KVEngine) PutWithTTL(key string, value string, ttl time.Duration) error {
+	batch := NewWriteBatch()
+	if err := batch.PutWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+
+	fileId, offset, err := e.write(context.Background(), batch)
+	if err != nil {
+		return fmt.Errorf("failed to put key %s: %w", key, err)
+	}
+
+	slog.Info("put: success",
+		"key", key,
+		"file_id", fileId,
+		"offset", offset,
+		"key_size", len(key),
+		"value_size", len(value),
+		"ttl", ttl)
+
+	e.maybeMerge()
+	return nil
+}
+
+// NoTTL is returned by TTL for a key that never expires.
+const NoTTL time.Duration = -1
+
+// TTL returns the time left before key expires, or NoTTL if it never
+// expires. Returns ErrKeyNotFound if the key does not exist.
+func (e *KVEngine) TTL(key string) (time.Duration, error) {
+	entry, ok := e.keyDir.Load(key)
+	now := nowMillis()
+	if !ok || entry.Expired(now) {
+		return 0, ErrKeyNotFound
+	}
+	if entry.Expiry == 0 {
+		return NoTTL, nil
+	}
+	return time.Duration(entry.Expiry-now) * time.Millisecond, nil
+}
+
+// Expire makes an existing key expire once ttl has passed, keeping its
+// value. A ttl that is not positive deletes the key right away. Holding
+// writeMu while the value is rewritten guarantees no concurrent write to
+// the key is lost. Returns false if the key does not exist, ErrClosed once
+// the engine is closed, and an error if encoding or I/O fails.
+func (e *KVEngine) Expire(key string, ttl time.Duration) (bool, error) {
+	if e.closed.Load() {
+		return false, ErrClosed
+	}
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	// A merge must not swap segments between the lookup and the read
+	e.swapMu.RLock()
+	entry, ok := e.keyDir.Load(key)
+	if !ok || entry.Expired(nowMillis()) {
+		e.swapMu.RUnlock()
+		return false, nil
+	}
+	value, err := readValue[string](e, key, entry)
+	e.swapMu.RUnlock()
+	if err != nil {
+		return false, fmt.Errorf("failed to expire key %s: %w", key, err)
+	}
+
+	batch := NewWriteBatch()
+	if ttl <= 0 {
+		batch.Delete(key)
+	} else if err := batch.PutWithTTL(key, value, ttl); err != nil {
+		return false, err
+	}
+
+	fileId, offset, err := e.appendBatch(batch)
+	if err != nil {
+		return false, fmt.Errorf("failed to expire key %s: %w", key, err)
+	}
+
+	slog.Info("expire: success",
+		"key", key,
+		"file_id", fileId,
+		"offset", offset,
+		"ttl", ttl)
+
+	e.maybeMerge()
+	return true, nil
+}
+
+// runReaper deletes expired keys every interval until the engine is closed.
+func (e *KVEngine) runReaper(interval time.Duration) {
+	defer e.bgWg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.C:
+			if _, err := e.reapExpired(); err != nil {
+				slog.Error("reaper: failed to delete expired keys",
+					"error", err)
+			}
+		}
+	}
+}
+
+// reapChunkSize is the most tombstones the reaper writes in one batch.
+const reapChunkSize = 1000
+
+// reapAttempts is how often the reaper writes a chunk whose keys keep
+// changing before leaving them to its next run.
+const reapAttempts = 3
+
+// expiredKey is a key the reaper found expired at version seq.
+type expiredKey struct {
+	key string
+	seq uint64
+}
+
+// reapExpired deletes every expired key and returns the number deleted.
+// Candidates are collected without writeMu, so writers only wait for one
+// chunk of reapChunkSize tombstones at a time. Each tombstone is
+// conditional on its key still holding the expired version observed, so a
+// key rewritten in the meantime keeps its new value.
+func (e *KVEngine) reapExpired() (int, error) {
+	now := nowMillis()
+	candidates := make([]expiredKey, 0)
+	e.keyDir.Ascend("", "", func(key string, entry Key) bool {
+		if entry.Expired(now) {
+			candidates = append(candidates, expiredKey{key: key, seq: entry.Seq})
+		}
+		return true
+	})
+
+	deleted := 0
+	for start := 0; start < len(candidates); start += reapChunkSize {
+		n, err := e.reapChunk(candidates[start:min(start+reapChunkSize, len(candidates))])
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+
+	slog.Info("reaper: deleted expired keys",
+		"count", deleted,
+		"candidates", len(candidates))
+
+	e.maybeMerge()
+	return deleted, nil
+}
+
+// reapChunk writes tombstones for chunk in one batch. If a key no longer
+// holds the version it expired at, the batch is written again without the
+// keys that changed. Returns the number of keys deleted.
+func (e *KVEngine) reapChunk(chunk []expiredKey) (int, error) {
+	for attempt := 0; attempt < reapAttempts && len(chunk) > 0; attempt++ {
+		batch := NewWriteBatch()
+		for _, c := range chunk {
+			batch.ops = append(batch.ops, batchOp{key: []byte(c.key), delete: true, cond: condExpired, version: c.seq})
+		}
+		_, _, err := e.write(context.Background(), batch)
+		if err == nil {
+			return len(chunk), nil
+		}
+		if !errors.Is(err, errConditionFailed) {
+			return 0, err
+		}
+		chunk = e.stillExpired(chunk)
+	}
+	return 0, nil
+}
+
+// stillExpired returns the keys of chunk that still hold the version they
+// expired at.
+func (e *KVEngine) stillExpired(chunk []expiredKey) []expiredKey {
+	now := nowMillis()
+	kept := make([]expiredKey, 0, len(chunk))
+	for _, c := range chunk {
+		if entry, ok := e.keyDir.Load(c.key); ok && entry.Seq == c.seq && entry.Expired(now) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// nowMillis returns the current time in Unix milliseconds, the unit used
+// for expiry times.
+func nowMillis() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+// expiryAfter returns the expiry time for a value written now with the given ttl.
+func expiryAfter(ttl time.Duration) uint64 {
+	return uint64(time.Now().Add(ttl).UnixMilli())
+}
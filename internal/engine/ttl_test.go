@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKVEngine_PutWithTTL(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if err := engine.PutWithTTL("session", "data", 0); err == nil {
+		t.Error("PutWithTTL() with zero ttl expected error, got nil")
+	}
+
+	if err := engine.PutWithTTL("session", "data", 50*time.Millisecond); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+	if err := engine.PutWithTTL("long", "data", time.Hour); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+	if got, err := engine.Get("session"); err != nil || got != "data" {
+		t.Errorf("Get(session) before expiry = %q, %v, want %q", got, err, "data")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := engine.Get("session"); err == nil {
+		t.Error("Get(session) succeeded after expiry")
+	}
+	if got, err := engine.Get("long"); err != nil || got != "data" {
+		t.Errorf("Get(long) = %q, %v, want %q", got, err, "data")
+	}
+
+	// A plain Put clears the expiry
+	if err := engine.Put("session", "again"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got, err := engine.Get("session"); err != nil || got != "again" {
+		t.Errorf("Get(session) after Put = %q, %v, want %q", got, err, "again")
+	}
+}
+
+func TestKVEngine_RecoverSkipsExpired(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := engine.Put("key", "forever"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := engine.PutWithTTL("key", "briefly", 50*time.Millisecond); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	reopened, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer reopened.Close()
+
+	// The expired value shadows the older one instead of resurrecting it
+	if size := reopened.GetKeyDirSize(); size != 0 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 0)
+	}
+	if _, err := reopened.Get("key"); err == nil {
+		t.Error("Get(key) succeeded after expiry and recovery")
+	}
+}
+
+func TestKVEngine_ReapExpired(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	batch := NewWriteBatch()
+	if err := batch.PutWithTTL("a", "1", 50*time.Millisecond); err != nil {
+		t.Fatalf("WriteBatch.PutWithTTL() error = %v", err)
+	}
+	if err := batch.PutWithTTL("b", "2", 50*time.Millisecond); err != nil {
+		t.Fatalf("WriteBatch.PutWithTTL() error = %v", err)
+	}
+	batch.Put("c", "3")
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	reaped, err := engine.reapExpired()
+	if err != nil {
+		t.Fatalf("reapExpired() error = %v", err)
+	}
+	if reaped != 2 {
+		t.Errorf("reapExpired() = %d, want %d", reaped, 2)
+	}
+	if size := engine.GetKeyDirSize(); size != 1 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 1)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Failed to close engine: %v", err)
+	}
+
+	reopened, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen engine: %v", err)
+	}
+	defer reopened.Close()
+	if got, err := reopened.Get("c"); err != nil || got != "3" {
+		t.Errorf("Get(c) = %q, %v, want %q", got, err, "3")
+	}
+	if size := reopened.GetKeyDirSize(); size != 1 {
+		t.Errorf("GetKeyDirSize() = %v, want %v", size, 1)
+	}
+}
+
+func TestKVEngine_TTLAndExpire(t *testing.T) {
+	cfg := setupTestConfig(t)
+
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if _, err := engine.TTL("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("TTL(missing) error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if ok, err := engine.Expire("missing", time.Hour); err != nil || ok {
+		t.Errorf("Expire(missing) = %v, %v, want false, nil", ok, err)
+	}
+
+	if err := engine.Put("key", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if ttl, err := engine.TTL("key"); err != nil || ttl != NoTTL {
+		t.Errorf("TTL(key) = %v, %v, want %v", ttl, err, NoTTL)
+	}
+
+	if ok, err := engine.Expire("key", time.Hour); err != nil || !ok {
+		t.Fatalf("Expire(key) = %v, %v, want true, nil", ok, err)
+	}
+	if ttl, err := engine.TTL("key"); err != nil || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("TTL(key) = %v, %v, want about 1h", ttl, err)
+	}
+	if got, err := engine.Get("key"); err != nil || got != "value" {
+		t.Errorf("Get(key) after Expire = %q, %v, want %q", got, err, "value")
+	}
+
+	if ok, err := engine.Expire("key", 0); err != nil || !ok {
+		t.Fatalf("Expire(key, 0) = %v, %v, want true, nil", ok, err)
+	}
+	if _, err := engine.Get("key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get(key) after Expire(0) error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestKVEngine_ReapExpiredChunks(t *testing.T) {
+	engine, err := NewKVEngine(setupTestConfig(t))
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	const keys = 2*reapChunkSize + 500
+	batch := NewWriteBatch()
+	for i := 0; i < keys; i++ {
+		if err := batch.PutWithTTL(fmt.Sprintf("key%04d", i), "value", time.Hour); err != nil {
+			t.Fatalf("WriteBatch.PutWithTTL() error = %v", err)
+		}
+	}
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	// Expire the keys in the keyDir rather than waiting on a short TTL, which
+	// a slow write could outlive before the keys are even applied
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		entry, _ := engine.keyDir.Load(key)
+		entry.Expiry = 1
+		engine.keyDir.Swap(key, entry)
+	}
+
+	// A key rewritten after the reaper saw it expire keeps its new value,
+	// and the rest of its chunk is still deleted
+	chunk := []expiredKey{}
+	for _, key := range []string{"key0000", "key0001", "key0002"} {
+		entry, _ := engine.keyDir.Load(key)
+		chunk = append(chunk, expiredKey{key: key, seq: entry.Seq})
+	}
+	if err := engine.Put("key0001", "rewritten"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if deleted, err := engine.reapChunk(chunk); err != nil || deleted != 2 {
+		t.Errorf("reapChunk() = %d, %v, want 2 deleted", deleted, err)
+	}
+	if got, err := engine.Get("key0001"); err != nil || got != "rewritten" {
+		t.Errorf("Get(key0001) = %q, %v, want rewritten", got, err)
+	}
+
+	reaped, err := engine.reapExpired()
+	if err != nil {
+		t.Fatalf("reapExpired() error = %v", err)
+	}
+	if reaped != keys-3 {
+		t.Errorf("reapExpired() = %d, want %d", reaped, keys-3)
+	}
+	if size := engine.GetKeyDirSize(); size != 1 {
+		t.Errorf("GetKeyDirSize() = %v, want 1", size)
+	}
+}
@@ -0,0 +1,164 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	// ErrConflict is returned by Txn.Commit when a key the transaction read
+	// was written by someone else before it committed. Nothing of the
+	// transaction is written, so it can be retried from the start.
+	ErrConflict = errors.New("transaction conflict")
+	// ErrTxnDone is returned when using a transaction after Commit or Rollback.
+	ErrTxnDone = errors.New("transaction already committed or rolled back")
+)
+
+// Txn is an optimistic read-write transaction. Its writes are buffered
+// until Commit, and every key it reads is noted with the version it was
+// read at. Commit appends the writes as one batch only if none of those
+// keys has been written since, which makes transactions serializable
+// without holding locks while they run. A Txn is not safe for concurrent
+// use.
+type Txn struct {
+	engine  *KVEngine
+	batch   WriteBatch
+	reads   map[string]batchOp // Condition each read key must still meet at commit
+	written map[string]int     // Index in batch of the last operation on each key
+	done    bool
+}
+
+// Begin starts a transaction.
+func (e *KVEngine) Begin() *Txn {
+	return &Txn{
+		engine:  e,
+		reads:   make(map[string]batchOp),
+		written: make(map[string]int),
+	}
+}
+
+// Get returns the value of key as the transaction sees it: its own
+// buffered write if it has one, else the current value, whose version is
+// checked again at commit. Returns ErrKeyNotFound if the key does not
+// exist, which is checked at commit as well.
+func (t *Txn) Get(key string) (string, error) {
+	if t.done {
+		return "", ErrTxnDone
+	}
+
+	if i, ok := t.written[key]; ok {
+		op := t.batch.ops[i]
+		if op.delete || (op.expiry != 0 && nowMillis() >= op.expiry) {
+			return "", ErrKeyNotFound
+		}
+		return string(op.value), nil
+	}
+
+	value, version, err := t.engine.GetVersion(key)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return "", err
+	}
+
+	// Later reads of the key must agree with the first, so only it is kept
+	if _, ok := t.reads[key]; !ok {
+		read := batchOp{key: []byte(key), cond: condVersion, version: version}
+		if err != nil {
+			read.cond = condAbsent
+		}
+		t.reads[key] = read
+	}
+	return value, err
+}
+
+// Put buffers storing value under key.
+func (t *Txn) Put(key string, value string) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.batch.Put(key, value)
+	t.written[key] = len(t.batch.ops) - 1
+	return nil
+}
+
+// PutWithTTL buffers storing value under key until ttl has passed, measured
+// from when the write is buffered. ttl must be positive.
+func (t *Txn) PutWithTTL(key string, value string, ttl time.Duration) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	if err := t.batch.PutWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+	t.written[key] = len(t.batch.ops) - 1
+	return nil
+}
+
+// Delete buffers removing key.
+func (t *Txn) Delete(key string) error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.batch.Delete(key)
+	t.written[key] = len(t.batch.ops) - 1
+	return nil
+}
+
+// Commit validates that every key the transaction read still has the
+// version it was read at, or is still missing, and if so appends its writes
+// atomically followed by a commit marker. Validation and append happen
+// under the lock that orders all writes, so no other write can slip in
+// between. Returns ErrConflict if validation fails, in which case nothing
+// is written. The transaction is finished either way.
+func (t *Txn) Commit() error {
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+
+	for _, read := range t.reads {
+		t.batch.reads = append(t.batch.reads, read)
+	}
+
+	e := t.engine
+	if t.batch.Len() == 0 {
+		// Nothing to write, but the reads must still have been a consistent
+		// view. Batches are applied to the keyDir atomically under swapMu.
+		e.swapMu.RLock()
+		err := e.checkConditions(&t.batch, 0, nil)
+		e.swapMu.RUnlock()
+		return conflictError(err)
+	}
+
+	fileId, offset, err := e.write(context.Background(), &t.batch)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", conflictError(err))
+	}
+
+	slog.Info("txn: committed",
+		"reads", len(t.reads),
+		"writes", t.batch.Len(),
+		"file_id", fileId,
+		"offset", offset)
+
+	e.maybeMerge()
+	return nil
+}
+
+// Rollback discards the transaction's buffered writes. It is safe to call
+// after Commit, where it does nothing.
+func (t *Txn) Rollback() {
+	t.done = true
+}
+
+// conflictError reports a failed read condition as ErrConflict.
+func conflictError(err error) error {
+	if errors.Is(err, errConditionFailed) {
+		slog.Debug("txn: conflict",
+			"error", err)
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
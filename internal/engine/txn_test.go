@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestKVEngine_Txn(t *testing.T) {
+	cfg := setupTestConfig(t)
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if err := engine.Put("a", "1"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// Writes are buffered and visible to the transaction only
+	txn := engine.Begin()
+	if got, err := txn.Get("a"); err != nil || got != "1" {
+		t.Fatalf("Txn.Get(a) = %q, %v, want 1", got, err)
+	}
+	txn.Put("a", "2")
+	txn.Put("b", "new")
+	txn.Delete("c")
+	if got, err := txn.Get("a"); err != nil || got != "2" {
+		t.Errorf("Txn.Get(a) after Put = %q, %v, want 2", got, err)
+	}
+	if _, err := txn.Get("c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Txn.Get(c) after Delete error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if got, err := engine.Get("a"); err != nil || got != "1" {
+		t.Errorf("Get(a) before Commit = %q, %v, want 1", got, err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if got, err := engine.Get("b"); err != nil || got != "new" {
+		t.Errorf("Get(b) after Commit = %q, %v, want new", got, err)
+	}
+	if err := txn.Put("a", "3"); !errors.Is(err, ErrTxnDone) {
+		t.Errorf("Put() after Commit error = %v, want %v", err, ErrTxnDone)
+	}
+
+	// A key read by the transaction and written since is a conflict
+	txn = engine.Begin()
+	txn.Get("a")
+	txn.Put("b", "lost")
+	if err := engine.Put("a", "other"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Errorf("Commit() after concurrent write error = %v, want %v", err, ErrConflict)
+	}
+	if got, err := engine.Get("b"); err != nil || got != "new" {
+		t.Errorf("Get(b) after conflict = %q, %v, want new", got, err)
+	}
+
+	// So is a key read as missing that was created since
+	txn = engine.Begin()
+	if _, err := txn.Get("d"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Txn.Get(d) error = %v, want %v", err, ErrKeyNotFound)
+	}
+	txn.Put("e", "value")
+	if err := engine.Put("d", "created"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Errorf("Commit() after key was created error = %v, want %v", err, ErrConflict)
+	}
+
+	// Read-only transactions are validated too
+	txn = engine.Begin()
+	txn.Get("a")
+	if err := engine.Delete("a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Errorf("read-only Commit() after delete error = %v, want %v", err, ErrConflict)
+	}
+
+	// Writes to keys the transaction never read do not conflict
+	txn = engine.Begin()
+	txn.Put("a", "blind")
+	if err := engine.Put("a", "other"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Errorf("Commit() of a blind write error = %v", err)
+	}
+}
+
+func TestKVEngine_TxnConcurrentTransfers(t *testing.T) {
+	for _, mode := range []string{"periodic", "batch"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := setupTestConfig(t)
+			cfg.SYNC_MODE = mode
+			engine, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer engine.Close()
+
+			const accounts, balance = 4, 100
+			for i := 0; i < accounts; i++ {
+				if err := engine.Put(fmt.Sprintf("acct%d", i), strconv.Itoa(balance)); err != nil {
+					t.Fatalf("Put() error = %v", err)
+				}
+			}
+
+			// Move money between accounts, retrying on conflict; the total
+			// only stays put if no transfer is applied on a stale read
+			transfer := func(from, to string) error {
+				for {
+					txn := engine.Begin()
+					a, err := txn.Get(from)
+					if err != nil {
+						return err
+					}
+					b, err := txn.Get(to)
+					if err != nil {
+						return err
+					}
+					x, _ := strconv.Atoi(a)
+					y, _ := strconv.Atoi(b)
+					txn.Put(from, strconv.Itoa(x-1))
+					txn.Put(to, strconv.Itoa(y+1))
+					err = txn.Commit()
+					if !errors.Is(err, ErrConflict) {
+						return err
+					}
+				}
+			}
+
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 25; i++ {
+						from, to := (w+i)%accounts, (w+i+1)%accounts
+						if err := transfer(fmt.Sprintf("acct%d", from), fmt.Sprintf("acct%d", to)); err != nil {
+							t.Errorf("transfer error = %v", err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			total := 0
+			for i := 0; i < accounts; i++ {
+				value, err := engine.Get(fmt.Sprintf("acct%d", i))
+				if err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				n, _ := strconv.Atoi(value)
+				total += n
+			}
+			if total != accounts*balance {
+				t.Errorf("total balance = %d, want %d", total, accounts*balance)
+			}
+		})
+	}
+}
+
+func TestKVEngine_TxnFirstCommitterWins(t *testing.T) {
+	for _, mode := range []string{"periodic", "batch"} {
+		t.Run(mode, func(t *testing.T) {
+			cfg := setupTestConfig(t)
+			cfg.SYNC_MODE = mode
+			engine, err := NewKVEngine(cfg)
+			if err != nil {
+				t.Fatalf("Failed to create engine: %v", err)
+			}
+			defer engine.Close()
+
+			if err := engine.Put("counter", "0"); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			// Every transaction reads the same version before any commits.
+			// Under group commit they are also queued into a single group.
+			const txns = 4
+			var read, wg sync.WaitGroup
+			read.Add(txns)
+			errs := make(chan error, txns)
+			if mode == "batch" {
+				engine.writeMu.Lock()
+			}
+			for i := 0; i < txns; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					txn := engine.Begin()
+					value, err := txn.Get("counter")
+					read.Done()
+					if err != nil {
+						errs <- err
+						return
+					}
+					read.Wait()
+					n, _ := strconv.Atoi(value)
+					txn.Put("counter", strconv.Itoa(n+1))
+					errs <- txn.Commit()
+				}()
+			}
+			if mode == "batch" {
+				for queued := 0; queued < txns; {
+					engine.commitMu.Lock()
+					queued = len(engine.commitQueue)
+					engine.commitMu.Unlock()
+				}
+				engine.writeMu.Unlock()
+			}
+			wg.Wait()
+			close(errs)
+
+			committed := 0
+			for err := range errs {
+				if err == nil {
+					committed++
+				} else if !errors.Is(err, ErrConflict) {
+					t.Errorf("Commit() error = %v, want nil or %v", err, ErrConflict)
+				}
+			}
+			if committed != 1 {
+				t.Errorf("%d transactions committed from the same read, want 1", committed)
+			}
+			if value, err := engine.Get("counter"); err != nil || value != "1" {
+				t.Errorf("Get(counter) = %q, %v, want 1", value, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/format"
+)
+
+var (
+	// ErrWatchLagged ends a watch whose subscriber fell so far behind that
+	// its buffer filled up. Writers never wait for subscribers; the watch
+	// can be resumed after the last event received, which replays the log.
+	ErrWatchLagged = errors.New("watcher fell behind")
+	// ErrWatchCompacted is returned when a watch cannot be resumed from the
+	// requested position because a merge has discarded records after it.
+	ErrWatchCompacted = errors.New("log compacted past requested position")
+)
+
+// DefaultWatchBuffer is the number of events buffered for a subscriber when
+// WatchOptions.Buffer is 0.
+const DefaultWatchBuffer = 1024
+
+// EventKind tells what a committed mutation did to its key.
+type EventKind uint8
+
+const (
+	EventPut    EventKind = iota + 1 // The key was stored
+	EventDelete                      // The key was deleted, including by the reaper once expired
+)
+
+// String returns the name of the kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	}
+	return fmt.Sprintf("EventKind(%d)", uint8(k))
+}
+
+// Event is a committed mutation of a single key.
+type Event struct {
+	Kind   EventKind
+	Key    string
+	Value  string    // Stored value, empty for deletes
+	Seq    uint64    // Log sequence number of the write
+	Time   time.Time // Wall-clock time of the write
+	Expiry time.Time // Time at which a put expires, zero if it never does
+}
+
+// WatchOptions holds the optional settings of Watch.
+type WatchOptions struct {
+	After  uint64 // Deliver events with higher sequence numbers, replaying the log for those already written
+	Buffer int    // Events buffered before the subscriber counts as lagging (0 uses DefaultWatchBuffer)
+}
+
+// Watcher delivers committed mutations of the keys under a prefix in
+// sequence order. Events are read from Events; once that channel is closed,
+// Err tells why. A Watcher must be closed once it is no longer needed.
+type Watcher struct {
+	engine *KVEngine
+	prefix string
+	live   chan Event    // Events published by writers, bounded by the buffer size
+	events chan Event    // Events handed to the subscriber
+	lagged chan struct{} // Closed once live overflowed; no event is published after it
+	stop   chan struct{} // Closed by close to end the watch, or once it ended
+	once   sync.Once     // Guards closing stop and setting reason
+	reason error         // Error reported once stopped, nil after Close
+	err    error         // Set before events is closed
+}
+
+// Watch subscribes to the committed mutations of every key starting with
+// prefix, in the order they were written. Events with sequence numbers
+// after opts.After that are already in the log are replayed from disk
+// first, then new writes follow as they are applied, without gaps or
+// duplicates. Writers never wait for a subscriber: one that lets its
+// buffer fill up is dropped and its events channel closed with
+// ErrWatchLagged, after which it can resume after the last event received.
+// Returns ErrSequenceUnavailable if opts.After is ahead of the last write.
+func (e *KVEngine) Watch(prefix string, opts WatchOptions) (*Watcher, error) {
+	if opts.Buffer < 0 {
+		return nil, fmt.Errorf("invalid watch buffer %d: must not be negative", opts.Buffer)
+	}
+	if opts.Buffer == 0 {
+		opts.Buffer = DefaultWatchBuffer
+	}
+	w := &Watcher{
+		engine: e,
+		prefix: prefix,
+		live:   make(chan Event, opts.Buffer),
+		events: make(chan Event),
+		lagged: make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+
+	// Writes are published under swapMu, so every write after until is
+	// delivered live and every one up to it is in the log
+	e.swapMu.RLock()
+	defer e.swapMu.RUnlock()
+	until := e.visibleSeq.Load()
+	if opts.After > until {
+		return nil, fmt.Errorf("watch after sequence %d is ahead of the last write %d: %w", opts.After, until, ErrSequenceUnavailable)
+	}
+
+	// Close stops every registered watcher, so none may start once it has
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+	if e.closed.Load() {
+		return nil, ErrClosed
+	}
+	e.watchers[w] = true
+	e.bgWg.Add(1)
+	go w.run(opts.After, until)
+
+	slog.Info("watch: started",
+		"prefix", prefix,
+		"after", opts.After,
+		"until", until)
+	return w, nil
+}
+
+// Events returns the channel events are delivered on. It is closed once
+// the watch ends, after which Err reports why.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns the error that ended the watch: ErrWatchLagged, ErrClosed if
+// the engine was closed, or the error that stopped the replay. It returns
+// nil while the watch runs and after Close, and must only be called once
+// the events channel has been closed.
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// Close ends the watch and closes its events channel. It is safe to call
+// more than once.
+func (w *Watcher) Close() {
+	w.close(nil)
+}
+
+// close ends the watch, reporting reason from Err.
+func (w *Watcher) close(reason error) {
+	w.once.Do(func() {
+		w.engine.watchMu.Lock()
+		delete(w.engine.watchers, w)
+		w.engine.watchMu.Unlock()
+
+		w.reason = reason
+		close(w.stop)
+	})
+}
+
+// run replays the log up to sequence number until, then forwards live
+// events until the watch is closed or falls behind.
+func (w *Watcher) run(after, until uint64) {
+	defer w.engine.bgWg.Done()
+	defer close(w.events)
+	defer w.close(nil)
+
+	last, err := w.replay(after, until)
+	if err != nil {
+		w.err = err
+		return
+	}
+
+	for {
+		select {
+		case ev := <-w.live:
+			if !w.send(ev) {
+				w.err = w.reason
+				return
+			}
+			last = ev.Seq
+		case <-w.lagged:
+			// Nothing is published once lagged, so what is buffered is all
+			for len(w.live) > 0 {
+				ev := <-w.live
+				if !w.send(ev) {
+					w.err = w.reason
+					return
+				}
+				last = ev.Seq
+			}
+			w.err = fmt.Errorf("watch of prefix %q dropped after sequence %d: %w", w.prefix, last, ErrWatchLagged)
+			return
+		case <-w.stop:
+			w.err = w.reason
+			return
+		}
+	}
+}
+
+// send hands ev to the subscriber. Returns false if the watch was closed
+// first.
+func (w *Watcher) send(ev Event) bool {
+	select {
+	case w.events <- ev:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// replay delivers the committed writes numbered after `after` up to and
+// including until from the log, segment by segment, and returns the
+// sequence number of the last. Sequence numbers of committed records have
+// no gaps, so a missing one means a merge dropped the record, and the
+// replay fails with ErrWatchCompacted rather than skip it.
+func (w *Watcher) replay(after, until uint64) (uint64, error) {
+	e := w.engine
+	next := after + 1
+	for cursor := uint32(0); next <= until; {
+		fileId, events, ok, err := e.readSegmentEvents(cursor, next, until)
+		if err != nil {
+			return after, err
+		}
+		if !ok {
+			break
+		}
+		cursor = fileId + 1
+
+		for _, ev := range events {
+			if ev.Seq != next {
+				return after, fmt.Errorf("sequence %d missing from the log: %w", next, ErrWatchCompacted)
+			}
+			next++
+			if !strings.HasPrefix(ev.Key, w.prefix) {
+				continue
+			}
+			if !w.send(ev) {
+				return after, w.reason
+			}
+		}
+	}
+	if next <= until {
+		return after, fmt.Errorf("sequence %d missing from the log: %w", next, ErrWatchCompacted)
+	}
+	return until, nil
+}
+
+// readSegmentEvents reads the segment with the lowest file id not below
+// cursor and returns its id and an event for every committed record
+// numbered from `from` up to and including until, in log order. A sealed
+// segment whose hint file shows it holds nothing from `from` on is not
+// read. Returns false if there is no such segment. Merges are held off
+// while the segment is read, so it is read whole and consistent.
+func (e *KVEngine) readSegmentEvents(cursor uint32, from, until uint64) (uint32, []Event, bool, error) {
+	e.sealMu.Lock()
+	defer e.sealMu.Unlock()
+
+	segments := e.file.Segments()
+	i := sort.Search(len(segments), func(i int) bool { return segments[i] >= cursor })
+	if i == len(segments) {
+		return 0, nil, false, nil
+	}
+	fileId := segments[i]
+
+	if fileId != segments[len(segments)-1] {
+		if _, info, err := e.readHintFile(fileId); err == nil && info.MaxSeq < from {
+			return fileId, nil, true, nil
+		}
+	}
+
+	segment, err := e.file.SegmentReader(fileId)
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("failed to open segment %d: %w", fileId, err)
+	}
+	events := make([]Event, 0)
+	_, err = e.readCommitted(bufio.NewReader(segment), fileId, func(record *format.Record, _ int64, _ int) {
+		if record.Seq >= from && record.Seq <= until {
+			events = append(events, recordEvent(record))
+		}
+	})
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("failed to read segment %d: %w", fileId, err)
+	}
+	return fileId, events, true, nil
+}
+
+// recordEvent returns the event describing a committed record.
+func recordEvent(record *format.Record) Event {
+	ev := Event{
+		Kind: EventPut,
+		Key:  string(record.Key),
+		Seq:  record.Seq,
+		Time: record.Time(),
+	}
+	if record.Flag == format.FlagTombstone {
+		ev.Kind = EventDelete
+	} else {
+		ev.Value = string(record.Value)
+	}
+	if record.Expiry != 0 {
+		ev.Expiry = time.UnixMilli(int64(record.Expiry))
+	}
+	return ev
+}
+
+// publish hands the writes of a group just applied to the keyDir to every
+// subscribed watcher of a matching prefix without waiting: a watcher whose
+// buffer is full is unsubscribed instead, ending its watch once it has
+// delivered what was buffered.
+// Caller must hold e.swapMu.
+func (e *KVEngine) publish(group []*commitRequest) {
+	e.watchMu.Lock()
+	defer e.watchMu.Unlock()
+	if len(e.watchers) == 0 {
+		return
+	}
+
+	for _, req := range group {
+		for i, hint := range req.hints {
+			op := req.batch.ops[i]
+			key := bytesToString(op.key)
+			var ev *Event
+			for w, subscribed := range e.watchers {
+				if !subscribed || !strings.HasPrefix(key, w.prefix) {
+					continue
+				}
+				if ev == nil {
+					ev = &Event{
+						Kind: EventPut,
+						Key:  string(op.key),
+						Seq:  hint.Seq,
+						Time: time.Unix(0, int64(hint.Timestamp)),
+					}
+					if op.delete {
+						ev.Kind = EventDelete
+					} else {
+						ev.Value = string(op.value)
+					}
+					if op.expiry != 0 {
+						ev.Expiry = time.UnixMilli(int64(op.expiry))
+					}
+				}
+
+				select {
+				case w.live <- *ev:
+				default:
+					// Unsubscribed under watchMu, so nothing follows the overflow
+					e.watchers[w] = false
+					close(w.lagged)
+					slog.Warn("watch: subscriber fell behind, dropping it",
+						"prefix", w.prefix,
+						"seq", ev.Seq)
+				}
+			}
+		}
+	}
+}
+
+// closeWatchers ends every watch with ErrClosed.
+func (e *KVEngine) closeWatchers() {
+	e.watchMu.Lock()
+	watchers := make([]*Watcher, 0, len(e.watchers))
+	for w := range e.watchers {
+		watchers = append(watchers, w)
+	}
+	e.watchMu.Unlock()
+
+	for _, w := range watchers {
+		w.close(ErrClosed)
+	}
+}
@@ -0,0 +1,248 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event of w, failing the test if none arrives
+// in time or the watch has ended.
+func nextEvent(t *testing.T, w *Watcher) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("watch ended early: %v", w.Err())
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return Event{}
+}
+
+// watchEnd drains w until its events channel is closed and returns Err.
+func watchEnd(t *testing.T, w *Watcher) ([]Event, error) {
+	t.Helper()
+	var events []Event
+	for {
+		select {
+		case ev, ok := <-w.Events():
+			if !ok {
+				return events, w.Err()
+			}
+			events = append(events, ev)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the watch to end")
+		}
+	}
+}
+
+func TestKVEngine_Watch(t *testing.T) {
+	cfg := setupTestConfig(t)
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if err := engine.Put("user:0", "before"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	w, err := engine.Watch("user:", WatchOptions{After: engine.LastSeq()})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	batch := NewWriteBatch()
+	batch.Put("user:1", "alice")
+	batch.Put("order:1", "ignored")
+	batch.PutWithTTL("user:2", "bob", time.Hour)
+	if err := engine.Write(batch); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := engine.Delete("user:1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	want := []struct {
+		kind  EventKind
+		key   string
+		value string
+	}{
+		{EventPut, "user:1", "alice"},
+		{EventPut, "user:2", "bob"},
+		{EventDelete, "user:1", ""},
+	}
+	var last uint64
+	for _, w2 := range want {
+		ev := nextEvent(t, w)
+		if ev.Kind != w2.kind || ev.Key != w2.key || ev.Value != w2.value || ev.Seq <= last {
+			t.Errorf("event = %+v, want %v %s=%q after sequence %d", ev, w2.kind, w2.key, w2.value, last)
+		}
+		if ev.Key == "user:2" && ev.Expiry.IsZero() {
+			t.Errorf("event for user:2 has no expiry")
+		}
+		last = ev.Seq
+	}
+	if last != engine.LastSeq() {
+		t.Errorf("last event sequence = %d, want %d", last, engine.LastSeq())
+	}
+
+	if _, err := engine.Watch("", WatchOptions{After: last + 1}); !errors.Is(err, ErrSequenceUnavailable) {
+		t.Errorf("Watch() ahead of the last write error = %v, want %v", err, ErrSequenceUnavailable)
+	}
+
+	w.Close()
+	if _, err := watchEnd(t, w); err != nil {
+		t.Errorf("Err() after Close() = %v, want nil", err)
+	}
+}
+
+func TestKVEngine_WatchExpiredAcrossMerge(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 128
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	if err := engine.PutWithTTL("session", "token", time.Hour); err != nil {
+		t.Fatalf("PutWithTTL() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := engine.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	w, err := engine.Watch("session", WatchOptions{After: engine.LastSeq()})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	entry, _ := engine.keyDir.Load("session")
+	entry.Expiry = 1
+	engine.keyDir.Swap("session", entry)
+
+	// A merge must leave the expired key to the reaper, which tells watchers
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if _, ok := engine.keyDir.Load("session"); !ok {
+		t.Fatal("Merge() dropped the expired key")
+	}
+	if reaped, err := engine.reapExpired(); err != nil || reaped != 1 {
+		t.Fatalf("reapExpired() = %d, %v, want 1", reaped, err)
+	}
+	if ev := nextEvent(t, w); ev.Kind != EventDelete || ev.Key != "session" {
+		t.Errorf("event = %+v, want %v session", ev, EventDelete)
+	}
+}
+
+func TestKVEngine_WatchReplay(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 256
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	// Spread the history over several segments, some described by hints
+	for i := 0; i < 20; i++ {
+		if err := engine.Put(fmt.Sprintf("key%02d", i), "value"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	engine.bgWg.Wait()
+	if len(engine.file.Segments()) < 3 {
+		t.Fatalf("expected several segments, got %v", engine.file.Segments())
+	}
+
+	// Resuming from the middle replays only what came after
+	w, err := engine.Watch("", WatchOptions{After: 12})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+	for seq := uint64(13); seq <= 20; seq++ {
+		if ev := nextEvent(t, w); ev.Seq != seq || ev.Key != fmt.Sprintf("key%02d", seq-1) {
+			t.Fatalf("replayed event = %+v, want key%02d at sequence %d", ev, seq-1, seq)
+		}
+	}
+	// ...and live writes follow without a gap
+	if err := engine.Put("live", "value"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if ev := nextEvent(t, w); ev.Seq != 21 || ev.Key != "live" {
+		t.Errorf("live event = %+v, want live at sequence 21", ev)
+	}
+
+	// A merge that drops overwritten records makes older positions unreachable
+	for i := 0; i < 20; i++ {
+		if err := engine.Delete(fmt.Sprintf("key%02d", i)); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+	}
+	if err := engine.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	compacted, err := engine.Watch("", WatchOptions{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if _, err := watchEnd(t, compacted); !errors.Is(err, ErrWatchCompacted) {
+		t.Errorf("Watch() from the start after Merge() error = %v, want %v", err, ErrWatchCompacted)
+	}
+}
+
+func TestKVEngine_WatchLagged(t *testing.T) {
+	cfg := setupTestConfig(t)
+	engine, err := NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	defer engine.Close()
+
+	w, err := engine.Watch("", WatchOptions{Buffer: 2})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer w.Close()
+
+	// Writers never wait for the subscriber, which is dropped instead
+	for i := 1; i <= 10; i++ {
+		if err := engine.Put(fmt.Sprintf("key%d", i), "value"); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+	events, err := watchEnd(t, w)
+	if !errors.Is(err, ErrWatchLagged) {
+		t.Fatalf("Err() of a slow subscriber = %v, want %v", err, ErrWatchLagged)
+	}
+	if len(events) == 0 || len(events) >= 10 {
+		t.Fatalf("slow subscriber received %d events, want some but not all", len(events))
+	}
+
+	// It resumes from the last event it received by replaying the log
+	resumed, err := engine.Watch("", WatchOptions{After: events[len(events)-1].Seq})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer resumed.Close()
+	for seq := events[len(events)-1].Seq + 1; seq <= 10; seq++ {
+		if ev := nextEvent(t, resumed); ev.Seq != seq {
+			t.Fatalf("resumed event = %+v, want sequence %d", ev, seq)
+		}
+	}
+
+	engine.Close()
+	if _, err := watchEnd(t, resumed); !errors.Is(err, ErrClosed) {
+		t.Errorf("Err() after engine Close() = %v, want %v", err, ErrClosed)
+	}
+}
@@ -7,23 +7,50 @@ import (
 	"fmt"
 	"hash/crc32"
 	"log/slog"
+	"time"
 )
 
 // Record flag constants define the type of log entry.
 const (
 	FlagNormal    uint8 = 0 // Normal log entry containing a key-value pair
 	FlagTombstone uint8 = 1 // Tombstone marker indicating a deleted entry
-	FlagCommit    uint8 = 2 // Commit marker indicating a transaction commit
+	FlagCommit    uint8 = 2 // Commit marker terminating a batch of records
+	FlagExpiring  uint8 = 3 // Normal log entry that expires at the time stored before its value
 )
 
+// flagSequenced is set in the flag byte of records that carry a sequence
+// number. Decode clears it, so Record.Flag only ever holds one of the flags
+// above. Records written before sequence numbers existed lack it.
+const flagSequenced uint8 = 0x80
+
+// SeqSize is the size in bytes of the sequence number stored at the start of
+// the value area of a sequenced record.
+const SeqSize = 8
+
+// ExpirySize is the size in bytes of the expiry time stored at the start of
+// the value area of a FlagExpiring record.
+const ExpirySize = 8
+
+// CommitValueSize is the size in bytes of the value carried by a commit
+// record: the number of records it commits (uint32, little-endian), the
+// CRC32 of their encoded bytes (uint32, little-endian) and the highest
+// sequence number assigned when it was written (uint64, little-endian).
+const CommitValueSize = 16
+
+// legacyCommitValueSize is the size of the value of commit records written
+// before they carried a sequence number.
+const legacyCommitValueSize = 8
+
 // Record represents a single key-value entry in the log file.
 // It includes metadata (CRC, timestamp, sizes, flag) and the actual key-value data.
 type Record struct {
 	CRC       uint32 // CRC32 checksum for data integrity verification
-	Timestamp uint64 // Unix timestamp when the record was created
+	Timestamp uint64 // Unix time in nanoseconds when the record was created (seconds if written without sequence number)
 	Keysize   uint32 // Size of the key in bytes
 	Valuesize uint32 // Size of the value in bytes
-	Flag      uint8  // Record type flag (normal or tombstone)
+	Flag      uint8  // Record type flag (normal, tombstone, commit or expiring)
+	Seq       uint64 // Sequence number of the write that produced the record (0 if written without one)
+	Expiry    uint64 // Unix time in milliseconds at which a FlagExpiring record expires
 	Key       []byte // The key bytes
 	Value     []byte // The value bytes
 }
@@ -41,17 +68,43 @@ type Codec interface {
 // [16:20] - Value size (uint32, little-endian)
 // [20:21] - Flag (uint8)
 // [HEADER_SIZE:] - Key bytes followed by value bytes
+// A record with a non-zero Seq is sequenced: its flag byte has
+// flagSequenced set and the sequence number (uint64, little-endian) comes
+// first in the value area. FlagExpiring records then store the expiry time
+// (uint64, little-endian) in front of the value bytes. The encoded value
+// size includes both.
 // Returns the encoded byte array and any error encountered.
 func (r *Record) Encode(headerSize uint32) ([]byte, error) {
-	buffer := make([]byte, int(headerSize)+len(r.Key)+len(r.Value))
+	valueStart := int(headerSize) + len(r.Key)
+	flag := r.Flag
+	seqSize := 0
+	if r.Seq != 0 {
+		flag |= flagSequenced
+		seqSize = SeqSize
+	}
+	expirySize := 0
+	if r.Flag == FlagExpiring {
+		expirySize = ExpirySize
+	}
+	valuesize := r.Valuesize + uint32(seqSize+expirySize)
+	buffer := make([]byte, valueStart+seqSize+expirySize+len(r.Value))
 
 	binary.LittleEndian.PutUint64(buffer[4:12], r.Timestamp)
 	binary.LittleEndian.PutUint32(buffer[12:16], r.Keysize)
-	binary.LittleEndian.PutUint32(buffer[16:20], r.Valuesize)
-	buffer[20] = r.Flag
-
-	copy(buffer[headerSize:int(headerSize)+len(r.Key)], r.Key)
-	copy(buffer[int(headerSize)+len(r.Key):], r.Value)
+	binary.LittleEndian.PutUint32(buffer[16:20], valuesize)
+	buffer[20] = flag
+
+	copy(buffer[headerSize:valueStart], r.Key)
+	pos := valueStart
+	if r.Seq != 0 {
+		binary.LittleEndian.PutUint64(buffer[pos:pos+SeqSize], r.Seq)
+		pos += SeqSize
+	}
+	if r.Flag == FlagExpiring {
+		binary.LittleEndian.PutUint64(buffer[pos:pos+ExpirySize], r.Expiry)
+		pos += ExpirySize
+	}
+	copy(buffer[pos:], r.Value)
 
 	crc := crc32.ChecksumIEEE(buffer[4:])
 	binary.LittleEndian.PutUint32(buffer[0:4], crc)
@@ -87,12 +140,39 @@ func Decode(data []byte, headerSize uint32) (*Record, error) {
 	copy(Key, data[headerSize:headerSize+Keysize])
 	copy(Value, data[headerSize+Keysize:headerSize+Keysize+Valuesize])
 
+	// Split the sequence number off the value of sequenced records
+	Seq := uint64(0)
+	if Flag&flagSequenced != 0 {
+		if Valuesize < SeqSize {
+			return nil, fmt.Errorf("sequenced record too short: value size %d, need at least %d bytes for sequence number",
+				Valuesize, SeqSize)
+		}
+		Flag &^= flagSequenced
+		Seq = binary.LittleEndian.Uint64(Value[:SeqSize])
+		Value = Value[SeqSize:]
+		Valuesize -= SeqSize
+	}
+
+	// Split the expiry time off the value of expiring records
+	Expiry := uint64(0)
+	if Flag == FlagExpiring {
+		if Valuesize < ExpirySize {
+			return nil, fmt.Errorf("expiring record too short: value size %d, need at least %d bytes for expiry",
+				Valuesize, ExpirySize)
+		}
+		Expiry = binary.LittleEndian.Uint64(Value[:ExpirySize])
+		Value = Value[ExpirySize:]
+		Valuesize -= ExpirySize
+	}
+
 	record := &Record{
 		CRC:       CRC,
 		Timestamp: Timestamp,
 		Keysize:   Keysize,
 		Valuesize: Valuesize,
 		Flag:      Flag,
+		Seq:       Seq,
+		Expiry:    Expiry,
 		Key:       Key,
 		Value:     Value,
 	}
@@ -113,3 +193,52 @@ func Decode(data []byte, headerSize uint32) (*Record, error) {
 
 	return record, nil
 }
+
+// NewCommitRecord returns the commit marker that terminates a run of count
+// records whose encoded bytes have the given CRC32 checksum. seq is the
+// highest sequence number assigned when the marker is written, which
+// recovery resumes from even if every record carrying it is gone.
+func NewCommitRecord(timestamp uint64, count uint32, checksum uint32, seq uint64) *Record {
+	value := make([]byte, CommitValueSize)
+	binary.LittleEndian.PutUint32(value[0:4], count)
+	binary.LittleEndian.PutUint32(value[4:8], checksum)
+	binary.LittleEndian.PutUint64(value[8:16], seq)
+
+	return &Record{
+		Timestamp: timestamp,
+		Keysize:   0,
+		Valuesize: CommitValueSize,
+		Flag:      FlagCommit,
+		Key:       []byte{},
+		Value:     value,
+	}
+}
+
+// CommitInfo returns the record count and checksum carried by a commit
+// record. ok is false for commit records written without them, which only
+// guarantee that the records before them were appended in full.
+func (r *Record) CommitInfo() (count uint32, checksum uint32, ok bool) {
+	if r.Flag != FlagCommit || (len(r.Value) != CommitValueSize && len(r.Value) != legacyCommitValueSize) {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint32(r.Value[0:4]), binary.LittleEndian.Uint32(r.Value[4:8]), true
+}
+
+// Time returns the wall-clock time the record was written. Records and
+// commit markers written since sequence numbers were introduced store it in
+// nanoseconds; older ones in whole seconds.
+func (r *Record) Time() time.Time {
+	if r.Seq != 0 || (r.Flag == FlagCommit && len(r.Value) == CommitValueSize) {
+		return time.Unix(0, int64(r.Timestamp))
+	}
+	return time.Unix(int64(r.Timestamp), 0)
+}
+
+// CommitSeq returns the sequence number carried by a commit record, or 0 if
+// it was written without one.
+func (r *Record) CommitSeq() uint64 {
+	if r.Flag != FlagCommit || len(r.Value) != CommitValueSize {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(r.Value[8:16])
+}
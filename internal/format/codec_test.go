@@ -3,6 +3,7 @@ package format
 
 import (
 	"testing"
+	"time"
 )
 
 const testHeaderSize = uint32(21)
@@ -235,3 +236,163 @@ func TestDecode_CRCValidation(t *testing.T) {
 		t.Error("Decode() should have failed with corrupted CRC")
 	}
 }
+
+func TestCommitRecord_RoundTrip(t *testing.T) {
+	setupTestConfig(t)
+
+	encoded, err := NewCommitRecord(1234567890, 3, 0xDEADBEEF, 42).Encode(testHeaderSize)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	decoded, err := Decode(encoded, testHeaderSize)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+
+	count, checksum, ok := decoded.CommitInfo()
+	if !ok {
+		t.Fatal("CommitInfo() ok = false, want true")
+	}
+	if count != 3 || checksum != 0xDEADBEEF {
+		t.Errorf("CommitInfo() = %d, %#x, want 3, 0xdeadbeef", count, checksum)
+	}
+	if seq := decoded.CommitSeq(); seq != 42 {
+		t.Errorf("CommitSeq() = %d, want 42", seq)
+	}
+
+	// Commit records written before sequence numbers still commit their batch
+	older := &Record{Flag: FlagCommit, Key: []byte{}, Value: decoded.Value[:legacyCommitValueSize]}
+	if count, _, ok := older.CommitInfo(); !ok || count != 3 {
+		t.Errorf("CommitInfo() without sequence number = %d, %v, want 3, true", count, ok)
+	}
+	if seq := older.CommitSeq(); seq != 0 {
+		t.Errorf("CommitSeq() without sequence number = %d, want 0", seq)
+	}
+
+	// Commit records without a payload carry no batch information
+	legacy := &Record{Flag: FlagCommit, Key: []byte{}}
+	if _, _, ok := legacy.CommitInfo(); ok {
+		t.Error("CommitInfo() ok = true for a commit record without payload")
+	}
+}
+
+func TestRecord_ExpiringRoundTrip(t *testing.T) {
+	setupTestConfig(t)
+
+	record := &Record{
+		Timestamp: 1234567890,
+		Keysize:   3,
+		Valuesize: 5,
+		Flag:      FlagExpiring,
+		Expiry:    1234567999000,
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+	}
+
+	encoded, err := record.Encode(testHeaderSize)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if want := int(testHeaderSize) + 3 + ExpirySize + 5; len(encoded) != want {
+		t.Errorf("Encode() length = %d, want %d", len(encoded), want)
+	}
+
+	decoded, err := Decode(encoded, testHeaderSize)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if decoded.Expiry != record.Expiry {
+		t.Errorf("Expiry = %v, want %v", decoded.Expiry, record.Expiry)
+	}
+	if decoded.Valuesize != record.Valuesize || string(decoded.Value) != "value" {
+		t.Errorf("Value = %q (size %d), want %q (size %d)",
+			decoded.Value, decoded.Valuesize, record.Value, record.Valuesize)
+	}
+}
+
+func TestRecord_SequencedRoundTrip(t *testing.T) {
+	setupTestConfig(t)
+
+	tests := []struct {
+		name   string
+		record *Record
+	}{
+		{
+			name: "normal record",
+			record: &Record{
+				Timestamp: 1234567890,
+				Keysize:   3,
+				Valuesize: 5,
+				Flag:      FlagNormal,
+				Seq:       7,
+				Key:       []byte("key"),
+				Value:     []byte("value"),
+			},
+		},
+		{
+			name: "expiring record",
+			record: &Record{
+				Timestamp: 1234567890,
+				Keysize:   3,
+				Valuesize: 5,
+				Flag:      FlagExpiring,
+				Seq:       1 << 40,
+				Expiry:    1234567999000,
+				Key:       []byte("key"),
+				Value:     []byte("value"),
+			},
+		},
+		{
+			name: "tombstone record",
+			record: &Record{
+				Timestamp: 1234567890,
+				Keysize:   3,
+				Flag:      FlagTombstone,
+				Seq:       9,
+				Key:       []byte("key"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.record.Encode(testHeaderSize)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+
+			decoded, err := Decode(encoded, testHeaderSize)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if decoded.Seq != tt.record.Seq || decoded.Flag != tt.record.Flag || decoded.Expiry != tt.record.Expiry {
+				t.Errorf("Decode() seq, flag, expiry = %d, %d, %d, want %d, %d, %d",
+					decoded.Seq, decoded.Flag, decoded.Expiry, tt.record.Seq, tt.record.Flag, tt.record.Expiry)
+			}
+			if decoded.Valuesize != tt.record.Valuesize || string(decoded.Value) != string(tt.record.Value) {
+				t.Errorf("Value = %q (size %d), want %q (size %d)",
+					decoded.Value, decoded.Valuesize, tt.record.Value, tt.record.Valuesize)
+			}
+		})
+	}
+}
+
+func TestRecord_Time(t *testing.T) {
+	setupTestConfig(t)
+
+	older := &Record{Timestamp: 1234567890, Flag: FlagNormal}
+	if got := older.Time(); !got.Equal(time.Unix(1234567890, 0)) {
+		t.Errorf("Time() without sequence number = %v, want whole seconds", got)
+	}
+
+	sequenced := &Record{Timestamp: 1234567890123456789, Flag: FlagNormal, Seq: 1}
+	if got := sequenced.Time(); !got.Equal(time.Unix(0, 1234567890123456789)) {
+		t.Errorf("Time() with sequence number = %v, want nanoseconds", got)
+	}
+
+	commit := NewCommitRecord(1234567890123456789, 1, 0, 0)
+	if got := commit.Time(); !got.Equal(time.Unix(0, 1234567890123456789)) {
+		t.Errorf("Time() of commit record = %v, want nanoseconds", got)
+	}
+}
@@ -0,0 +1,148 @@
+package format
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// HintHeaderSize is the size in bytes of the fixed part of an encoded hint entry.
+const HintHeaderSize = 45
+
+// hintTrailerSize is the size in bytes of the trailer closing a hint file:
+// entry count (uint32), segment size (int64), highest sequence number
+// (uint64), format magic (uint32) and CRC32 of everything before it.
+const hintTrailerSize = 28
+
+// hintMagic identifies the current hint file layout. Hint files written
+// before entries carried sequence numbers lack it and are rejected, so
+// recovery rebuilds them from their segments.
+const hintMagic uint32 = 0x48494e54
+
+// SegmentInfo describes the log segment a hint file was built from.
+type SegmentInfo struct {
+	Size   int64  // Size of the segment in bytes
+	MaxSeq uint64 // Highest sequence number recorded in the segment, including by commit markers
+}
+
+// Hint describes one committed record of a log segment without its value.
+// A segment's hint file lists its records in log order, so replaying the
+// hints rebuilds the key directory exactly like scanning the segment would.
+type Hint struct {
+	Timestamp uint64 // Timestamp copied from the record
+	FileId    uint32 // Identifier of the segment holding the record
+	Keysize   uint32 // Size of the key in bytes
+	Size      uint32 // Total size of the record (header + key + value)
+	Offset    int64  // Byte offset where the record starts in the segment
+	Expiry    uint64 // Unix time in milliseconds at which the record expires (0 never expires)
+	Seq       uint64 // Sequence number copied from the record (0 if it has none)
+	Flag      uint8  // Record type flag (normal, tombstone or expiring)
+	Key       []byte // The key bytes
+}
+
+// EncodeHints serializes the hints of a segment into the contents of a hint
+// file. Each entry has the following format:
+// [0:8]   - Timestamp (uint64, little-endian)
+// [8:12]  - File id (uint32, little-endian)
+// [12:16] - Key size (uint32, little-endian)
+// [16:20] - Record size (uint32, little-endian)
+// [20:28] - Offset (int64, little-endian)
+// [28:36] - Expiry (uint64, little-endian)
+// [36:44] - Sequence number (uint64, little-endian)
+// [44:45] - Flag (uint8)
+// [45:]   - Key bytes
+// The entries are followed by the entry count, the size and highest sequence
+// number of the segment the hints were built from, the format magic and a
+// CRC32 over the whole file.
+func EncodeHints(hints []*Hint, segment SegmentInfo) []byte {
+	size := hintTrailerSize
+	for _, h := range hints {
+		size += HintHeaderSize + len(h.Key)
+	}
+
+	buffer := make([]byte, size)
+	pos := 0
+	for _, h := range hints {
+		binary.LittleEndian.PutUint64(buffer[pos:pos+8], h.Timestamp)
+		binary.LittleEndian.PutUint32(buffer[pos+8:pos+12], h.FileId)
+		binary.LittleEndian.PutUint32(buffer[pos+12:pos+16], h.Keysize)
+		binary.LittleEndian.PutUint32(buffer[pos+16:pos+20], h.Size)
+		binary.LittleEndian.PutUint64(buffer[pos+20:pos+28], uint64(h.Offset))
+		binary.LittleEndian.PutUint64(buffer[pos+28:pos+36], h.Expiry)
+		binary.LittleEndian.PutUint64(buffer[pos+36:pos+44], h.Seq)
+		buffer[pos+44] = h.Flag
+		copy(buffer[pos+HintHeaderSize:], h.Key)
+		pos += HintHeaderSize + len(h.Key)
+	}
+
+	binary.LittleEndian.PutUint32(buffer[pos:pos+4], uint32(len(hints)))
+	binary.LittleEndian.PutUint64(buffer[pos+4:pos+12], uint64(segment.Size))
+	binary.LittleEndian.PutUint64(buffer[pos+12:pos+20], segment.MaxSeq)
+	binary.LittleEndian.PutUint32(buffer[pos+20:pos+24], hintMagic)
+	crc := crc32.ChecksumIEEE(buffer[:pos+24])
+	binary.LittleEndian.PutUint32(buffer[pos+24:pos+28], crc)
+
+	return buffer
+}
+
+// DecodeHints deserializes the contents of a hint file. It verifies the
+// trailing CRC checksum, format magic and entry count, and returns the hints
+// together with a description of the segment they were built from. Returns
+// an error if the data is truncated, corrupted or of an older format.
+func DecodeHints(data []byte) ([]*Hint, SegmentInfo, error) {
+	if len(data) < hintTrailerSize {
+		return nil, SegmentInfo{}, fmt.Errorf("hint data too short: got %d bytes, need at least %d bytes for trailer",
+			len(data), hintTrailerSize)
+	}
+
+	end := len(data) - hintTrailerSize
+	count := binary.LittleEndian.Uint32(data[end : end+4])
+	segment := SegmentInfo{
+		Size:   int64(binary.LittleEndian.Uint64(data[end+4 : end+12])),
+		MaxSeq: binary.LittleEndian.Uint64(data[end+12 : end+20]),
+	}
+	magic := binary.LittleEndian.Uint32(data[end+20 : end+24])
+	CRC := binary.LittleEndian.Uint32(data[end+24 : end+28])
+
+	calculatedCRC := crc32.ChecksumIEEE(data[:end+24])
+	if calculatedCRC != CRC {
+		return nil, SegmentInfo{}, fmt.Errorf("hint CRC mismatch: calculated %d, expected %d (data corruption detected)",
+			calculatedCRC, CRC)
+	}
+	if magic != hintMagic {
+		return nil, SegmentInfo{}, fmt.Errorf("unsupported hint format: magic %#x, expected %#x", magic, hintMagic)
+	}
+
+	hints := make([]*Hint, 0, count)
+	pos := 0
+	for pos < end {
+		if end-pos < HintHeaderSize {
+			return nil, SegmentInfo{}, fmt.Errorf("hint entry at %d truncated", pos)
+		}
+		keysize := binary.LittleEndian.Uint32(data[pos+12 : pos+16])
+		if end-pos-HintHeaderSize < int(keysize) {
+			return nil, SegmentInfo{}, fmt.Errorf("hint entry at %d truncated: need %d key bytes", pos, keysize)
+		}
+
+		key := make([]byte, keysize)
+		copy(key, data[pos+HintHeaderSize:pos+HintHeaderSize+int(keysize)])
+		hints = append(hints, &Hint{
+			Timestamp: binary.LittleEndian.Uint64(data[pos : pos+8]),
+			FileId:    binary.LittleEndian.Uint32(data[pos+8 : pos+12]),
+			Keysize:   keysize,
+			Size:      binary.LittleEndian.Uint32(data[pos+16 : pos+20]),
+			Offset:    int64(binary.LittleEndian.Uint64(data[pos+20 : pos+28])),
+			Expiry:    binary.LittleEndian.Uint64(data[pos+28 : pos+36]),
+			Seq:       binary.LittleEndian.Uint64(data[pos+36 : pos+44]),
+			Flag:      data[pos+44],
+			Key:       key,
+		})
+		pos += HintHeaderSize + int(keysize)
+	}
+
+	if uint32(len(hints)) != count {
+		return nil, SegmentInfo{}, fmt.Errorf("hint count mismatch: decoded %d entries, expected %d", len(hints), count)
+	}
+
+	return hints, segment, nil
+}
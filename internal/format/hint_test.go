@@ -0,0 +1,115 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func TestHints_EncodeDecode(t *testing.T) {
+	hints := []*Hint{
+		{
+			Timestamp: 1234567890,
+			FileId:    3,
+			Keysize:   3,
+			Size:      29,
+			Offset:    0,
+			Seq:       5,
+			Flag:      FlagNormal,
+			Key:       []byte("key"),
+		},
+		{
+			Timestamp: 1234567891,
+			FileId:    3,
+			Keysize:   0,
+			Size:      26,
+			Offset:    50,
+			Expiry:    1234567999000,
+			Flag:      FlagExpiring,
+			Key:       []byte{},
+		},
+		{
+			Timestamp: 1234567892,
+			FileId:    3,
+			Keysize:   3,
+			Size:      24,
+			Offset:    97,
+			Flag:      FlagTombstone,
+			Key:       []byte("key"),
+		},
+	}
+
+	data := EncodeHints(hints, SegmentInfo{Size: 142, MaxSeq: 9})
+	decoded, segment, err := DecodeHints(data)
+	if err != nil {
+		t.Fatalf("DecodeHints() error = %v", err)
+	}
+	if segment.Size != 142 || segment.MaxSeq != 9 {
+		t.Errorf("DecodeHints() segment = %+v, want size 142 and max seq 9", segment)
+	}
+	if len(decoded) != len(hints) {
+		t.Fatalf("DecodeHints() returned %d hints, want %d", len(decoded), len(hints))
+	}
+	for i, want := range hints {
+		got := decoded[i]
+		if got.Timestamp != want.Timestamp || got.FileId != want.FileId ||
+			got.Keysize != want.Keysize || got.Size != want.Size ||
+			got.Offset != want.Offset || got.Expiry != want.Expiry || got.Seq != want.Seq || got.Flag != want.Flag ||
+			!bytes.Equal(got.Key, want.Key) {
+			t.Errorf("DecodeHints()[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestDecodeHints_Invalid(t *testing.T) {
+	valid := EncodeHints([]*Hint{{FileId: 1, Keysize: 3, Size: 30, Key: []byte("key")}}, SegmentInfo{Size: 60})
+
+	corrupted := append([]byte(nil), valid...)
+	corrupted[10] ^= 0xFF
+
+	// A file in the layout from before entries carried sequence numbers:
+	// valid checksum, but no magic in front of it
+	older := append([]byte(nil), valid[:len(valid)-hintTrailerSize+12]...)
+	older = binary.LittleEndian.AppendUint32(older, crc32.ChecksumIEEE(older))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "empty",
+			data: []byte{},
+		},
+		{
+			name: "truncated",
+			data: valid[:len(valid)-1],
+		},
+		{
+			name: "corrupted",
+			data: corrupted,
+		},
+		{
+			name: "older format",
+			data: older,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := DecodeHints(tt.data); err == nil {
+				t.Error("DecodeHints() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestHints_Empty(t *testing.T) {
+	decoded, segment, err := DecodeHints(EncodeHints(nil, SegmentInfo{}))
+	if err != nil {
+		t.Fatalf("DecodeHints() error = %v", err)
+	}
+	if len(decoded) != 0 || segment != (SegmentInfo{}) {
+		t.Errorf("DecodeHints() = %v, %+v, want no hints", decoded, segment)
+	}
+}
@@ -0,0 +1,333 @@
+// Package httpapi exposes the storage engine over HTTP. Values are sent and
+// returned as raw request and response bodies; listings, statistics and
+// errors are JSON. The Handler can be served on its own or mounted inside
+// another server, under a path prefix with http.StripPrefix. Key requests
+// run under the request's context, so a write still queued behind others
+// is abandoned when the client goes away.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/engine"
+	"github.com/jassi-singh/aether-kv/internal/index"
+)
+
+const (
+	// MaxValueSize is the largest value in bytes accepted by PUT.
+	MaxValueSize = 64 << 20
+	// defaultListLimit is the number of keys a listing returns when no limit is given.
+	defaultListLimit = 1000
+	// maxListLimit is the largest limit a listing accepts.
+	maxListLimit = 10000
+	// statusClientClosedRequest is the status logged for a request whose
+	// client went away before it completed, as nginx does.
+	statusClientClosedRequest = 499
+)
+
+// Handler serves the HTTP API:
+//
+//	GET    /v1/keys/{key}   value of key as the raw body
+//	PUT    /v1/keys/{key}   store the raw body under key, ?ttl=30s to expire it
+//	DELETE /v1/keys/{key}   delete key
+//	GET    /v1/keys         list keys, ?prefix=, ?after= and ?limit= to page
+//	GET    /healthz         health check, 503 while the log cannot be flushed
+//	GET    /stats           engine statistics
+//	POST   /admin/compact   merge sealed segments
+//	POST   /admin/backup    write a backup named {"name": ...} under BackupDir
+type Handler struct {
+	// BackupDir is the directory POST /admin/backup writes named backups
+	// under. Empty disables the endpoint. Set it before serving.
+	BackupDir string
+
+	engine engine.Engine
+	mux    *http.ServeMux
+}
+
+// NewHandler creates a handler that serves the API for the given engine.
+func NewHandler(e engine.Engine) *Handler {
+	h := &Handler{
+		engine: e,
+		mux:    http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /v1/keys/{key...}", h.handleGet)
+	h.mux.HandleFunc("PUT /v1/keys/{key...}", h.handlePut)
+	h.mux.HandleFunc("DELETE /v1/keys/{key...}", h.handleDelete)
+	h.mux.HandleFunc("GET /v1/keys", h.handleList)
+	h.mux.HandleFunc("GET /healthz", h.handleHealth)
+	h.mux.HandleFunc("GET /stats", h.handleStats)
+	h.mux.HandleFunc("POST /admin/compact", h.handleCompact)
+	h.mux.HandleFunc("POST /admin/backup", h.handleBackup)
+	return h
+}
+
+// ServeHTTP dispatches the request to its endpoint and logs the outcome.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	h.mux.ServeHTTP(rec, r)
+
+	slog.Info("http: request served",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", rec.status,
+		"duration", time.Since(start))
+}
+
+// handleGet writes the value of a key as the response body.
+func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "key cannot be empty")
+		return
+	}
+
+	value, err := h.engine.GetBytes(r.Context(), []byte(key))
+	if errors.Is(err, engine.ErrKeyNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.Write(value)
+}
+
+// handlePut stores the request body under a key. An optional ttl query
+// parameter, a Go duration such as 30s or 1h, makes the key expire.
+func (h *Handler) handlePut(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "key cannot be empty")
+		return
+	}
+
+	var ttl time.Duration
+	if raw := r.URL.Query().Get("ttl"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid ttl %q: must be a positive duration", raw))
+			return
+		}
+		ttl = parsed
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxValueSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("value exceeds %d bytes", MaxValueSize))
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("failed to read body: %v", err))
+		return
+	}
+
+	if err := h.engine.PutBytes(r.Context(), []byte(key), body, engine.PutOptions{TTL: ttl}); err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDelete deletes a key. Deleting a missing key succeeds.
+func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		writeError(w, http.StatusBadRequest, "key cannot be empty")
+		return
+	}
+
+	if err := h.engine.DeleteBytes(r.Context(), []byte(key)); err != nil {
+		writeError(w, errorStatus(err), err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listResponse is the body returned by a key listing.
+type listResponse struct {
+	Keys []string `json:"keys"`
+	Next string   `json:"next,omitempty"` // Pass as after to fetch the next page; empty on the last page
+}
+
+// handleList lists keys in sorted order. The prefix parameter restricts the
+// listing, after resumes it past a key and limit caps the page size.
+func (h *Handler) handleList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	limit := defaultListLimit
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxListLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit %q: must be between 1 and %d", raw, maxListLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	start := prefix
+	if after := query.Get("after"); after != "" && after+"\x00" > start {
+		// The smallest key sorting after the given one
+		start = after + "\x00"
+	}
+
+	it := h.engine.Scan(start, index.PrefixEnd(prefix))
+	defer it.Close()
+
+	resp := listResponse{Keys: make([]string, 0)}
+	for it.Next() {
+		if len(resp.Keys) == limit {
+			resp.Next = resp.Keys[len(resp.Keys)-1]
+			break
+		}
+		resp.Keys = append(resp.Keys, it.Key())
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleHealth reports whether the engine can persist writes. Returns 503
+// Service Unavailable while background flushes of the log are failing.
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := h.engine.Health(); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "unhealthy",
+			"error":  err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleStats returns the engine statistics.
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.engine.Stats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// handleCompact merges the sealed segments and waits for the merge to
+// finish. Returns 409 Conflict if a merge is already running or an open
+// snapshot pins the segments.
+func (h *Handler) handleCompact(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	err := h.engine.Merge()
+	if errors.Is(err, engine.ErrMergeInProgress) || errors.Is(err, engine.ErrSegmentsPinned) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":   "compacted",
+		"duration": time.Since(start).String(),
+	})
+}
+
+// backupRequest is the body of a backup request.
+type backupRequest struct {
+	Name string `json:"name"` // Name of the backup, a directory created under the handler's BackupDir
+}
+
+// handleBackup writes a backup of the running store to the directory of the
+// requested name under BackupDir and returns its manifest once it is
+// complete and verified. Clients only choose the name, never where on the
+// server's file system it goes. Returns 404 Not Found if backups are
+// disabled, 400 Bad Request for a name that is not a single path element
+// and 409 Conflict if the directory is not empty.
+func (h *Handler) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if h.BackupDir == "" {
+		writeError(w, http.StatusNotFound, "backups are disabled, set BACKUP_DIR to enable them")
+		return
+	}
+	var req backupRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid backup request: %v", err))
+		return
+	}
+	dir, err := engine.BackupPath(h.BackupDir, req.Name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	manifest, err := h.engine.Backup(dir)
+	if errors.Is(err, engine.ErrBackupDirNotEmpty) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := engine.VerifyBackup(dir); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, manifest)
+}
+
+// writeJSON writes v as a JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("http: failed to write response",
+			"error", err)
+	}
+}
+
+// errorStatus returns the status for an error from a key request: 400 for a
+// key over engine.MaxKeySize, 413 for a value over engine.MaxValueSize, 499
+// when the client went away and 503 when the request's deadline passed
+// first, else 500.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, engine.ErrKeyTooLarge):
+		return http.StatusBadRequest
+	case errors.Is(err, engine.ErrValueTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, context.Canceled):
+		return statusClientClosedRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// statusRecorder captures the status code written by a handler for logging.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before writing it.
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
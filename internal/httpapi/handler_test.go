@@ -0,0 +1,259 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/config"
+	"github.com/jassi-singh/aether-kv/internal/engine"
+)
+
+// newEngine creates a fresh engine, closed when the test ends.
+func newEngine(t *testing.T) *engine.KVEngine {
+	t.Helper()
+	cfg := &config.Config{
+		DATA_DIR:      t.TempDir(),
+		HEADER_SIZE:   21,
+		BATCH_SIZE:    4096,
+		SYNC_INTERVAL: 5,
+	}
+	kv, err := engine.NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	t.Cleanup(func() { kv.Close() })
+	return kv
+}
+
+// startServer serves a handler for a fresh engine on a localhost port.
+// Both are closed when the test ends.
+func startServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(NewHandler(newEngine(t)))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// request sends a request and returns the response status and body.
+func request(t *testing.T, method, target, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, target, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestHandler_Keys(t *testing.T) {
+	srv := startServer(t)
+
+	tests := []struct {
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{method: http.MethodGet, path: "/v1/keys/missing", wantStatus: http.StatusNotFound, wantBody: `{"error":"key not found"}` + "\n"},
+		{method: http.MethodPut, path: "/v1/keys/user/42/name", body: "John Doe", wantStatus: http.StatusNoContent},
+		{method: http.MethodGet, path: "/v1/keys/user/42/name", wantStatus: http.StatusOK, wantBody: "John Doe"},
+		{method: http.MethodPut, path: "/v1/keys/bin", body: "\x00\x01\xff", wantStatus: http.StatusNoContent},
+		{method: http.MethodGet, path: "/v1/keys/bin", wantStatus: http.StatusOK, wantBody: "\x00\x01\xff"},
+		{method: http.MethodPut, path: "/v1/keys/session?ttl=1h", body: "token", wantStatus: http.StatusNoContent},
+		{method: http.MethodPut, path: "/v1/keys/session?ttl=soon", body: "token", wantStatus: http.StatusBadRequest},
+		{method: http.MethodGet, path: "/v1/keys/session", wantStatus: http.StatusOK, wantBody: "token"},
+		{method: http.MethodDelete, path: "/v1/keys/session", wantStatus: http.StatusNoContent},
+		{method: http.MethodGet, path: "/v1/keys/session", wantStatus: http.StatusNotFound, wantBody: `{"error":"key not found"}` + "\n"},
+		{method: http.MethodPost, path: "/v1/keys/session", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		status, body := request(t, tt.method, srv.URL+tt.path, tt.body)
+		if status != tt.wantStatus {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.path, status, tt.wantStatus)
+		}
+		if tt.wantBody != "" && body != tt.wantBody {
+			t.Errorf("%s %s body = %q, want %q", tt.method, tt.path, body, tt.wantBody)
+		}
+	}
+}
+
+func TestHandler_KeyErrors(t *testing.T) {
+	srv := startServer(t)
+
+	longKey := "/v1/keys/" + strings.Repeat("k", engine.MaxKeySize+1)
+	if status, body := request(t, http.MethodPut, srv.URL+longKey, "v"); status != http.StatusBadRequest || !strings.Contains(body, "key too large") {
+		t.Errorf("PUT of a key over MaxKeySize = %d %s, want %d", status, body, http.StatusBadRequest)
+	}
+	if status, body := request(t, http.MethodDelete, srv.URL+longKey, ""); status != http.StatusBadRequest {
+		t.Errorf("DELETE of a key over MaxKeySize = %d %s, want %d", status, body, http.StatusBadRequest)
+	}
+
+	// Requests whose context is already done are never applied
+	h := NewHandler(newEngine(t))
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		name       string
+		method     string
+		ctx        context.Context
+		wantStatus int
+	}{
+		{name: "put canceled", method: http.MethodPut, ctx: canceled, wantStatus: statusClientClosedRequest},
+		{name: "delete canceled", method: http.MethodDelete, ctx: canceled, wantStatus: statusClientClosedRequest},
+		{name: "get canceled", method: http.MethodGet, ctx: canceled, wantStatus: statusClientClosedRequest},
+		{name: "put past deadline", method: http.MethodPut, ctx: expired, wantStatus: http.StatusServiceUnavailable},
+		{name: "delete past deadline", method: http.MethodDelete, ctx: expired, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/v1/keys/a", strings.NewReader("v")).WithContext(tt.ctx)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s /v1/keys/a status = %d %s, want %d", tt.method, rec.Code, rec.Body.String(), tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: fmt.Errorf("put: %w", engine.ErrKeyTooLarge), want: http.StatusBadRequest},
+		{err: fmt.Errorf("put: %w", engine.ErrValueTooLarge), want: http.StatusRequestEntityTooLarge},
+		{err: fmt.Errorf("put: %w", context.Canceled), want: statusClientClosedRequest},
+		{err: fmt.Errorf("put: %w", context.DeadlineExceeded), want: http.StatusServiceUnavailable},
+		{err: errors.New("disk full"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := errorStatus(tt.err); got != tt.want {
+			t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestHandler_List(t *testing.T) {
+	srv := startServer(t)
+
+	for i := 0; i < 5; i++ {
+		request(t, http.MethodPut, fmt.Sprintf("%s/v1/keys/user:%d", srv.URL, i), "v")
+		request(t, http.MethodPut, fmt.Sprintf("%s/v1/keys/order:%d", srv.URL, i), "v")
+	}
+
+	list := func(query url.Values) listResponse {
+		t.Helper()
+		status, body := request(t, http.MethodGet, srv.URL+"/v1/keys?"+query.Encode(), "")
+		if status != http.StatusOK {
+			t.Fatalf("GET /v1/keys?%s status = %d, body %s", query.Encode(), status, body)
+		}
+		var resp listResponse
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatalf("Failed to decode listing: %v", err)
+		}
+		return resp
+	}
+
+	first := list(url.Values{"prefix": {"user:"}, "limit": {"3"}})
+	if want := []string{"user:0", "user:1", "user:2"}; !reflect.DeepEqual(first.Keys, want) || first.Next != "user:2" {
+		t.Errorf("first page = %+v, want keys %v and next user:2", first, want)
+	}
+	second := list(url.Values{"prefix": {"user:"}, "limit": {"3"}, "after": {first.Next}})
+	if want := []string{"user:3", "user:4"}; !reflect.DeepEqual(second.Keys, want) || second.Next != "" {
+		t.Errorf("second page = %+v, want keys %v and no next", second, want)
+	}
+
+	if all := list(url.Values{}); len(all.Keys) != 10 {
+		t.Errorf("listing without prefix returned %d keys, want 10", len(all.Keys))
+	}
+
+	if status, _ := request(t, http.MethodGet, srv.URL+"/v1/keys?limit=0", ""); status != http.StatusBadRequest {
+		t.Errorf("listing with limit=0 status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestHandler_Admin(t *testing.T) {
+	srv := startServer(t)
+
+	if status, body := request(t, http.MethodGet, srv.URL+"/healthz", ""); status != http.StatusOK || !strings.Contains(body, `"ok"`) {
+		t.Errorf("GET /healthz = %d %s, want 200 ok", status, body)
+	}
+
+	request(t, http.MethodPut, srv.URL+"/v1/keys/a", "1")
+	request(t, http.MethodPut, srv.URL+"/v1/keys/a", "2")
+
+	status, body := request(t, http.MethodGet, srv.URL+"/stats", "")
+	var stats engine.Stats
+	if err := json.Unmarshal([]byte(body), &stats); status != http.StatusOK || err != nil {
+		t.Fatalf("GET /stats = %d %s, %v", status, body, err)
+	}
+	if stats.Keys != 1 || stats.DeadBytes == 0 {
+		t.Errorf("GET /stats = %+v, want 1 key and some dead bytes", stats)
+	}
+
+	if status, body := request(t, http.MethodPost, srv.URL+"/admin/compact", ""); status != http.StatusOK {
+		t.Errorf("POST /admin/compact = %d %s, want 200", status, body)
+	}
+	if status, _ := request(t, http.MethodGet, srv.URL+"/admin/compact", ""); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/compact status = %d, want %d", status, http.StatusMethodNotAllowed)
+	}
+
+	if status, _ := request(t, http.MethodPost, srv.URL+"/admin/backup", `{"name": "nightly"}`); status != http.StatusNotFound {
+		t.Errorf("POST /admin/backup without a backup directory status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestHandler_Backup(t *testing.T) {
+	root := t.TempDir()
+	h := NewHandler(newEngine(t))
+	h.BackupDir = root
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	request(t, http.MethodPut, srv.URL+"/v1/keys/a", "1")
+
+	status, body := request(t, http.MethodPost, srv.URL+"/admin/backup", `{"name": "nightly"}`)
+	var manifest engine.BackupManifest
+	if err := json.Unmarshal([]byte(body), &manifest); status != http.StatusOK || err != nil || manifest.LastSeq == 0 {
+		t.Fatalf("POST /admin/backup = %d %s, want 200 and a manifest", status, body)
+	}
+	if _, err := engine.VerifyBackup(filepath.Join(root, "nightly")); err != nil {
+		t.Errorf("VerifyBackup() of the served backup error = %v", err)
+	}
+	if status, body := request(t, http.MethodPost, srv.URL+"/admin/backup", `{"name": "nightly"}`); status != http.StatusConflict {
+		t.Errorf("POST /admin/backup into a non-empty directory = %d %s, want %d", status, body, http.StatusConflict)
+	}
+
+	// Only names under the backup directory are accepted
+	for _, name := range []string{"", filepath.Join(t.TempDir(), "abs"), "..", "../escape", "a/b"} {
+		req := fmt.Sprintf(`{"name": %q}`, name)
+		if status, _ := request(t, http.MethodPost, srv.URL+"/admin/backup", req); status != http.StatusBadRequest {
+			t.Errorf("POST /admin/backup %s status = %d, want %d", req, status, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package index
+
+import (
+	"encoding/binary"
+	"unsafe"
+)
+
+// slabSize is the size of the slabs keys are copied into. A key too large
+// for a slab gets a slab of its own.
+const slabSize = 1 << 20
+
+// minSlabSize is the size of an arena's first slab. Each further slab is
+// twice the size of the one before, up to slabSize, so a small index does
+// not hold a whole slab of unused space.
+const minSlabSize = 4 << 10
+
+// keyRef locates a key in an arena: the slab in the upper 32 bits and the
+// offset of the key's length prefix within it in the lower 32 bits.
+type keyRef uint64
+
+// arena stores keys back to back in large byte slabs, each prefixed with
+// its length as a uvarint and followed by a tail of up to 255 bytes the
+// index may keep with the key, prefixed with its length as a single byte.
+// Millions of keys then cost a handful of allocations instead of one each,
+// and the slabs hold no pointers for the garbage collector to scan. Space of
+// removed keys is only reclaimed by copying the live keys into a fresh
+// arena.
+type arena struct {
+	slabs [][]byte
+	cur   int   // Slab new keys are appended to, -1 before the first key
+	used  int64 // Bytes appended to slabs, including removed keys
+	live  int64 // Bytes held by keys not yet removed
+}
+
+// newArena creates an empty arena.
+func newArena() *arena {
+	return &arena{cur: -1}
+}
+
+// store copies key into the arena with an empty tail and returns its
+// location. It accepts the key as either a string or a byte slice so no
+// conversion is needed.
+func store[K string | []byte](a *arena, key K) keyRef {
+	return storeWithTail(a, key, nil)
+}
+
+// storeWithTail copies key followed by tail, which must be at most 255
+// bytes long, into the arena and returns its location.
+func storeWithTail[K string | []byte](a *arena, key K, tail []byte) keyRef {
+	n := entrySize(len(key), len(tail))
+
+	slab := a.cur
+	if n > slabSize {
+		a.slabs = append(a.slabs, make([]byte, 0, n))
+		slab = len(a.slabs) - 1
+	} else if slab < 0 || int64(len(a.slabs[slab]))+n > int64(cap(a.slabs[slab])) {
+		size := int64(minSlabSize)
+		if slab >= 0 {
+			size = min(2*int64(cap(a.slabs[slab])), slabSize)
+		}
+		a.slabs = append(a.slabs, make([]byte, 0, max(size, n)))
+		slab = len(a.slabs) - 1
+		a.cur = slab
+	}
+
+	offset := len(a.slabs[slab])
+	a.slabs[slab] = binary.AppendUvarint(a.slabs[slab], uint64(len(key)))
+	a.slabs[slab] = append(a.slabs[slab], key...)
+	a.slabs[slab] = append(a.slabs[slab], byte(len(tail)))
+	a.slabs[slab] = append(a.slabs[slab], tail...)
+	a.used += n
+	a.live += n
+	return keyRef(uint64(slab)<<32 | uint64(offset))
+}
+
+// bytes returns the key stored at ref. The slice aliases the arena and must
+// not be modified or retained.
+func (a *arena) bytes(ref keyRef) []byte {
+	slab := a.slabs[ref>>32]
+	offset := int(uint32(ref))
+	length, n := binary.Uvarint(slab[offset:])
+	start := offset + n
+	return slab[start : start+int(length)]
+}
+
+// string returns the key stored at ref without copying it. The bytes of a
+// stored key are never modified, not even once it is removed, so the string
+// stays valid; holding on to it keeps its whole slab from being collected.
+func (a *arena) string(ref keyRef) string {
+	b := a.bytes(ref)
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
+// tail returns the tail stored with the key at ref. The slice aliases the
+// arena: writing to it updates the tail in place, but it must not be
+// retained.
+func (a *arena) tail(ref keyRef) []byte {
+	slab := a.slabs[ref>>32]
+	offset := int(uint32(ref))
+	length, n := binary.Uvarint(slab[offset:])
+	start := offset + n + int(length)
+	return slab[start+1 : start+1+int(slab[start])]
+}
+
+// free marks the key at ref as removed.
+func (a *arena) free(ref keyRef) {
+	a.live -= entrySize(len(a.bytes(ref)), len(a.tail(ref)))
+}
+
+// wasteful reports whether removed keys take up more of the arena than live
+// ones, so it is worth copying the live keys into a fresh arena.
+func (a *arena) wasteful() bool {
+	dead := a.used - a.live
+	return dead > slabSize && dead > a.live
+}
+
+// size returns the memory allocated for the slabs.
+func (a *arena) size() int64 {
+	total := int64(0)
+	for _, slab := range a.slabs {
+		total += int64(cap(slab))
+	}
+	return total
+}
+
+// entrySize returns the bytes an arena entry takes for a key and tail of the
+// given lengths.
+func entrySize(keyLen, tailLen int) int64 {
+	return int64(uvarintSize(uint64(keyLen)) + keyLen + 1 + tailLen)
+}
+
+// uvarintSize returns the number of bytes x takes encoded as a uvarint.
+func uvarintSize(x uint64) int {
+	n := 1
+	for x >= 0x80 {
+		x >>= 7
+		n++
+	}
+	return n
+}
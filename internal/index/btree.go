@@ -0,0 +1,447 @@
+package index
+
+import (
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// DefaultDegree is the minimum degree used by NewBTree when given a degree
+// below 2. Every node except the root holds between degree-1 and
+// 2*degree-1 keys.
+const DefaultDegree = 32
+
+// item is a single key and its value stored in a B-tree node. The key bytes
+// live in the tree's arena, so an item holds no pointers unless V does.
+type item[V comparable] struct {
+	key   keyRef
+	value V
+}
+
+// node is a B-tree node. Leaves have no children; an inner node with n
+// items has n+1 children. Both slices are allocated at their full capacity
+// when the node is created and never grow past it.
+type node[V comparable] struct {
+	items    []item[V]
+	children []*node[V]
+}
+
+// BTree is an Index backed by an in-memory B-tree guarded by a
+// read-write mutex. Lookups and iteration share the lock; writes take it
+// exclusively. Keys are copied into an arena of large slabs rather than
+// kept as separate strings, which keeps the per-key overhead and the work
+// of the garbage collector low for trees with many millions of keys.
+type BTree[V comparable] struct {
+	mu     sync.RWMutex
+	root   *node[V]
+	keys   *arena // Bytes of every key in the tree
+	degree int
+	length int
+	leaves int // Leaf nodes, counted for Bytes
+	inner  int // Inner nodes, counted for Bytes
+}
+
+// NewBTree creates and returns an empty B-tree with the given minimum
+// degree. A degree below 2 selects DefaultDegree.
+func NewBTree[V comparable](degree int) *BTree[V] {
+	if degree < 2 {
+		degree = DefaultDegree
+	}
+	return &BTree[V]{degree: degree, keys: newArena()}
+}
+
+// Load returns the value stored under key, if any.
+func (t *BTree[V]) Load(key string) (V, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.lookup(key)
+}
+
+// Swap stores value under key and returns the previous value, if any.
+func (t *BTree[V]) Swap(key string, value V) (V, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.insert(key, value)
+}
+
+// LoadAndDelete removes key and returns its previous value, if any.
+func (t *BTree[V]) LoadAndDelete(key string) (V, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.remove(key)
+}
+
+// CompareAndSwap stores new under key only if key currently holds old.
+func (t *BTree[V]) CompareAndSwap(key string, old, new V) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, i, ok := t.find(key)
+	if !ok || n.items[i].value != old {
+		return false
+	}
+	n.items[i].value = new
+	return true
+}
+
+// CompareAndDelete removes key only if it currently holds old.
+func (t *BTree[V]) CompareAndDelete(key string, old V) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, i, ok := t.find(key)
+	if !ok || n.items[i].value != old {
+		return false
+	}
+	t.remove(key)
+	return true
+}
+
+// Len returns the number of keys in the tree.
+func (t *BTree[V]) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.length
+}
+
+// Ascend calls fn for every key in [start, end) in ascending order until fn
+// returns false. An empty end means no upper bound. The read lock is held
+// for the whole iteration, so fn must not modify the tree.
+func (t *BTree[V]) Ascend(start, end string, fn func(key string, value V) bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.root != nil {
+		t.ascend(t.root, start, end, func(it item[V]) bool {
+			return fn(t.keys.string(it.key), it.value)
+		})
+	}
+}
+
+// Bytes returns the approximate memory held by the tree: its nodes and the
+// arena holding its keys. Memory referenced by values is not included.
+func (t *BTree[V]) Bytes() int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var n node[V]
+	var it item[V]
+	leaf := int64(unsafe.Sizeof(n)) + int64(t.maxItems())*int64(unsafe.Sizeof(it))
+	inner := leaf + int64(t.maxItems()+1)*int64(unsafe.Sizeof(&n))
+	return int64(t.leaves)*leaf + int64(t.inner)*inner + t.keys.size()
+}
+
+// lookup returns the value stored under key. Caller must hold t.mu.
+func (t *BTree[V]) lookup(key string) (V, bool) {
+	n, i, ok := t.find(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return n.items[i].value, true
+}
+
+// find returns the node and item index holding key. Caller must hold t.mu.
+func (t *BTree[V]) find(key string) (*node[V], int, bool) {
+	n := t.root
+	for n != nil {
+		i, found := t.search(n, key)
+		if found {
+			return n, i, true
+		}
+		if n.leaf() {
+			break
+		}
+		n = n.children[i]
+	}
+	return nil, 0, false
+}
+
+// insert stores value under key, replacing any existing value in place.
+// Caller must hold t.mu for writing.
+func (t *BTree[V]) insert(key string, value V) (V, bool) {
+	if n, i, ok := t.find(key); ok {
+		prev := n.items[i].value
+		n.items[i].value = value
+		return prev, true
+	}
+
+	t.add(key, item[V]{key: store(t.keys, key), value: value})
+	var zero V
+	return zero, false
+}
+
+// add inserts it, whose key is stored in t.keys and not yet in the tree.
+// Full nodes are split on the way down, so the insert never has to walk
+// back up the tree. Caller must hold t.mu for writing.
+func (t *BTree[V]) add(key string, it item[V]) {
+	t.length++
+	if t.root == nil {
+		t.root = t.newNode(true)
+		t.root.items = append(t.root.items, it)
+		return
+	}
+
+	if len(t.root.items) == t.maxItems() {
+		root := t.newNode(false)
+		root.children = append(root.children, t.root)
+		t.splitChild(root, 0)
+		t.root = root
+	}
+
+	n := t.root
+	for {
+		i, _ := t.search(n, key)
+		if n.leaf() {
+			n.items = insertAt(n.items, i, it)
+			return
+		}
+		if len(n.children[i].items) == t.maxItems() {
+			t.splitChild(n, i)
+			if key > string(t.keys.bytes(n.items[i].key)) {
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// remove deletes key and returns its value. Caller must hold t.mu for writing.
+func (t *BTree[V]) remove(key string) (V, bool) {
+	if t.root == nil {
+		var zero V
+		return zero, false
+	}
+
+	removed, ok := t.removeFrom(t.root, key)
+	if ok {
+		t.length--
+		t.keys.free(removed.key)
+	}
+	// The root shrinks once its last item moved down into a merged child
+	if len(t.root.items) == 0 {
+		root := t.root
+		if root.leaf() {
+			t.root = nil
+		} else {
+			t.root = root.children[0]
+		}
+		t.dropNode(root)
+	}
+	if t.keys.wasteful() {
+		t.compactKeys()
+	}
+	return removed.value, ok
+}
+
+// compactKeys copies the keys still in the tree into a fresh arena,
+// releasing the space held by removed keys. Caller must hold t.mu for
+// writing.
+func (t *BTree[V]) compactKeys() {
+	keys := newArena()
+	var rekey func(n *node[V])
+	rekey = func(n *node[V]) {
+		for i := range n.items {
+			ref := n.items[i].key
+			n.items[i].key = storeWithTail(keys, t.keys.bytes(ref), t.keys.tail(ref))
+		}
+		for _, child := range n.children {
+			rekey(child)
+		}
+	}
+	if t.root != nil {
+		rekey(t.root)
+	}
+	t.keys = keys
+}
+
+// maxItems returns the number of items at which a node is full.
+func (t *BTree[V]) maxItems() int {
+	return 2*t.degree - 1
+}
+
+// minItems returns the fewest items a node other than the root may hold.
+func (t *BTree[V]) minItems() int {
+	return t.degree - 1
+}
+
+// newNode allocates an empty node with room for a full set of items and,
+// unless it is a leaf, children.
+func (t *BTree[V]) newNode(leaf bool) *node[V] {
+	n := &node[V]{items: make([]item[V], 0, t.maxItems())}
+	if leaf {
+		t.leaves++
+	} else {
+		n.children = make([]*node[V], 0, t.maxItems()+1)
+		t.inner++
+	}
+	return n
+}
+
+// dropNode accounts for a node that has been unlinked from the tree.
+func (t *BTree[V]) dropNode(n *node[V]) {
+	if n.leaf() {
+		t.leaves--
+	} else {
+		t.inner--
+	}
+}
+
+// leaf reports whether the node has no children.
+func (n *node[V]) leaf() bool {
+	return cap(n.children) == 0
+}
+
+// search returns the index of the first item of n whose key is not less
+// than key and whether that item holds key exactly.
+func (t *BTree[V]) search(n *node[V], key string) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return string(t.keys.bytes(n.items[i].key)) >= key
+	})
+	return i, i < len(n.items) && string(t.keys.bytes(n.items[i].key)) == key
+}
+
+// splitChild splits the full child of n at index i around its middle item,
+// which moves up into n.
+func (t *BTree[V]) splitChild(n *node[V], i int) {
+	child := n.children[i]
+	middle := child.items[t.degree-1]
+
+	right := t.newNode(child.leaf())
+	right.items = append(right.items, child.items[t.degree:]...)
+	if !child.leaf() {
+		right.children = append(right.children, child.children[t.degree:]...)
+		clear(child.children[t.degree:])
+		child.children = child.children[:t.degree]
+	}
+	clear(child.items[t.degree-1:])
+	child.items = child.items[:t.degree-1]
+
+	n.items = insertAt(n.items, i, middle)
+	n.children = insertAt(n.children, i+1, right)
+}
+
+// removeFrom deletes key from the subtree rooted at n. Every child is grown
+// to more than minItems items before descending into it, so removing from a
+// leaf never leaves it underfull.
+func (t *BTree[V]) removeFrom(n *node[V], key string) (item[V], bool) {
+	i, found := t.search(n, key)
+	if n.leaf() {
+		if !found {
+			return item[V]{}, false
+		}
+		removed := n.items[i]
+		n.items = removeAt(n.items, i)
+		return removed, true
+	}
+
+	if len(n.children[i].items) <= t.minItems() {
+		t.growChild(n, i)
+		return t.removeFrom(n, key)
+	}
+
+	if found {
+		// Replace the item with its predecessor, the largest key to its left
+		removed := n.items[i]
+		n.items[i] = t.removeMax(n.children[i])
+		return removed, true
+	}
+	return t.removeFrom(n.children[i], key)
+}
+
+// removeMax deletes and returns the largest item in the subtree rooted at n.
+func (t *BTree[V]) removeMax(n *node[V]) item[V] {
+	if n.leaf() {
+		last := n.items[len(n.items)-1]
+		n.items = removeAt(n.items, len(n.items)-1)
+		return last
+	}
+
+	i := len(n.items)
+	if len(n.children[i].items) <= t.minItems() {
+		t.growChild(n, i)
+		return t.removeMax(n)
+	}
+	return t.removeMax(n.children[i])
+}
+
+// growChild gives the child of n at index i an extra item by borrowing one
+// through n from a sibling with items to spare, or otherwise by merging it
+// with a sibling and the item separating them.
+func (t *BTree[V]) growChild(n *node[V], i int) {
+	minItems := t.minItems()
+	switch {
+	case i > 0 && len(n.children[i-1].items) > minItems:
+		child, left := n.children[i], n.children[i-1]
+		child.items = insertAt(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[len(left.items)-1]
+		left.items = removeAt(left.items, len(left.items)-1)
+		if !left.leaf() {
+			child.children = insertAt(child.children, 0, left.children[len(left.children)-1])
+			left.children = removeAt(left.children, len(left.children)-1)
+		}
+	case i < len(n.items) && len(n.children[i+1].items) > minItems:
+		child, right := n.children[i], n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = removeAt(right.items, 0)
+		if !right.leaf() {
+			child.children = append(child.children, right.children[0])
+			right.children = removeAt(right.children, 0)
+		}
+	default:
+		if i >= len(n.items) {
+			i--
+		}
+		child, right := n.children[i], n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		child.items = append(child.items, right.items...)
+		child.children = append(child.children, right.children...)
+		n.items = removeAt(n.items, i)
+		n.children = removeAt(n.children, i+1)
+		t.dropNode(right)
+	}
+}
+
+// ascend calls fn for the items with keys in [start, end) of the subtree
+// rooted at n in ascending order. Returns false once fn has asked to stop or
+// end is reached.
+func (t *BTree[V]) ascend(n *node[V], start, end string, fn func(it item[V]) bool) bool {
+	i, _ := t.search(n, start)
+	for ; i < len(n.items); i++ {
+		if !n.leaf() && !t.ascend(n.children[i], start, end, fn) {
+			return false
+		}
+		if end != "" && string(t.keys.bytes(n.items[i].key)) >= end {
+			return false
+		}
+		if !fn(n.items[i]) {
+			return false
+		}
+	}
+	if !n.leaf() {
+		return t.ascend(n.children[len(n.items)], start, end, fn)
+	}
+	return true
+}
+
+// insertAt inserts v into s at index i.
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+// removeAt removes the element at index i from s.
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
@@ -0,0 +1,183 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// collect returns the keys Ascend visits for [start, end).
+func collect[V comparable](t *BTree[V], start, end string) []string {
+	keys := make([]string, 0)
+	t.Ascend(start, end, func(key string, value V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func TestBTree_MatchesMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, degree := range []int{2, 3, DefaultDegree} {
+		t.Run(fmt.Sprintf("degree_%d", degree), func(t *testing.T) {
+			tree := NewBTree[int](degree)
+			want := make(map[string]int)
+
+			for i := 0; i < 20000; i++ {
+				key := fmt.Sprintf("key%04d", rng.Intn(2000))
+				switch rng.Intn(3) {
+				case 0, 1:
+					prev, loaded := tree.Swap(key, i)
+					wantPrev, wantLoaded := want[key]
+					if loaded != wantLoaded || prev != wantPrev {
+						t.Fatalf("Swap(%s) = %d, %v, want %d, %v", key, prev, loaded, wantPrev, wantLoaded)
+					}
+					want[key] = i
+				case 2:
+					prev, loaded := tree.LoadAndDelete(key)
+					wantPrev, wantLoaded := want[key]
+					if loaded != wantLoaded || prev != wantPrev {
+						t.Fatalf("LoadAndDelete(%s) = %d, %v, want %d, %v", key, prev, loaded, wantPrev, wantLoaded)
+					}
+					delete(want, key)
+				}
+			}
+
+			if tree.Len() != len(want) {
+				t.Fatalf("Len() = %d, want %d", tree.Len(), len(want))
+			}
+			wantKeys := make([]string, 0, len(want))
+			for key, value := range want {
+				wantKeys = append(wantKeys, key)
+				if got, ok := tree.Load(key); !ok || got != value {
+					t.Fatalf("Load(%s) = %d, %v, want %d, true", key, got, ok, value)
+				}
+			}
+			sort.Strings(wantKeys)
+			if got := collect(tree, "", ""); fmt.Sprint(got) != fmt.Sprint(wantKeys) {
+				t.Fatalf("Ascend() returned %d keys out of order, want %d", len(got), len(wantKeys))
+			}
+
+			for _, key := range wantKeys {
+				tree.LoadAndDelete(key)
+			}
+			if tree.Len() != 0 || len(collect(tree, "", "")) != 0 {
+				t.Errorf("tree not empty after deleting every key: Len() = %d", tree.Len())
+			}
+		})
+	}
+}
+
+func TestBTree_Ascend(t *testing.T) {
+	tree := NewBTree[int](2)
+	for i, key := range []string{"a", "b", "ba", "bb", "bz", "c", "d"} {
+		tree.Swap(key, i)
+	}
+
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		want  []string
+	}{
+		{name: "all", want: []string{"a", "b", "ba", "bb", "bz", "c", "d"}},
+		{name: "range", start: "b", end: "c", want: []string{"b", "ba", "bb", "bz"}},
+		{name: "start between keys", start: "bc", end: "d", want: []string{"bz", "c"}},
+		{name: "no upper bound", start: "c", want: []string{"c", "d"}},
+		{name: "prefix", start: "b", end: PrefixEnd("b"), want: []string{"b", "ba", "bb", "bz"}},
+		{name: "empty", start: "e", want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collect(tree, tt.start, tt.end); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Ascend(%q, %q) = %v, want %v", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+
+	stopped := make([]string, 0)
+	tree.Ascend("", "", func(key string, value int) bool {
+		stopped = append(stopped, key)
+		return len(stopped) < 3
+	})
+	if len(stopped) != 3 {
+		t.Errorf("Ascend() visited %d keys after fn returned false, want 3", len(stopped))
+	}
+}
+
+func TestBTree_CompareAndSwap(t *testing.T) {
+	tree := NewBTree[int](0)
+	tree.Swap("key", 1)
+
+	if tree.CompareAndSwap("key", 2, 3) {
+		t.Error("CompareAndSwap() with wrong old value succeeded")
+	}
+	if !tree.CompareAndSwap("key", 1, 3) {
+		t.Error("CompareAndSwap() with matching old value failed")
+	}
+	if tree.CompareAndDelete("key", 1) {
+		t.Error("CompareAndDelete() with wrong old value succeeded")
+	}
+	if !tree.CompareAndDelete("key", 3) {
+		t.Error("CompareAndDelete() with matching old value failed")
+	}
+	if _, ok := tree.Load("key"); ok {
+		t.Error("Load() found key after CompareAndDelete()")
+	}
+}
+
+func TestBTree_Bytes(t *testing.T) {
+	const n = 200000
+	tree := NewBTree[uint64](DefaultDegree)
+	if got := tree.Bytes(); got != 0 {
+		t.Errorf("Bytes() of empty tree = %d, want 0", got)
+	}
+
+	for i := 0; i < n; i++ {
+		tree.Swap(fmt.Sprintf("key%08d", i), uint64(i))
+	}
+	full := tree.Bytes()
+	if perKey := full / n; perKey > 64 {
+		t.Errorf("Bytes() = %d, %d bytes per key, want at most 64", full, perKey)
+	}
+
+	// Removing most keys leaves the arena mostly dead, so the live keys are
+	// copied into a fresh one
+	for i := 0; i < n; i++ {
+		if i%10 != 0 {
+			tree.LoadAndDelete(fmt.Sprintf("key%08d", i))
+		}
+	}
+	if got := tree.Bytes(); got >= full/2 {
+		t.Errorf("Bytes() after removing 90%% of keys = %d, want below %d", got, full/2)
+	}
+	for i := 0; i < n; i += 10 {
+		key := fmt.Sprintf("key%08d", i)
+		if value, ok := tree.Load(key); !ok || value != uint64(i) {
+			t.Fatalf("Load(%s) after compaction = %d, %v, want %d", key, value, ok, i)
+		}
+	}
+	if got := collect(tree, "", ""); len(got) != n/10 || got[0] != "key00000000" || got[len(got)-1] != "key00199990" {
+		t.Errorf("Ascend() after compaction returned %d keys from %q to %q", len(got), got[0], got[len(got)-1])
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "user:42:", want: "user:42;"},
+		{prefix: "a\xff", want: "b"},
+		{prefix: "\xff\xff", want: ""},
+		{prefix: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := PrefixEnd(tt.prefix); got != tt.want {
+			t.Errorf("PrefixEnd(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
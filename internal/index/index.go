@@ -0,0 +1,43 @@
+// Package index provides ordered in-memory indexes mapping string keys to
+// values. The engine uses an index as its key directory, so keys can be
+// looked up individually as well as iterated in sorted order.
+package index
+
+// Index is an ordered map from string keys to values that is safe for
+// concurrent use. Its keyed operations mirror those of sync.Map, so values
+// are compared with == by the compare-and-swap style methods.
+type Index[V comparable] interface {
+	// Load returns the value stored under key, if any.
+	Load(key string) (V, bool)
+	// Swap stores value under key and returns the previous value, if any.
+	Swap(key string, value V) (V, bool)
+	// LoadAndDelete removes key and returns its previous value, if any.
+	LoadAndDelete(key string) (V, bool)
+	// CompareAndSwap stores new under key only if key currently holds old.
+	CompareAndSwap(key string, old, new V) bool
+	// CompareAndDelete removes key only if it currently holds old.
+	CompareAndDelete(key string, old V) bool
+	// Len returns the number of keys in the index.
+	Len() int
+	// Ascend calls fn for every key in [start, end) in ascending order until
+	// fn returns false. An empty end means no upper bound. fn must not
+	// modify the index.
+	Ascend(start, end string, fn func(key string, value V) bool)
+	// Bytes returns the approximate memory held by the index, not counting
+	// memory referenced by values.
+	Bytes() int64
+}
+
+// PrefixEnd returns the smallest key greater than every key that starts
+// with prefix, for use as the end of an Ascend over that prefix. Returns an
+// empty string, meaning no upper bound, if no such key exists.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,301 @@
+package index
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"hash/maphash"
+)
+
+// DefaultShards is the number of shards used by NewLocations when given
+// fewer than one.
+const DefaultShards = 32
+
+// ascendBatchSize is the number of entries Locations.Ascend copies out of a
+// shard at a time.
+const ascendBatchSize = 256
+
+// Location is where the record a key maps to is stored, together with the
+// record's expiry and sequence number.
+type Location struct {
+	FileId uint32 // Identifier of the log segment holding the record
+	Size   uint32 // Total size of the record (header + key + value)
+	Offset int64  // Byte offset where the record starts in the log file
+	Expiry uint64 // Unix time in milliseconds at which the key expires (0 never expires)
+	Seq    uint64 // Sequence number of the record, the key's version
+}
+
+// Expired reports whether the location has expired at now, given in Unix
+// milliseconds.
+func (l Location) Expired(now uint64) bool {
+	return l.Expiry != 0 && now >= l.Expiry
+}
+
+// slot is the part of a Location kept in tree nodes: 16 bytes with no
+// pointers. The sequence number and expiry are kept in the arena after the
+// key, and only take space there when set.
+type slot struct {
+	fileId uint32
+	size   uint32
+	offset int64
+}
+
+// Locations is an Index of Locations partitioned by key hash into shards,
+// each a B-tree with its own read-write mutex, so writers of different keys
+// rarely wait for each other. Ascend merges the shards back into key order.
+type Locations struct {
+	shards []*BTree[slot]
+	seed   maphash.Seed
+}
+
+// NewLocations creates and returns an empty index with the given number of
+// shards, each a B-tree of the given minimum degree. A shard count below 1
+// selects DefaultShards.
+func NewLocations(shards, degree int) *Locations {
+	if shards < 1 {
+		shards = DefaultShards
+	}
+	l := &Locations{shards: make([]*BTree[slot], shards), seed: maphash.MakeSeed()}
+	for i := range l.shards {
+		l.shards[i] = NewBTree[slot](degree)
+	}
+	return l
+}
+
+// Load returns the location stored under key, if any.
+func (l *Locations) Load(key string) (Location, bool) {
+	t := l.shard(key)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	n, i, ok := t.find(key)
+	if !ok {
+		return Location{}, false
+	}
+	return locationOf(t.keys, n.items[i]), true
+}
+
+// Swap stores loc under key and returns the previous location, if any.
+func (l *Locations) Swap(key string, loc Location) (Location, bool) {
+	t := l.shard(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, i, ok := t.find(key)
+	if !ok {
+		var buf [16]byte
+		t.add(key, item[slot]{key: storeWithTail(t.keys, key, encodeTail(loc, &buf)), value: slotOf(loc)})
+		return Location{}, false
+	}
+	prev := locationOf(t.keys, n.items[i])
+	setLocation(t, &n.items[i], loc)
+	return prev, true
+}
+
+// LoadAndDelete removes key and returns its previous location, if any.
+func (l *Locations) LoadAndDelete(key string) (Location, bool) {
+	t := l.shard(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, i, ok := t.find(key)
+	if !ok {
+		return Location{}, false
+	}
+	prev := locationOf(t.keys, n.items[i])
+	t.remove(key)
+	return prev, true
+}
+
+// CompareAndSwap stores new under key only if key currently holds old.
+func (l *Locations) CompareAndSwap(key string, old, new Location) bool {
+	t := l.shard(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, i, ok := t.find(key)
+	if !ok || locationOf(t.keys, n.items[i]) != old {
+		return false
+	}
+	setLocation(t, &n.items[i], new)
+	return true
+}
+
+// CompareAndDelete removes key only if it currently holds old.
+func (l *Locations) CompareAndDelete(key string, old Location) bool {
+	t := l.shard(key)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, i, ok := t.find(key)
+	if !ok || locationOf(t.keys, n.items[i]) != old {
+		return false
+	}
+	t.remove(key)
+	return true
+}
+
+// Len returns the number of keys in the index.
+func (l *Locations) Len() int {
+	total := 0
+	for _, t := range l.shards {
+		total += t.Len()
+	}
+	return total
+}
+
+// Bytes returns the approximate memory held by the shards.
+func (l *Locations) Bytes() int64 {
+	total := int64(0)
+	for _, t := range l.shards {
+		total += t.Bytes()
+	}
+	return total
+}
+
+// Ascend calls fn for every key in [start, end) in ascending order until fn
+// returns false. An empty end means no upper bound. Each shard is read in
+// batches of ascendBatchSize under its read lock, which is not held while
+// fn runs, so writers are never blocked for a whole scan. A key written
+// during the scan may or may not be visited, but no key is visited twice.
+func (l *Locations) Ascend(start, end string, fn func(key string, loc Location) bool) {
+	cursors := make(cursorHeap, 0, len(l.shards))
+	for _, t := range l.shards {
+		c := &shardCursor{shard: t, start: start, end: end}
+		if c.fill() {
+			cursors = append(cursors, c)
+		}
+	}
+	heap.Init(&cursors)
+
+	for len(cursors) > 0 {
+		c := cursors[0]
+		e := c.batch[c.pos]
+		c.pos++
+		if c.pos < len(c.batch) || c.fill() {
+			heap.Fix(&cursors, 0)
+		} else {
+			heap.Pop(&cursors)
+		}
+		if !fn(e.key, e.loc) {
+			return
+		}
+	}
+}
+
+// shard returns the shard holding key.
+func (l *Locations) shard(key string) *BTree[slot] {
+	return l.shards[maphash.String(l.seed, key)%uint64(len(l.shards))]
+}
+
+// shardEntry is a key and location copied out of a shard by Ascend.
+type shardEntry struct {
+	key string
+	loc Location
+}
+
+// shardCursor walks one shard in [start, end) a batch at a time.
+type shardCursor struct {
+	shard *BTree[slot]
+	start string // First key of the next batch
+	end   string
+	batch []shardEntry
+	pos   int  // Index into batch of the next entry
+	done  bool // Set once the shard has no keys past the batch
+}
+
+// fill replaces the batch with the next entries of the shard. Returns false
+// if there are none.
+func (c *shardCursor) fill() bool {
+	c.batch = c.batch[:0]
+	c.pos = 0
+	if c.done {
+		return false
+	}
+
+	t := c.shard
+	t.mu.RLock()
+	if t.root != nil {
+		t.ascend(t.root, c.start, c.end, func(it item[slot]) bool {
+			c.batch = append(c.batch, shardEntry{key: t.keys.string(it.key), loc: locationOf(t.keys, it)})
+			return len(c.batch) < ascendBatchSize
+		})
+	}
+	t.mu.RUnlock()
+
+	if len(c.batch) < ascendBatchSize {
+		c.done = true
+	} else {
+		// The smallest key sorting after the last one
+		c.start = c.batch[len(c.batch)-1].key + "\x00"
+	}
+	return len(c.batch) > 0
+}
+
+// cursorHeap orders shard cursors by their next key.
+type cursorHeap []*shardCursor
+
+func (h cursorHeap) Len() int { return len(h) }
+func (h cursorHeap) Less(i, j int) bool {
+	return h[i].batch[h[i].pos].key < h[j].batch[h[j].pos].key
+}
+func (h cursorHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *cursorHeap) Push(x any)   { *h = append(*h, x.(*shardCursor)) }
+func (h *cursorHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// slotOf returns the part of loc kept in tree nodes.
+func slotOf(loc Location) slot {
+	return slot{fileId: loc.FileId, size: loc.Size, offset: loc.Offset}
+}
+
+// locationOf returns the location held by it, whose key is stored in keys.
+func locationOf(keys *arena, it item[slot]) Location {
+	loc := Location{FileId: it.value.fileId, Size: it.value.size, Offset: it.value.offset}
+	tail := keys.tail(it.key)
+	if len(tail) >= 8 {
+		loc.Seq = binary.LittleEndian.Uint64(tail[0:8])
+	}
+	if len(tail) == 16 {
+		loc.Expiry = binary.LittleEndian.Uint64(tail[8:16])
+	}
+	return loc
+}
+
+// encodeTail encodes the sequence number and expiry of loc into buf and
+// returns the part of it to store after the key: nothing if neither is
+// set, the sequence number alone if the key never expires, or both.
+func encodeTail(loc Location, buf *[16]byte) []byte {
+	if loc.Expiry != 0 {
+		binary.LittleEndian.PutUint64(buf[0:8], loc.Seq)
+		binary.LittleEndian.PutUint64(buf[8:16], loc.Expiry)
+		return buf[:16]
+	}
+	if loc.Seq != 0 {
+		binary.LittleEndian.PutUint64(buf[0:8], loc.Seq)
+		return buf[:8]
+	}
+	return buf[:0]
+}
+
+// setLocation stores loc in it, an item of t. The tail is rewritten in
+// place if its length is unchanged; otherwise the key is stored again with
+// the new tail. Caller must hold t.mu for writing.
+func setLocation(t *BTree[slot], it *item[slot], loc Location) {
+	var buf [16]byte
+	tail := encodeTail(loc, &buf)
+	if current := t.keys.tail(it.key); len(current) == len(tail) {
+		copy(current, tail)
+	} else {
+		ref := storeWithTail(t.keys, t.keys.bytes(it.key), tail)
+		t.keys.free(it.key)
+		it.key = ref
+		if t.keys.wasteful() {
+			t.compactKeys()
+		}
+	}
+	it.value = slotOf(loc)
+}
@@ -0,0 +1,210 @@
+package index
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// collectLocations returns the keys Ascend visits for [start, end).
+func collectLocations(l *Locations, start, end string) []string {
+	keys := make([]string, 0)
+	l.Ascend(start, end, func(key string, loc Location) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// randomLocation returns a location with the sequence number and expiry
+// each set or not at random.
+func randomLocation(rng *rand.Rand, i int) Location {
+	loc := Location{FileId: uint32(rng.Intn(8)), Size: uint32(i), Offset: int64(i) * 100}
+	if rng.Intn(4) != 0 {
+		loc.Seq = uint64(i)
+	}
+	if rng.Intn(3) == 0 {
+		loc.Expiry = uint64(i) + 1000
+	}
+	return loc
+}
+
+func TestLocations_MatchesMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, shards := range []int{1, 3, DefaultShards} {
+		t.Run(fmt.Sprintf("shards_%d", shards), func(t *testing.T) {
+			l := NewLocations(shards, 2)
+			want := make(map[string]Location)
+
+			for i := 1; i <= 20000; i++ {
+				key := fmt.Sprintf("key%04d", rng.Intn(2000))
+				switch rng.Intn(4) {
+				case 0, 1:
+					loc := randomLocation(rng, i)
+					prev, loaded := l.Swap(key, loc)
+					wantPrev, wantLoaded := want[key]
+					if loaded != wantLoaded || prev != wantPrev {
+						t.Fatalf("Swap(%s) = %+v, %v, want %+v, %v", key, prev, loaded, wantPrev, wantLoaded)
+					}
+					want[key] = loc
+				case 2:
+					loc := randomLocation(rng, i)
+					old, ok := want[key]
+					if rng.Intn(2) == 0 {
+						old.Size++
+					}
+					swapped := l.CompareAndSwap(key, old, loc)
+					if wantSwapped := ok && old == want[key]; swapped != wantSwapped {
+						t.Fatalf("CompareAndSwap(%s) = %v, want %v", key, swapped, wantSwapped)
+					}
+					if swapped {
+						want[key] = loc
+					}
+				case 3:
+					prev, loaded := l.LoadAndDelete(key)
+					wantPrev, wantLoaded := want[key]
+					if loaded != wantLoaded || prev != wantPrev {
+						t.Fatalf("LoadAndDelete(%s) = %+v, %v, want %+v, %v", key, prev, loaded, wantPrev, wantLoaded)
+					}
+					delete(want, key)
+				}
+			}
+
+			if l.Len() != len(want) {
+				t.Fatalf("Len() = %d, want %d", l.Len(), len(want))
+			}
+			wantKeys := make([]string, 0, len(want))
+			for key, loc := range want {
+				wantKeys = append(wantKeys, key)
+				if got, ok := l.Load(key); !ok || got != loc {
+					t.Fatalf("Load(%s) = %+v, %v, want %+v, true", key, got, ok, loc)
+				}
+			}
+			sort.Strings(wantKeys)
+			if got := collectLocations(l, "", ""); fmt.Sprint(got) != fmt.Sprint(wantKeys) {
+				t.Fatalf("Ascend() returned %d keys out of order, want %d", len(got), len(wantKeys))
+			}
+			l.Ascend("", "", func(key string, loc Location) bool {
+				if loc != want[key] {
+					t.Fatalf("Ascend() visited %s with %+v, want %+v", key, loc, want[key])
+				}
+				return true
+			})
+		})
+	}
+}
+
+func TestLocations_Ascend(t *testing.T) {
+	l := NewLocations(4, 2)
+	for i := 0; i < 1000; i++ {
+		l.Swap(fmt.Sprintf("key%04d", i), Location{Seq: uint64(i + 1)})
+	}
+
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		want  int
+	}{
+		{name: "all", want: 1000},
+		{name: "range", start: "key0100", end: "key0600", want: 500},
+		{name: "prefix", start: "key09", end: PrefixEnd("key09"), want: 100},
+		{name: "empty", start: "z", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collectLocations(l, tt.start, tt.end)
+			if len(got) != tt.want || !sort.StringsAreSorted(got) {
+				t.Errorf("Ascend(%q, %q) visited %d keys, sorted %v, want %d sorted", tt.start, tt.end, len(got), sort.StringsAreSorted(got), tt.want)
+			}
+		})
+	}
+
+	stopped := 0
+	l.Ascend("", "", func(string, Location) bool {
+		stopped++
+		return stopped < 300
+	})
+	if stopped != 300 {
+		t.Errorf("Ascend() visited %d keys after fn returned false, want 300", stopped)
+	}
+}
+
+func TestLocations_ConcurrentWrites(t *testing.T) {
+	l := NewLocations(0, 0)
+	const writers, keys = 8, 2000
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprintf("w%d:%04d", w, i)
+				l.Swap(key, Location{Seq: uint64(i + 1)})
+				if i%3 == 0 {
+					l.CompareAndSwap(key, Location{Seq: uint64(i + 1)}, Location{Seq: uint64(i + 1), Expiry: 1})
+				}
+			}
+		}(w)
+	}
+	// Scans run alongside the writers and always see keys in order
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if got := collectLocations(l, "", ""); !sort.StringsAreSorted(got) {
+				t.Errorf("Ascend() during writes returned keys out of order")
+			}
+		}
+	}()
+	wg.Wait()
+
+	if got := l.Len(); got != writers*keys {
+		t.Errorf("Len() = %d, want %d", got, writers*keys)
+	}
+	if got, ok := l.Load("w3:0999"); !ok || got != (Location{Seq: 1000, Expiry: 1}) {
+		t.Errorf("Load(w3:0999) = %+v, %v, want expiring sequence 1000", got, ok)
+	}
+}
+
+func TestLocations_Bytes(t *testing.T) {
+	const n = 200000
+	// One shard, so the slack of partly filled arena slabs per shard does
+	// not hide the packing
+	l := NewLocations(1, DefaultDegree)
+	wide := NewBTree[Location](DefaultDegree)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		key := fmt.Sprintf("key%08d", i)
+		loc := Location{FileId: 1, Size: 40, Offset: int64(i) * 40, Seq: uint64(i + 1)}
+		l.Swap(key, loc)
+		wide.Swap(key, loc)
+	}
+
+	// Keeping the version with the key and no expiry is smaller than
+	// storing whole locations in the nodes
+	if got, full := l.Bytes(), wide.Bytes(); got >= full {
+		t.Errorf("Bytes() = %d, want below %d for whole locations in the nodes", got, full)
+	}
+
+	// Giving keys an expiry moves them in the arena; the space left behind
+	// is reclaimed once it outweighs the live keys
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%08d", i)
+		old, _ := l.Load(key)
+		loc := old
+		loc.Expiry = 1
+		if !l.CompareAndSwap(key, old, loc) {
+			t.Fatalf("CompareAndSwap(%s) failed", key)
+		}
+	}
+	for i := 0; i < n; i += 1000 {
+		key := fmt.Sprintf("key%08d", i)
+		want := Location{FileId: 1, Size: 40, Offset: int64(i) * 40, Seq: uint64(i + 1), Expiry: 1}
+		if got, ok := l.Load(key); !ok || got != want {
+			t.Fatalf("Load(%s) = %+v, %v, want %+v", key, got, ok, want)
+		}
+	}
+}
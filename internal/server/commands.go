@@ -0,0 +1,603 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/engine"
+	"github.com/jassi-singh/aether-kv/internal/index"
+)
+
+const (
+	// serverName and serverVersion are reported by HELLO and INFO.
+	serverName    = "aether-kv"
+	serverVersion = "0.1.0"
+	// defaultScanCount is the number of keys SCAN visits when no COUNT is given.
+	defaultScanCount = 10
+	// maxCursors limits the SCAN cursors a connection keeps open; the oldest
+	// is forgotten once a new one would exceed it.
+	maxCursors = 64
+)
+
+// command describes how to execute a command and how many arguments it takes.
+type command struct {
+	handler func(s *Server, c *conn, args []string) bool // Returns true to close the connection
+	arity   int                                          // Argument count including the name, negative for at least -arity
+}
+
+// commands maps lowercase command names to their implementation.
+var commands = map[string]command{
+	"ping":    {handler: cmdPing, arity: -1},
+	"echo":    {handler: cmdEcho, arity: 2},
+	"hello":   {handler: cmdHello, arity: -1},
+	"quit":    {handler: cmdQuit, arity: -1},
+	"select":  {handler: cmdSelect, arity: 2},
+	"client":  {handler: cmdClient, arity: -2},
+	"command": {handler: cmdCommand, arity: -1},
+	"info":    {handler: cmdInfo, arity: -1},
+	"dbsize":  {handler: cmdDbSize, arity: 1},
+	"get":     {handler: cmdGet, arity: 2},
+	"set":     {handler: cmdSet, arity: -3},
+	"del":     {handler: cmdDel, arity: -2},
+	"exists":  {handler: cmdExists, arity: -2},
+	"mget":    {handler: cmdMGet, arity: -2},
+	"mset":    {handler: cmdMSet, arity: -3},
+	"scan":    {handler: cmdScan, arity: -2},
+	"ttl":     {handler: cmdTTL, arity: 2},
+	"pttl":    {handler: cmdTTL, arity: 2},
+	"expire":  {handler: cmdExpire, arity: 3},
+	"pexpire": {handler: cmdExpire, arity: 3},
+	"backup":  {handler: cmdBackup, arity: 2},
+}
+
+// cmdPing replies PONG, or echoes its argument.
+func cmdPing(s *Server, c *conn, args []string) bool {
+	switch len(args) {
+	case 1:
+		c.w.writeSimple("PONG")
+	case 2:
+		c.w.writeBulk(args[1])
+	default:
+		c.w.writeError("ERR wrong number of arguments for 'ping' command")
+	}
+	return false
+}
+
+// cmdEcho replies with its argument.
+func cmdEcho(s *Server, c *conn, args []string) bool {
+	c.w.writeBulk(args[1])
+	return false
+}
+
+// cmdHello negotiates the protocol version and replies with server details:
+// HELLO [protover [SETNAME name]].
+func cmdHello(s *Server, c *conn, args []string) bool {
+	proto := c.w.proto
+	if len(args) > 1 {
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			c.w.writeError("ERR Protocol version is not an integer or out of range")
+			return false
+		}
+		if version != 2 && version != 3 {
+			c.w.writeError("NOPROTO unsupported protocol version")
+			return false
+		}
+		proto = version
+	}
+
+	name := c.name
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "setname":
+			if i+1 >= len(args) {
+				c.w.writeError("ERR syntax error")
+				return false
+			}
+			name = args[i+1]
+			i++
+		case "auth":
+			c.w.writeError("ERR AUTH is not supported")
+			return false
+		default:
+			c.w.writeError("ERR syntax error")
+			return false
+		}
+	}
+
+	c.w.proto = proto
+	c.name = name
+
+	c.w.writeMap(7)
+	c.w.writeBulk("server")
+	c.w.writeBulk(serverName)
+	c.w.writeBulk("version")
+	c.w.writeBulk(serverVersion)
+	c.w.writeBulk("proto")
+	c.w.writeInt(int64(proto))
+	c.w.writeBulk("id")
+	c.w.writeInt(c.id)
+	c.w.writeBulk("mode")
+	c.w.writeBulk("standalone")
+	c.w.writeBulk("role")
+	c.w.writeBulk("master")
+	c.w.writeBulk("modules")
+	c.w.writeArray(0)
+	return false
+}
+
+// cmdQuit replies OK and closes the connection.
+func cmdQuit(s *Server, c *conn, args []string) bool {
+	c.w.writeSimple("OK")
+	return true
+}
+
+// cmdSelect accepts only database 0, the single keyspace of the engine.
+func cmdSelect(s *Server, c *conn, args []string) bool {
+	if args[1] != "0" {
+		c.w.writeError("ERR DB index is out of range")
+		return false
+	}
+	c.w.writeSimple("OK")
+	return false
+}
+
+// cmdClient implements the CLIENT subcommands clients send on connect.
+func cmdClient(s *Server, c *conn, args []string) bool {
+	switch strings.ToLower(args[1]) {
+	case "id":
+		c.w.writeInt(c.id)
+	case "getname":
+		if c.name == "" {
+			c.w.writeNull()
+		} else {
+			c.w.writeBulk(c.name)
+		}
+	case "setname":
+		if len(args) != 3 {
+			c.w.writeError("ERR wrong number of arguments for 'client|setname' command")
+			return false
+		}
+		c.name = args[2]
+		c.w.writeSimple("OK")
+	case "setinfo":
+		c.w.writeSimple("OK")
+	default:
+		c.w.writeError(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+	}
+	return false
+}
+
+// cmdCommand replies with an empty command table. redis-cli asks for it on
+// startup and only uses it for hints.
+func cmdCommand(s *Server, c *conn, args []string) bool {
+	c.w.writeArray(0)
+	return false
+}
+
+// cmdInfo replies with server statistics: INFO [section ...].
+func cmdInfo(s *Server, c *conn, args []string) bool {
+	sections := []struct {
+		name   string
+		fields [][2]string
+	}{
+		{name: "server", fields: [][2]string{
+			{"server_name", serverName},
+			{"server_version", serverVersion},
+			{"redis_mode", "standalone"},
+			{"process_id", strconv.Itoa(os.Getpid())},
+			{"uptime_in_seconds", strconv.FormatInt(int64(time.Since(s.started).Seconds()), 10)},
+		}},
+		{name: "clients", fields: [][2]string{
+			{"connected_clients", strconv.Itoa(s.connectedClients())},
+		}},
+		{name: "memory", fields: memoryInfo(s)},
+		{name: "stats", fields: statsInfo(s)},
+		{name: "persistence", fields: persistenceInfo(s)},
+		{name: "keyspace", fields: [][2]string{
+			{"db0", fmt.Sprintf("keys=%d", s.engine.GetKeyDirSize())},
+		}},
+	}
+
+	wanted := make(map[string]bool)
+	for _, arg := range args[1:] {
+		wanted[strings.ToLower(arg)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var info strings.Builder
+	for _, section := range sections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if info.Len() > 0 {
+			info.WriteString("\r\n")
+		}
+		fmt.Fprintf(&info, "# %s%s\r\n", strings.ToUpper(section.name[:1]), section.name[1:])
+		for _, field := range section.fields {
+			fmt.Fprintf(&info, "%s:%s\r\n", field[0], field[1])
+		}
+	}
+	c.w.writeBulk(info.String())
+	return false
+}
+
+// memoryInfo returns the fields of the INFO memory section.
+func memoryInfo(s *Server) [][2]string {
+	stats, err := s.engine.Stats()
+	if err != nil {
+		return nil
+	}
+	fields := [][2]string{{"keydir_bytes", strconv.FormatInt(stats.KeyDirBytes, 10)}}
+	if stats.Cache != nil {
+		fields = append(fields, [2]string{"cache_bytes", strconv.FormatInt(stats.Cache.Bytes, 10)})
+	}
+	return fields
+}
+
+// statsInfo returns the fields of the INFO stats section, including the
+// read cache counters when the cache is enabled.
+func statsInfo(s *Server) [][2]string {
+	fields := [][2]string{
+		{"total_connections_received", strconv.FormatInt(s.totalConnections.Load(), 10)},
+		{"total_commands_processed", strconv.FormatInt(s.totalCommands.Load(), 10)},
+	}
+
+	if stats, err := s.engine.Stats(); err == nil && stats.Cache != nil {
+		fields = append(fields,
+			[2]string{"cache_hits", strconv.FormatUint(stats.Cache.Hits, 10)},
+			[2]string{"cache_misses", strconv.FormatUint(stats.Cache.Misses, 10)},
+			[2]string{"cache_evictions", strconv.FormatUint(stats.Cache.Evictions, 10)})
+	}
+	return fields
+}
+
+// persistenceInfo returns the fields of the INFO persistence section.
+func persistenceInfo(s *Server) [][2]string {
+	status := "ok"
+	if s.engine.Health() != nil {
+		status = "err"
+	}
+	fields := [][2]string{{"last_flush_status", status}}
+
+	if stats, err := s.engine.Stats(); err == nil {
+		fields = append(fields,
+			[2]string{"flush_failures", strconv.FormatUint(stats.FlushFailures, 10)},
+			[2]string{"disk_bytes", strconv.FormatInt(stats.DiskBytes, 10)},
+			[2]string{"dead_bytes", strconv.FormatInt(stats.DeadBytes, 10)},
+			[2]string{"merging", strconv.FormatBool(stats.Merging)},
+			[2]string{"last_seq", strconv.FormatUint(stats.LastSeq, 10)})
+	}
+	return fields
+}
+
+// cmdDbSize replies with the number of keys.
+func cmdDbSize(s *Server, c *conn, args []string) bool {
+	c.w.writeInt(int64(s.engine.GetKeyDirSize()))
+	return false
+}
+
+// cmdGet replies with the value of a key, or null if it does not exist.
+func cmdGet(s *Server, c *conn, args []string) bool {
+	value, err := s.engine.Get(args[1])
+	if errors.Is(err, engine.ErrKeyNotFound) {
+		c.w.writeNull()
+		return false
+	}
+	if err != nil {
+		writeEngineError(c, err)
+		return false
+	}
+	c.w.writeBulk(value)
+	return false
+}
+
+// cmdSet stores a value: SET key value [EX seconds | PX milliseconds].
+func cmdSet(s *Server, c *conn, args []string) bool {
+	key, value := args[1], args[2]
+
+	var ttl time.Duration
+	for i := 3; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		if (option != "ex" && option != "px") || ttl != 0 || i+1 >= len(args) {
+			c.w.writeError("ERR syntax error")
+			return false
+		}
+		n, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil {
+			c.w.writeError("ERR value is not an integer or out of range")
+			return false
+		}
+		unit := time.Millisecond
+		if option == "ex" {
+			unit = time.Second
+		}
+		if n <= 0 || !inDurationRange(n, unit) {
+			c.w.writeError("ERR invalid expire time in 'set' command")
+			return false
+		}
+		ttl = time.Duration(n) * unit
+		i++
+	}
+
+	var err error
+	if ttl > 0 {
+		err = s.engine.PutWithTTL(key, value, ttl)
+	} else {
+		err = s.engine.Put(key, value)
+	}
+	if err != nil {
+		writeEngineError(c, err)
+		return false
+	}
+	c.w.writeSimple("OK")
+	return false
+}
+
+// cmdDel deletes keys in a single batch and replies with how many existed.
+func cmdDel(s *Server, c *conn, args []string) bool {
+	deleted, err := s.engine.DeleteExisting(args[1:]...)
+	if err != nil {
+		writeEngineError(c, err)
+		return false
+	}
+	c.w.writeInt(int64(deleted))
+	return false
+}
+
+// cmdExists replies with how many of the given keys exist. A key named
+// more than once is counted each time.
+func cmdExists(s *Server, c *conn, args []string) bool {
+	count := 0
+	for _, key := range args[1:] {
+		if _, err := s.engine.TTL(key); err == nil {
+			count++
+		}
+	}
+	c.w.writeInt(int64(count))
+	return false
+}
+
+// cmdMGet replies with the value of every key, null for missing ones.
+func cmdMGet(s *Server, c *conn, args []string) bool {
+	values := make([]*string, len(args)-1)
+	for i, key := range args[1:] {
+		value, err := s.engine.Get(key)
+		if errors.Is(err, engine.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			writeEngineError(c, err)
+			return false
+		}
+		values[i] = &value
+	}
+
+	c.w.writeArray(len(values))
+	for _, value := range values {
+		if value == nil {
+			c.w.writeNull()
+		} else {
+			c.w.writeBulk(*value)
+		}
+	}
+	return false
+}
+
+// cmdMSet stores every key-value pair atomically: MSET key value [key value ...].
+func cmdMSet(s *Server, c *conn, args []string) bool {
+	if len(args)%2 != 1 {
+		c.w.writeError("ERR wrong number of arguments for 'mset' command")
+		return false
+	}
+
+	batch := engine.NewWriteBatch()
+	for i := 1; i < len(args); i += 2 {
+		batch.Put(args[i], args[i+1])
+	}
+	if err := s.engine.Write(batch); err != nil {
+		writeEngineError(c, err)
+		return false
+	}
+	c.w.writeSimple("OK")
+	return false
+}
+
+// cmdScan iterates the keyspace in sorted order:
+// SCAN cursor [MATCH pattern] [COUNT count]. Cursors are handed out per
+// connection and remember the last key returned, so every key that exists
+// for the whole iteration is returned exactly once.
+func cmdScan(s *Server, c *conn, args []string) bool {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		c.w.writeError("ERR invalid cursor")
+		return false
+	}
+
+	pattern := "*"
+	count := defaultScanCount
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.w.writeError("ERR syntax error")
+			return false
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				c.w.writeError("ERR value is not an integer or out of range")
+				return false
+			}
+			if n < 1 {
+				c.w.writeError("ERR syntax error")
+				return false
+			}
+			count = n
+		default:
+			c.w.writeError("ERR syntax error")
+			return false
+		}
+	}
+
+	// Only keys starting with the literal prefix of the pattern can match
+	prefix := globPrefix(pattern)
+	start := prefix
+	if cursor != 0 {
+		last, ok := c.cursors[cursor]
+		if !ok {
+			c.w.writeError("ERR invalid cursor")
+			return false
+		}
+		delete(c.cursors, cursor)
+		// The smallest key sorting after last
+		if last+"\x00" > start {
+			start = last + "\x00"
+		}
+	}
+
+	it := s.engine.Scan(start, index.PrefixEnd(prefix))
+	defer it.Close()
+
+	keys := make([]string, 0)
+	visited := 0
+	last := ""
+	more := false
+	for it.Next() {
+		if visited == count {
+			more = true
+			break
+		}
+		last = it.Key()
+		visited++
+		if matchGlob(pattern, last) {
+			keys = append(keys, last)
+		}
+	}
+
+	next := uint64(0)
+	if more {
+		next = c.saveCursor(last)
+	}
+
+	c.w.writeArray(2)
+	c.w.writeBulk(strconv.FormatUint(next, 10))
+	c.w.writeArray(len(keys))
+	for _, key := range keys {
+		c.w.writeBulk(key)
+	}
+	return false
+}
+
+// saveCursor hands out a new SCAN cursor resuming after last.
+func (c *conn) saveCursor(last string) uint64 {
+	if len(c.cursors) >= maxCursors {
+		ids := make([]uint64, 0, len(c.cursors))
+		for id := range c.cursors {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		delete(c.cursors, ids[0])
+	}
+
+	c.cursor++
+	c.cursors[c.cursor] = last
+	return c.cursor
+}
+
+// cmdTTL replies with the time left before a key expires, in seconds for
+// TTL and milliseconds for PTTL: -2 if the key does not exist and -1 if it
+// never expires.
+func cmdTTL(s *Server, c *conn, args []string) bool {
+	ttl, err := s.engine.TTL(args[1])
+	switch {
+	case errors.Is(err, engine.ErrKeyNotFound):
+		c.w.writeInt(-2)
+	case err != nil:
+		writeEngineError(c, err)
+	case ttl == engine.NoTTL:
+		c.w.writeInt(-1)
+	case strings.ToLower(args[0]) == "pttl":
+		c.w.writeInt(ttl.Milliseconds())
+	default:
+		c.w.writeInt(int64((ttl + 500*time.Millisecond) / time.Second))
+	}
+	return false
+}
+
+// cmdExpire sets a key to expire after the given number of seconds for
+// EXPIRE or milliseconds for PEXPIRE. Replies 1 if the key exists and 0
+// otherwise.
+func cmdExpire(s *Server, c *conn, args []string) bool {
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.w.writeError("ERR value is not an integer or out of range")
+		return false
+	}
+	unit := time.Second
+	if strings.ToLower(args[0]) == "pexpire" {
+		unit = time.Millisecond
+	}
+	if !inDurationRange(n, unit) {
+		c.w.writeError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(args[0])))
+		return false
+	}
+	ttl := time.Duration(n) * unit
+
+	ok, err := s.engine.Expire(args[1], ttl)
+	if err != nil {
+		writeEngineError(c, err)
+		return false
+	}
+	if ok {
+		c.w.writeInt(1)
+	} else {
+		c.w.writeInt(0)
+	}
+	return false
+}
+
+// inDurationRange reports whether n units fit in a time.Duration, so
+// multiplying them out does not wrap around.
+func inDurationRange(n int64, unit time.Duration) bool {
+	return n <= math.MaxInt64/int64(unit) && n >= math.MinInt64/int64(unit)
+}
+
+// cmdBackup writes a backup of the running store to the directory of the
+// given name under the server's BackupDir, which must not exist or be
+// empty, and replies OK once the backup is complete and verified. Clients
+// only choose the name, never where on the server's file system it goes.
+func cmdBackup(s *Server, c *conn, args []string) bool {
+	if s.BackupDir == "" {
+		c.w.writeError("ERR backups are disabled, set BACKUP_DIR to enable them")
+		return false
+	}
+	dir, err := engine.BackupPath(s.BackupDir, args[1])
+	if err != nil {
+		writeEngineError(c, err)
+		return false
+	}
+
+	if _, err := s.engine.Backup(dir); err != nil {
+		writeEngineError(c, err)
+		return false
+	}
+	if _, err := engine.VerifyBackup(dir); err != nil {
+		writeEngineError(c, err)
+		return false
+	}
+	c.w.writeSimple("OK")
+	return false
+}
+
+// writeEngineError replies with an error returned by the engine.
+func writeEngineError(c *conn, err error) {
+	c.w.writeError("ERR " + err.Error())
+}
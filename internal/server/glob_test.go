@@ -0,0 +1,54 @@
+package server
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{pattern: "*", s: "", want: true},
+		{pattern: "*", s: "a/b", want: true},
+		{pattern: "user:*", s: "user:42", want: true},
+		{pattern: "user:*", s: "order:42", want: false},
+		{pattern: "*:name", s: "user:42:name", want: true},
+		{pattern: "h?llo", s: "hello", want: true},
+		{pattern: "h?llo", s: "hllo", want: false},
+		{pattern: "h[ae]llo", s: "hallo", want: true},
+		{pattern: "h[ae]llo", s: "hillo", want: false},
+		{pattern: "h[^e]llo", s: "hallo", want: true},
+		{pattern: "h[^e]llo", s: "hello", want: false},
+		{pattern: "key[0-9]", s: "key7", want: true},
+		{pattern: "key[0-9]", s: "keyx", want: false},
+		{pattern: `a\*b`, s: "a*b", want: true},
+		{pattern: `a\*b`, s: "axb", want: false},
+		{pattern: "a**b", s: "ab", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "*", want: ""},
+		{pattern: "user:42:*", want: "user:42:"},
+		{pattern: "key?", want: "key"},
+		{pattern: "key[0-9]", want: "key"},
+		{pattern: `a\*b*`, want: "a*b"},
+		{pattern: "exact", want: "exact"},
+	}
+
+	for _, tt := range tests {
+		if got := globPrefix(tt.pattern); got != tt.want {
+			t.Errorf("globPrefix(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxArgs limits the number of arguments accepted in a single command.
+	maxArgs = 1024 * 1024
+	// maxBulkSize limits the size in bytes of a single bulk string argument.
+	maxBulkSize = 512 * 1024 * 1024
+	// maxLineSize limits the length in bytes of an inline command or of the
+	// header line of a multibulk request or bulk string.
+	maxLineSize = 64 * 1024
+	// bulkChunkSize is the most a bulk string's buffer grows ahead of the
+	// bytes actually received, so a large declared length costs nothing
+	// until the data arrives.
+	bulkChunkSize = 1024 * 1024
+	// argsPrealloc is the most argument slots allocated ahead of the
+	// arguments actually received.
+	argsPrealloc = 1024
+)
+
+// errProtocol is wrapped by every error caused by a malformed request. The
+// connection is closed after replying, since the stream cannot be resynced.
+var errProtocol = errors.New("protocol error")
+
+// reader parses client requests. Requests are normally RESP arrays of bulk
+// strings, but plain inline commands such as those typed into telnet are
+// accepted too.
+type reader struct {
+	r *bufio.Reader
+}
+
+// newReader creates a reader over the given connection.
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r)}
+}
+
+// readCommand reads the next request and returns its arguments. An empty
+// inline line yields an empty command. Returns io.EOF once the client has
+// closed the connection between requests.
+func (r *reader) readCommand() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	args := make([]string, 0, min(max(count, 0), argsPrealloc))
+	for i := 0; i < count; i++ {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a single bulk string argument.
+func (r *reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("%w: expected '$', got %q", errProtocol, line)
+	}
+
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 || size > maxBulkSize {
+		return "", fmt.Errorf("%w: invalid bulk length", errProtocol)
+	}
+
+	data := make([]byte, 0, min(size+2, bulkChunkSize))
+	for len(data) < size+2 {
+		n := min(size+2-len(data), bulkChunkSize)
+		data = slices.Grow(data, n)
+		if _, err := io.ReadFull(r.r, data[len(data):len(data)+n]); err != nil {
+			return "", unexpectedEOF(err)
+		}
+		data = data[:len(data)+n]
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string not terminated by CRLF", errProtocol)
+	}
+	return string(data[:size]), nil
+}
+
+// readLine reads a line terminated by CRLF, or by a bare LF for inline
+// commands, and returns it without the terminator. A line longer than
+// maxLineSize is a protocol error.
+func (r *reader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineSize+2 {
+			return "", fmt.Errorf("%w: line exceeds %d bytes", errProtocol, maxLineSize)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) == 0 {
+				return "", io.EOF
+			}
+			return "", unexpectedEOF(err)
+		}
+		break
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// unexpectedEOF turns an EOF in the middle of a request into io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// writer encodes replies in the protocol version negotiated by the client.
+// RESP3 adds dedicated null and map types; under RESP2 nulls are sent as
+// null bulk strings and maps as flat arrays of alternating keys and values.
+type writer struct {
+	w     *bufio.Writer
+	proto int // 2 or 3
+}
+
+// newWriter creates a writer that starts out speaking RESP2.
+func newWriter(w io.Writer) *writer {
+	return &writer{w: bufio.NewWriter(w), proto: 2}
+}
+
+// writeSimple writes a simple string reply such as OK.
+func (w *writer) writeSimple(s string) {
+	w.w.WriteString("+" + s + "\r\n")
+}
+
+// writeError writes an error reply. The message should start with an error
+// code such as ERR or WRONGTYPE.
+func (w *writer) writeError(msg string) {
+	// Error replies are single line
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.w.WriteString("-" + msg + "\r\n")
+}
+
+// writeInt writes an integer reply.
+func (w *writer) writeInt(n int64) {
+	w.w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// writeBulk writes a bulk string reply.
+func (w *writer) writeBulk(s string) {
+	w.w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+// writeNull writes a null reply.
+func (w *writer) writeNull() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+// writeArray writes the header of an array reply with n elements, which
+// must follow.
+func (w *writer) writeArray(n int) {
+	w.w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// writeMap writes the header of a map reply with n key-value pairs, which
+// must follow.
+func (w *writer) writeMap(n int) {
+	if w.proto == 3 {
+		w.w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.writeArray(2 * n)
+}
+
+// flush sends every buffered reply to the client.
+func (w *writer) flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReader_ReadCommand(t *testing.T) {
+	longLine := strings.Repeat("a", maxLineSize+1)
+
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{name: "multibulk", input: "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", want: []string{"GET", "k"}},
+		{name: "inline", input: "SET k v\n", want: []string{"SET", "k", "v"}},
+		{name: "inline at limit", input: strings.Repeat("a", maxLineSize) + "\r\n", want: []string{strings.Repeat("a", maxLineSize)}},
+		{name: "inline too long", input: longLine + "\r\n", wantErr: errProtocol},
+		{name: "unterminated line too long", input: longLine + "aa", wantErr: errProtocol},
+		{name: "bulk header too long", input: "*1\r\n$" + longLine + "\r\n", wantErr: errProtocol},
+		{name: "multibulk too long", input: fmt.Sprintf("*%d\r\n", maxArgs+1), wantErr: errProtocol},
+		{name: "bulk too large", input: fmt.Sprintf("*1\r\n$%d\r\n", maxBulkSize+1), wantErr: errProtocol},
+		{name: "negative bulk", input: "*1\r\n$-1\r\n", wantErr: errProtocol},
+		{name: "bulk not terminated", input: "*1\r\n$1\r\nabc", wantErr: errProtocol},
+		// A declared length is not allocated up front, so a short body fails
+		// without costing the full size
+		{name: "bulk cut short", input: fmt.Sprintf("*1\r\n$%d\r\nabc", maxBulkSize), wantErr: io.ErrUnexpectedEOF},
+		{name: "closed", input: "", wantErr: io.EOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newReader(strings.NewReader(tt.input)).readCommand()
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("readCommand() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCommand() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// Package server exposes the storage engine over TCP using the Redis
+// serialization protocol (RESP2 and RESP3), so standard Redis clients and
+// redis-cli can talk to it. Every connection is served by its own goroutine
+// and all of them share a single engine.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/engine"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close.
+var ErrServerClosed = errors.New("server closed")
+
+// Server accepts RESP connections and executes their commands against an
+// engine.
+type Server struct {
+	// BackupDir is the directory BACKUP writes named backups under. Empty
+	// disables BACKUP. Set it before serving.
+	BackupDir string
+
+	engine   engine.Engine
+	started  time.Time
+	mu       sync.Mutex // Protects listener, conns and closed
+	listener net.Listener
+	conns    map[*conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup // Tracks connection goroutines so Close can wait for them
+
+	nextConnId       atomic.Int64
+	totalConnections atomic.Int64
+	totalCommands    atomic.Int64
+}
+
+// conn holds the state of a single client connection.
+type conn struct {
+	id      int64
+	netConn net.Conn
+	r       *reader
+	w       *writer
+	name    string            // Set by CLIENT SETNAME or HELLO SETNAME
+	cursors map[uint64]string // Last key returned for each SCAN cursor handed out
+	cursor  uint64            // Last SCAN cursor handed out
+}
+
+// NewServer creates a server that executes commands against the given engine.
+func NewServer(e engine.Engine) *Server {
+	return &Server{
+		engine:  e,
+		started: time.Now(),
+		conns:   make(map[*conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+// until Close is called. It always returns a non-nil error.
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts connections on the listener and serves each one in its own
+// goroutine until Close is called. It takes ownership of the listener and
+// always returns a non-nil error, ErrServerClosed after Close.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	slog.Info("server: listening",
+		"addr", listener.Addr().String())
+
+	for {
+		netConn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		c := &conn{
+			id:      s.nextConnId.Add(1),
+			netConn: netConn,
+			r:       newReader(netConn),
+			w:       newWriter(netConn),
+			cursors: make(map[uint64]string),
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			netConn.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		s.totalConnections.Add(1)
+		go s.serveConn(c)
+	}
+}
+
+// Addr returns the address the server is listening on, or nil if it is not
+// serving yet.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops accepting connections, closes every open connection and waits
+// for their goroutines to finish. Commands already executing complete first.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.netConn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	slog.Info("server: closed")
+	if err != nil {
+		return fmt.Errorf("failed to close listener: %w", err)
+	}
+	return nil
+}
+
+// serveConn reads and executes commands from a connection until the client
+// disconnects, sends QUIT or the server is closed.
+func (s *Server) serveConn(c *conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.netConn.Close()
+	}()
+
+	slog.Debug("server: connection accepted",
+		"conn_id", c.id,
+		"remote_addr", c.netConn.RemoteAddr().String())
+
+	for {
+		args, err := c.r.readCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.w.writeError("ERR " + err.Error())
+				c.w.flush()
+			}
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("server: connection error",
+					"conn_id", c.id,
+					"error", err)
+			}
+			break
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.totalCommands.Add(1)
+		quit := s.execute(c, args)
+
+		// Replies to pipelined commands are sent together
+		if c.r.r.Buffered() == 0 || quit {
+			if err := c.w.flush(); err != nil {
+				slog.Debug("server: failed to write reply",
+					"conn_id", c.id,
+					"error", err)
+				break
+			}
+		}
+		if quit {
+			break
+		}
+	}
+
+	slog.Debug("server: connection closed",
+		"conn_id", c.id)
+}
+
+// execute runs a single command and writes its reply. Returns true if the
+// connection should be closed afterwards.
+func (s *Server) execute(c *conn, args []string) bool {
+	name := strings.ToLower(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.writeError(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s",
+			args[0], quoteArgs(args[1:])))
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+
+	slog.Debug("server: executing command",
+		"conn_id", c.id,
+		"command", name,
+		"args", len(args)-1)
+
+	return cmd.handler(s, c, args)
+}
+
+// quoteArgs formats command arguments for an unknown command error.
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + arg + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// connectedClients returns the number of open connections.
+func (s *Server) connectedClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
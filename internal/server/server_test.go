@@ -0,0 +1,352 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/config"
+	"github.com/jassi-singh/aether-kv/internal/engine"
+)
+
+// respError is an error reply read by testClient.
+type respError string
+
+// testClient is a minimal RESP client used to drive the server.
+type testClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// startServer starts a server for a fresh engine on a localhost port, with
+// opts applied before it serves, and returns its address. Both are closed
+// when the test ends.
+func startServer(t *testing.T, opts ...func(*Server)) string {
+	t.Helper()
+	cfg := &config.Config{
+		DATA_DIR:      t.TempDir(),
+		HEADER_SIZE:   21,
+		BATCH_SIZE:    4096,
+		SYNC_INTERVAL: 5,
+	}
+	kv, err := engine.NewKVEngine(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	srv := NewServer(kv)
+	for _, opt := range opts {
+		opt(srv)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(listener) }()
+
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Errorf("Server.Close() error = %v", err)
+		}
+		if err := <-done; !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve() error = %v, want %v", err, ErrServerClosed)
+		}
+		kv.Close()
+	})
+	return listener.Addr().String()
+}
+
+// dial connects a test client to addr and closes it when the test ends.
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns its reply.
+func (c *testClient) do(t *testing.T, args ...string) interface{} {
+	t.Helper()
+	var req strings.Builder
+	fmt.Fprintf(&req, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&req, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, req.String()); err != nil {
+		t.Fatalf("Failed to send %v: %v", args, err)
+	}
+	return c.read(t)
+}
+
+// read reads one reply. Bulk and simple strings are returned as string,
+// integers as int64, nulls as nil, arrays as []interface{}, maps as
+// map[string]interface{} and errors as respError.
+func (c *testClient) read(t *testing.T) interface{} {
+	t.Helper()
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return respError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			t.Fatalf("Invalid integer reply %q", line)
+		}
+		return n
+	case '_':
+		return nil
+	case '$':
+		size, _ := strconv.Atoi(line[1:])
+		if size < 0 {
+			return nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			t.Fatalf("Failed to read bulk reply: %v", err)
+		}
+		return string(data[:size])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.read(t)
+		}
+		return items
+	case '%':
+		n, _ := strconv.Atoi(line[1:])
+		items := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key := c.read(t).(string)
+			items[key] = c.read(t)
+		}
+		return items
+	}
+	t.Fatalf("Unknown reply type %q", line)
+	return nil
+}
+
+func TestServer_Commands(t *testing.T) {
+	client := dial(t, startServer(t))
+
+	tests := []struct {
+		args []string
+		want interface{}
+	}{
+		{args: []string{"PING"}, want: "PONG"},
+		{args: []string{"ping", "hello"}, want: "hello"},
+		{args: []string{"GET", "missing"}, want: nil},
+		{args: []string{"SET", "a", "1"}, want: "OK"},
+		{args: []string{"GET", "a"}, want: "1"},
+		{args: []string{"SET", "b", "two words"}, want: "OK"},
+		{args: []string{"EXISTS", "a", "b", "missing", "a"}, want: int64(3)},
+		{args: []string{"MSET", "c", "3", "d", "4"}, want: "OK"},
+		{args: []string{"MGET", "a", "missing", "d"}, want: []interface{}{"1", nil, "4"}},
+		{args: []string{"DBSIZE"}, want: int64(4)},
+		{args: []string{"DEL", "a", "c", "missing"}, want: int64(2)},
+		{args: []string{"GET", "a"}, want: nil},
+		{args: []string{"TTL", "b"}, want: int64(-1)},
+		{args: []string{"TTL", "missing"}, want: int64(-2)},
+		{args: []string{"EXPIRE", "b", "100"}, want: int64(1)},
+		{args: []string{"TTL", "b"}, want: int64(100)},
+		{args: []string{"GET", "b"}, want: "two words"},
+		{args: []string{"EXPIRE", "missing", "100"}, want: int64(0)},
+		{args: []string{"EXPIRE", "b", "9999999999999"}, want: respError("ERR invalid expire time in 'expire' command")},
+		{args: []string{"EXPIRE", "b", "-9999999999999"}, want: respError("ERR invalid expire time in 'expire' command")},
+		{args: []string{"PEXPIRE", "b", "9223372036854776"}, want: respError("ERR invalid expire time in 'pexpire' command")},
+		{args: []string{"SET", "b", "two words", "EX", "9999999999999"}, want: respError("ERR invalid expire time in 'set' command")},
+		{args: []string{"GET", "b"}, want: "two words"},
+		{args: []string{"SET", "e", "5", "EX", "50"}, want: "OK"},
+		{args: []string{"TTL", "e"}, want: int64(50)},
+		{args: []string{"SET", "e", "5", "NX"}, want: respError("ERR syntax error")},
+		{args: []string{"MSET", "x"}, want: respError("ERR wrong number of arguments for 'mset' command")},
+		{args: []string{"GET"}, want: respError("ERR wrong number of arguments for 'get' command")},
+		{args: []string{"NOPE", "x"}, want: respError("ERR unknown command 'NOPE', with args beginning with: 'x'")},
+		{args: []string{"EXPIRE", "e", "0"}, want: int64(1)},
+		{args: []string{"EXISTS", "e"}, want: int64(0)},
+	}
+
+	for _, tt := range tests {
+		if got := client.do(t, tt.args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v = %#v, want %#v", tt.args, got, tt.want)
+		}
+	}
+
+	info, ok := client.do(t, "INFO", "keyspace").(string)
+	if !ok || !strings.Contains(info, "db0:keys=2") || strings.Contains(info, "# Server") {
+		t.Errorf("INFO keyspace = %q, want only the keyspace section with 2 keys", info)
+	}
+
+	if got := client.do(t, "QUIT"); got != "OK" {
+		t.Errorf("QUIT = %#v, want OK", got)
+	}
+	if _, err := client.r.ReadByte(); err != io.EOF {
+		t.Errorf("connection still open after QUIT: %v", err)
+	}
+}
+
+func TestServer_Backup(t *testing.T) {
+	client := dial(t, startServer(t))
+	if got, ok := client.do(t, "BACKUP", "nightly").(respError); !ok || !strings.Contains(string(got), "disabled") {
+		t.Errorf("BACKUP without a backup directory = %#v, want disabled error", got)
+	}
+
+	root := t.TempDir()
+	client = dial(t, startServer(t, func(s *Server) { s.BackupDir = root }))
+	if got := client.do(t, "SET", "a", "1"); got != "OK" {
+		t.Fatalf("SET a 1 = %#v, want OK", got)
+	}
+
+	if got := client.do(t, "BACKUP", "nightly"); got != "OK" {
+		t.Fatalf("BACKUP = %#v, want OK", got)
+	}
+	if manifest, err := engine.VerifyBackup(filepath.Join(root, "nightly")); err != nil || manifest.LastSeq != 1 {
+		t.Errorf("VerifyBackup() = %+v, %v, want a backup up to sequence 1", manifest, err)
+	}
+	if _, ok := client.do(t, "BACKUP", "nightly").(respError); !ok {
+		t.Errorf("BACKUP into a non-empty directory did not reply with an error")
+	}
+
+	// Only names under the backup directory are accepted
+	for _, name := range []string{filepath.Join(t.TempDir(), "abs"), "..", "../escape", "a/b"} {
+		if got, ok := client.do(t, "BACKUP", name).(respError); !ok || !strings.Contains(string(got), "invalid backup name") {
+			t.Errorf("BACKUP %s = %#v, want invalid backup name error", name, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escape")); !os.IsNotExist(err) {
+		t.Errorf("BACKUP ../escape wrote outside the backup directory: %v", err)
+	}
+}
+
+func TestServer_Scan(t *testing.T) {
+	client := dial(t, startServer(t))
+
+	want := make([]string, 0)
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		want = append(want, key)
+		client.do(t, "SET", key, "v")
+		client.do(t, "SET", fmt.Sprintf("order:%d", i), "v")
+	}
+	sort.Strings(want)
+
+	got := make([]string, 0)
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 10 {
+			t.Fatal("SCAN did not finish")
+		}
+		reply := client.do(t, "SCAN", cursor, "MATCH", "user:*", "COUNT", "7").([]interface{})
+		for _, key := range reply[1].([]interface{}) {
+			got = append(got, key.(string))
+		}
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SCAN MATCH user:* = %v, want %v", got, want)
+	}
+
+	if reply := client.do(t, "SCAN", "12345"); reply != respError("ERR invalid cursor") {
+		t.Errorf("SCAN with unknown cursor = %#v, want invalid cursor error", reply)
+	}
+}
+
+func TestServer_RESP3(t *testing.T) {
+	client := dial(t, startServer(t))
+
+	if got := client.do(t, "HELLO", "4"); got != respError("NOPROTO unsupported protocol version") {
+		t.Errorf("HELLO 4 = %#v, want NOPROTO error", got)
+	}
+
+	hello, ok := client.do(t, "HELLO", "3", "SETNAME", "tester").(map[string]interface{})
+	if !ok || hello["proto"] != int64(3) || hello["server"] != serverName {
+		t.Fatalf("HELLO 3 = %#v, want a map with proto 3", hello)
+	}
+	if got := client.do(t, "CLIENT", "GETNAME"); got != "tester" {
+		t.Errorf("CLIENT GETNAME = %#v, want tester", got)
+	}
+
+	// RESP3 has a dedicated null type
+	if _, err := io.WriteString(client.conn, "*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n"); err != nil {
+		t.Fatalf("Failed to send GET: %v", err)
+	}
+	if line, _ := client.r.ReadString('\n'); line != "_\r\n" {
+		t.Errorf("GET missing under RESP3 = %q, want %q", line, "_\r\n")
+	}
+}
+
+func TestServer_InlineAndPipelined(t *testing.T) {
+	client := dial(t, startServer(t))
+
+	if _, err := io.WriteString(client.conn, "SET k v\r\nGET k\r\nPING\n"); err != nil {
+		t.Fatalf("Failed to send commands: %v", err)
+	}
+	for _, want := range []interface{}{"OK", "v", "PONG"} {
+		if got := client.read(t); got != want {
+			t.Errorf("pipelined reply = %#v, want %#v", got, want)
+		}
+	}
+
+	// A malformed request closes the connection after an error reply
+	if _, err := io.WriteString(client.conn, "*1\r\n+PING\r\n"); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if got, ok := client.read(t).(respError); !ok || !strings.HasPrefix(string(got), "ERR protocol error") {
+		t.Errorf("malformed request reply = %#v, want protocol error", got)
+	}
+}
+
+func TestServer_ConcurrentClients(t *testing.T) {
+	addr := startServer(t)
+
+	var wg sync.WaitGroup
+	for c := 0; c < 20; c++ {
+		client := dial(t, addr)
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("client%d:key%d", c, i)
+				value := fmt.Sprintf("value%d", i)
+				if got := client.do(t, "SET", key, value); got != "OK" {
+					t.Errorf("SET %s = %#v, want OK", key, got)
+					return
+				}
+				if got := client.do(t, "GET", key); got != value {
+					t.Errorf("GET %s = %#v, want %q", key, got, value)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if got := dial(t, addr).do(t, "DBSIZE"); got != int64(1000) {
+		t.Errorf("DBSIZE = %#v, want 1000", got)
+	}
+}
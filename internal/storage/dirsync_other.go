@@ -0,0 +1,9 @@
+//go:build !unix
+
+package storage
+
+// syncDir does nothing on platforms where directories cannot be fsynced;
+// their file systems make directory changes durable on their own.
+func syncDir(path string) error {
+	return nil
+}
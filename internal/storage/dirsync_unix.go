@@ -0,0 +1,19 @@
+//go:build unix
+
+package storage
+
+import "os"
+
+// syncDir fsyncs the directory at path, so the files created, renamed or
+// removed in it survive a crash.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
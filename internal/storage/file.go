@@ -1,52 +1,173 @@
 // Package storage provides file storage operations for the key-value store.
-// It handles buffered writes, automatic flushing, and file I/O operations.
+// It handles buffered writes, automatic flushing, segment rotation and
+// file I/O operations.
 package storage
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/jassi-singh/aether-kv/internal/config"
 )
 
+const (
+	// ActiveFileName is the name of the log file currently receiving writes.
+	ActiveFileName = "active.log"
+	// SegmentExt is the file extension used by sealed, immutable segments.
+	SegmentExt = ".log"
+)
+
+// ErrFailed is returned by Append once an append that failed could not be
+// discarded from the active file. The end of the log is unknown from then
+// on, so every later append is refused until the file is reopened.
+var ErrFailed = errors.New("log file failed")
+
 // Storage defines the interface for storage operations.
 // This abstraction allows for different storage backends and easier testing.
 type Storage interface {
-	Append(data []byte) (int64, error)
-	ReadAt(offset int64, size uint32) ([]byte, error)
+	Append(data []byte) (uint32, int64, error)
+	AppendSync(data []byte) (uint32, int64, error)
+	ReadAt(fileId uint32, offset int64, size uint32) ([]byte, error)
 	Close() error
 	Flush() error
+	Sync() error
+	FlushStatus() FlushStatus
 	// Internal methods for engine coordination
 	GetFile() *os.File
-	GetBuffer() *bufio.Writer
+	ActiveFileId() uint32
+	Segments() []uint32
+	SegmentReader(fileId uint32) (io.Reader, error)
+	OpenActive() (*os.File, uint32, int64, error)
+	SegmentSize(fileId uint32) (int64, error)
+	DiskSize() (int64, error)
+	ReadView(fileId uint32, offset int64, size uint32, fn func(data []byte) error) error
+	MappedSegments() int
+	TruncateActive(size int64) error
+	SetSealHandler(handler func(fileId uint32))
+	WriteHint(fileId uint32, data []byte) error
+	ReadHint(fileId uint32) ([]byte, error)
+	NewMergeWriter(inputs []uint32) (*MergeWriter, error)
+	CommitMerge(w *MergeWriter) error
 }
 
-// File implements Storage and provides buffered file operations
-// with automatic flushing based on batch size and sync interval.
+// File implements Storage and provides buffered file operations.
+// Appends collect in an in-memory tail that is written to the operating
+// system once BATCH_SIZE bytes accumulate, and fsynced according to the
+// configured SyncMode. Writes always go to the active log file; once it
+// grows past MAX_FILE_SIZE (or is older than MAX_FILE_AGE) it is renamed
+// to a numbered immutable segment and a fresh active file is opened.
+//
+// Writers are serialized by mu. Readers never take mu: they hold readMu
+// for reading, positionally read flushed bytes and copy unflushed ones out
+// of the tail, so reads neither wait for appends nor for fsyncs. Fields
+// readers rely on (file, activeId, sealed, tail, flushed) are only changed
+// while holding both mu and readMu.
 type File struct {
-	mu           sync.Mutex // Protects buffer and file operations from concurrent access
-	buffer       *bufio.Writer
-	file         *os.File
+	mu           sync.Mutex          // Serializes appends, flushes, rotation and merges
+	readMu       sync.RWMutex        // Held for reading by ReadAt, for writing while reader-visible fields change
+	tail         []byte              // Appended bytes not yet written to the active file
+	flushed      int64               // Size of the active file on disk; tail starts here
+	file         *os.File            // Active log file receiving appends
+	activeId     uint32              // File id assigned to the active log file
+	activeSize   int64               // Bytes appended to the active file (flushed + tail)
+	activeOpened time.Time           // When the active file was opened, used for age-based rotation
+	sealed       map[uint32]*os.File // Read handles for sealed segments, keyed by file id
+	mapped       map[uint32][]byte   // Memory mappings of sealed segments when MMAP_SEGMENTS is set
+	onSeal       func(fileId uint32) // Called with the id of each segment sealed by rotation
+	syncMode     SyncMode            // When appends are fsynced
+	unsynced     bool                // Set while appended data has not been fsynced
 	lastSyncTime time.Time
+	flushStatus  FlushStatus    // Outcome of background flushes
+	failed       error          // Set once a failed append could not be discarded; appends are refused
+	stop         chan struct{}  // Closed by Close to stop the background flusher
+	flusherWg    sync.WaitGroup // Tracks the background flusher so Close can wait for it
+	lock         *os.File       // LOCK file holding the exclusive lock on the data directory
 	cfg          *config.Config
 }
 
+// FlushStatus reports the outcome of the background flushes of a File.
+type FlushStatus struct {
+	Failures  uint64 // Background flushes that have failed since the file was opened
+	LastError error  // Error of the latest background flush, nil if it succeeded
+	Failed    error  // Set once the file refuses appends, wrapping ErrFailed
+}
+
+// SegmentFileName returns the file name used for the sealed segment with
+// the given file id.
+func SegmentFileName(fileId uint32) string {
+	return fmt.Sprintf("%09d%s", fileId, SegmentExt)
+}
+
+// parseSegmentFileName extracts the file id from a sealed segment file name.
+// Returns false if the name does not belong to a sealed segment.
+func parseSegmentFileName(name string) (uint32, bool) {
+	if name == ActiveFileName || !strings.HasSuffix(name, SegmentExt) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimSuffix(name, SegmentExt), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// LogFiles returns the names of the log files in dir in the order their
+// records were written: sealed segments by ascending file id, then the
+// active file if there is one. It only reads the directory, so it can be
+// used on a backup or a data directory no File has open.
+func LogFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list directory %s: %w", dir, err)
+	}
+
+	ids := make([]uint32, 0, len(entries))
+	active := false
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if entry.Name() == ActiveFileName {
+			active = true
+		} else if id, ok := parseSegmentFileName(entry.Name()); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	names := make([]string, 0, len(ids)+1)
+	for _, id := range ids {
+		names = append(names, SegmentFileName(id))
+	}
+	if active {
+		names = append(names, ActiveFileName)
+	}
+	return names, nil
+}
+
 // NewFile creates a new File instance with the given configuration.
-// It opens or creates the active log file in append mode and initializes
-// the write buffer. Returns an error if file operations fail.
+// It locks the data directory, finishes or discards any interrupted merge,
+// opens every sealed segment found in the data directory for reading,
+// opens or creates the active log file in append mode and initializes
+// the tail buffer. Returns an error wrapping ErrLocked if another File has
+// the directory open, or an error if file operations fail.
 func NewFile(cfg *config.Config) (*File, error) {
 	if cfg == nil {
 		return nil, fmt.Errorf("config cannot be nil")
 	}
 
-	// Ensure the data directory exists
-	if err := os.MkdirAll(cfg.DATA_DIR, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory %s: %w", cfg.DATA_DIR, err)
+	syncMode, err := ParseSyncMode(cfg.SYNC_MODE)
+	if err != nil {
+		return nil, err
 	}
 
 	// Ensure the data directory exists
@@ -54,35 +175,131 @@ func NewFile(cfg *config.Config) (*File, error) {
 		return nil, fmt.Errorf("failed to create data directory %s: %w", cfg.DATA_DIR, err)
 	}
 
-	filePath := cfg.DATA_DIR + "/active.log"
+	// Two writers appending to one log would corrupt it
+	lock, err := lockDir(cfg.DATA_DIR)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		lock:         lock,
+		sealed:       make(map[uint32]*os.File),
+		mapped:       make(map[uint32][]byte),
+		syncMode:     syncMode,
+		lastSyncTime: time.Now(),
+		stop:         make(chan struct{}),
+		cfg:          cfg,
+	}
+
+	if err := recoverMerge(cfg.DATA_DIR); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	if err := f.openSegments(); err != nil {
+		f.closeSegments()
+		lock.Close()
+		return nil, err
+	}
+
+	if err := f.openActive(); err != nil {
+		f.closeSegments()
+		lock.Close()
+		return nil, err
+	}
+
+	// Under SyncAlways and SyncBatch every write is synced by its writer
+	if cfg.SYNC_INTERVAL > 0 && (syncMode == SyncPeriodic || syncMode == SyncNone) {
+		f.flusherWg.Add(1)
+		go f.runFlusher(time.Duration(cfg.SYNC_INTERVAL) * time.Second)
+	}
+
+	return f, nil
+}
+
+// openSegments opens a read handle for every sealed segment in the data
+// directory and derives the file id of the active file from the highest
+// segment id found.
+func (f *File) openSegments() error {
+	entries, err := os.ReadDir(f.cfg.DATA_DIR)
+	if err != nil {
+		return fmt.Errorf("failed to list data directory %s: %w", f.cfg.DATA_DIR, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		id, ok := parseSegmentFileName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		path := filepath.Join(f.cfg.DATA_DIR, entry.Name())
+		segment, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open segment %s: %w", path, err)
+		}
+		f.sealed[id] = segment
+		f.mapSegment(id, segment)
+		if id >= f.activeId {
+			f.activeId = id + 1
+		}
+	}
+
+	slog.Debug("storage: sealed segments opened",
+		"count", len(f.sealed),
+		"active_id", f.activeId)
+	return nil
+}
+
+// openActive opens or creates the active log file and resets the tail
+// buffer and rotation bookkeeping. Caller must hold f.mu and f.readMu once
+// readers may be running.
+func (f *File) openActive() error {
+	filePath := filepath.Join(f.cfg.DATA_DIR, ActiveFileName)
 
 	slog.Debug("storage: opening log file",
 		"path", filePath,
-		"data_dir", cfg.DATA_DIR)
+		"data_dir", f.cfg.DATA_DIR)
 
 	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open log file at %s: %w", filePath, err)
+		return fmt.Errorf("failed to open log file at %s: %w", filePath, err)
 	}
 
-	// Get file stats for logging
 	stat, err := file.Stat()
 	if err != nil {
-		slog.Warn("storage: failed to get file stats",
-			"path", filePath,
-			"error", err)
-	} else {
-		slog.Info("storage: log file opened successfully",
-			"path", filePath,
-			"size", stat.Size())
+		file.Close()
+		return fmt.Errorf("failed to get file stats for %s: %w", filePath, err)
 	}
 
-	return &File{
-		file:         file,
-		buffer:       bufio.NewWriter(file),
-		lastSyncTime: time.Now(),
-		cfg:          cfg,
-	}, nil
+	slog.Info("storage: log file opened successfully",
+		"path", filePath,
+		"file_id", f.activeId,
+		"size", stat.Size())
+
+	f.file = file
+	f.tail = make([]byte, 0, f.cfg.BATCH_SIZE)
+	f.flushed = stat.Size()
+	f.activeSize = stat.Size()
+	f.activeOpened = time.Now()
+	return nil
+}
+
+// closeSegments unmaps and closes all sealed segment handles, logging any
+// failures.
+func (f *File) closeSegments() {
+	for id := range f.mapped {
+		f.unmapSegment(id)
+	}
+	for id, segment := range f.sealed {
+		if err := segment.Close(); err != nil {
+			slog.Error("storage: failed to close segment",
+				"file_id", id,
+				"error", err)
+		}
+	}
 }
 
 // GetFile returns the underlying os.File for internal engine operations.
@@ -92,34 +309,159 @@ func (f *File) GetFile() *os.File {
 	return f.file
 }
 
-// GetBuffer returns the underlying bufio.Writer for internal engine operations.
-// Note: Direct access to the buffer should be done carefully as it's not thread-safe.
+// ActiveFileId returns the file id currently assigned to the active log file.
+func (f *File) ActiveFileId() uint32 {
+	f.readMu.RLock()
+	defer f.readMu.RUnlock()
+	return f.activeId
+}
+
+// Segments returns the ids of all log files in ascending order, ending with
+// the active file. Records in a higher id are always newer than records in
+// a lower id, so this is the order recovery must replay them in.
+func (f *File) Segments() []uint32 {
+	f.readMu.RLock()
+	defer f.readMu.RUnlock()
+
+	ids := make([]uint32, 0, len(f.sealed)+1)
+	for id := range f.sealed {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return append(ids, f.activeId)
+}
+
+// SegmentReader returns a reader over the full contents of the given log
+// file. Buffered writes are flushed first when the active file is requested.
 // This method is primarily for recovery operations.
-func (f *File) GetBuffer() *bufio.Writer {
-	return f.buffer
+func (f *File) SegmentReader(fileId uint32) (io.Reader, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	handle, size, err := f.segmentHandle(fileId)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(handle, 0, size), nil
 }
 
-// ShouldFlushBeforeRead checks if data at the given offset is in the unflushed buffer
-// and returns true if a flush is needed. This is a thread-safe check.
-func (f *File) ShouldFlushBeforeRead(offset int64) (bool, error) {
+// OpenActive flushes and fsyncs buffered writes and opens a new read handle
+// on the active log file, returning it with the file's id and current size.
+// The handle keeps reading the same file once it is sealed, and the bytes
+// up to size never change, so they can be copied while appends go on. The
+// caller must close the handle.
+func (f *File) OpenActive() (*os.File, uint32, int64, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	fileSize, err := f.file.Seek(0, io.SeekEnd)
+	if err := f.flushAndSync(); err != nil {
+		return nil, 0, 0, err
+	}
+	path := filepath.Join(f.cfg.DATA_DIR, ActiveFileName)
+	handle, err := os.Open(path)
 	if err != nil {
-		return false, fmt.Errorf("failed to seek to end of file: %w", err)
+		return nil, 0, 0, fmt.Errorf("failed to open log file %s: %w", path, err)
 	}
+	return handle, f.activeId, f.activeSize, nil
+}
 
-	bufferedSize := int64(f.buffer.Buffered())
-	unflushedStart := fileSize
+// segmentHandle returns the handle and current on-disk size of the given
+// log file, flushing the tail buffer if it is the active file.
+// Caller must hold f.mu.
+func (f *File) segmentHandle(fileId uint32) (*os.File, int64, error) {
+	if fileId == f.activeId {
+		if err := f.flushTail(); err != nil {
+			return nil, 0, err
+		}
+		return f.file, f.activeSize, nil
+	}
 
-	return offset >= unflushedStart && offset < unflushedStart+bufferedSize, nil
+	segment, ok := f.sealed[fileId]
+	if !ok {
+		return nil, 0, fmt.Errorf("segment %d not found", fileId)
+	}
+	stat, err := segment.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get stats for segment %d: %w", fileId, err)
+	}
+	return segment, stat.Size(), nil
 }
 
-// Flush flushes the buffer and syncs the file to disk.
-// This is exposed for cases where the engine needs to ensure data is persisted.
-// This method is thread-safe and can be called concurrently.
+// TruncateActive discards everything in the active file past size, flushing
+// buffered writes first. Recovery uses it to drop a write torn by a crash so
+// that later appends are not stranded behind unreadable bytes. It is a no-op
+// if the active file is not larger than size.
+func (f *File) TruncateActive(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.flushTail(); err != nil {
+		return err
+	}
+	if size >= f.activeSize {
+		return nil
+	}
+
+	slog.Warn("storage: truncating torn tail of active file",
+		"file_id", f.activeId,
+		"size", f.activeSize,
+		"truncated_to", size)
+
+	if err := f.file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to truncate active file to %d bytes: %w", size, err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync active file after truncation: %w", err)
+	}
+
+	f.readMu.Lock()
+	f.flushed = size
+	f.readMu.Unlock()
+	f.activeSize = size
+	return nil
+}
+
+// Flush writes buffered data to the operating system without syncing it.
+// It does not make the data durable; use Sync for that. Reads do not need
+// it, since they are served from the tail buffer. This method is
+// thread-safe and can be called concurrently.
 func (f *File) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.flushTail()
+}
+
+// flushTail writes the tail buffer to the active file. Readers keep reading
+// the tail while the write is in flight and only switch to the file once it
+// has succeeded. After a short write the written prefix is dropped from the
+// tail so a retry does not write it twice.
+// Caller must hold f.mu.
+func (f *File) flushTail() error {
+	if len(f.tail) == 0 {
+		return nil
+	}
+
+	n, err := f.file.Write(f.tail)
+
+	f.readMu.Lock()
+	f.flushed += int64(n)
+	if n == len(f.tail) {
+		f.tail = f.tail[:0]
+	} else {
+		f.tail = append(make([]byte, 0, cap(f.tail)), f.tail[n:]...)
+	}
+	f.readMu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to flush buffer: %w", err)
+	}
+	return nil
+}
+
+// Sync flushes buffered data and fsyncs the active file, making every
+// append so far durable. This method is thread-safe and can be called
+// concurrently.
+func (f *File) Sync() error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	return f.flushAndSync()
@@ -129,90 +471,247 @@ func (f *File) Flush() error {
 // This ensures all buffered data is persisted and updates the last sync time.
 // Returns an error if flushing or syncing fails.
 func (f *File) flushAndSync() error {
-	if err := f.buffer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush buffer: %w", err)
+	if err := f.flushTail(); err != nil {
+		return err
 	}
 
 	if err := f.file.Sync(); err != nil {
 		return fmt.Errorf("failed to sync file after flush: %w", err)
 	}
 
+	f.unsynced = false
 	f.lastSyncTime = time.Now()
 	slog.Debug("storage: buffer flushed, file synced, and last sync time updated",
 		"last_sync_time", f.lastSyncTime)
 	return nil
 }
 
-// Append writes data to the log file using a buffered writer.
-// It calculates the offset where data will be written (accounting for
-// unflushed buffer data) and automatically flushes when batch size or
-// sync interval thresholds are reached. Returns the offset where data
-// was written and any error encountered.
-// This method is thread-safe and can be called concurrently.
-func (f *File) Append(data []byte) (int64, error) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
+// shouldRotate reports whether the active file has reached the configured
+// size or age limit. An empty active file is never rotated.
+// Caller must hold f.mu.
+func (f *File) shouldRotate() bool {
+	if f.activeSize == 0 {
+		return false
+	}
+	if f.cfg.MAX_FILE_SIZE > 0 && f.activeSize >= int64(f.cfg.MAX_FILE_SIZE) {
+		return true
+	}
+	if f.cfg.MAX_FILE_AGE > 0 &&
+		time.Since(f.activeOpened) >= time.Duration(f.cfg.MAX_FILE_AGE)*time.Second {
+		return true
+	}
+	return false
+}
+
+// rotate seals the active file as a numbered immutable segment and opens a
+// fresh active file with the next file id. The sealed file keeps its id, so
+// locations already handed out for it remain valid.
+// Caller must hold f.mu.
+func (f *File) rotate() error {
+	if err := f.flushAndSync(); err != nil {
+		return fmt.Errorf("failed to flush before rotation: %w", err)
+	}
 
-	fileSize, err := f.file.Seek(0, io.SeekEnd)
+	activePath := filepath.Join(f.cfg.DATA_DIR, ActiveFileName)
+	segmentPath := filepath.Join(f.cfg.DATA_DIR, SegmentFileName(f.activeId))
+	if err := os.Rename(activePath, segmentPath); err != nil {
+		return fmt.Errorf("failed to seal active file as %s: %w", segmentPath, err)
+	}
+
+	slog.Info("storage: active file rotated",
+		"file_id", f.activeId,
+		"path", segmentPath,
+		"size", f.activeSize)
+
+	// The open handle follows the rename, so it can keep serving reads
+	f.readMu.Lock()
+	sealedId := f.activeId
+	f.sealed[sealedId] = f.file
+	f.mapSegment(sealedId, f.file)
+	f.activeId++
+	err := f.openActive()
+	f.readMu.Unlock()
 	if err != nil {
-		return 0, fmt.Errorf("failed to seek to end of file: %w", err)
+		return err
 	}
 
-	// Calculate the actual offset where data will be written
-	// This accounts for any unflushed data in the buffer
-	bufferSize := int64(f.buffer.Buffered())
-	offset := fileSize + bufferSize
+	if f.onSeal != nil {
+		f.onSeal(sealedId)
+	}
+	return nil
+}
+
+// SetSealHandler registers a function called with the id of every segment
+// sealed by rotation. It runs while the storage lock is held, so it must not
+// call back into the File and should hand any real work off to a goroutine.
+func (f *File) SetSealHandler(handler func(fileId uint32)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onSeal = handler
+}
+
+// Append adds data to the tail buffer of the active log file.
+// The active file is rotated first if it has reached its size or age limit,
+// so data passed in a single call never spans two segments. Buffered data is
+// written out once it reaches BATCH_SIZE, and under SyncPeriodic the file is
+// fsynced once SYNC_INTERVAL has passed since the last sync. Other modes
+// leave syncing to the caller. Returns the file id and offset where data was
+// written and any error encountered. If writing out the buffer fails, data
+// is discarded again, so a failed append never reaches the log; earlier
+// appends stay buffered for the next flush.
+// This method is thread-safe and can be called concurrently.
+func (f *File) Append(data []byte) (uint32, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.append(data)
+}
+
+// AppendSync is like Append but also writes out and fsyncs the active file
+// before returning, so data is durable once it returns. If the flush or the
+// fsync fails, data is discarded again as with a failed Append, so it never
+// reappears in the log after a restart.
+// This method is thread-safe and can be called concurrently.
+func (f *File) AppendSync(data []byte) (uint32, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	bytesWritten, err := f.buffer.Write(data)
+	fileId, offset, err := f.append(data)
 	if err != nil {
-		return 0, fmt.Errorf("failed to write data to buffer at offset %d: %w", offset, err)
+		return 0, 0, err
+	}
+	if err := f.flushAndSync(); err != nil {
+		return 0, 0, f.rollbackAppend(offset, fmt.Errorf("failed to sync after append: %w", err))
 	}
+	return fileId, offset, nil
+}
 
-	if bytesWritten != len(data) {
-		slog.Warn("storage: partial buffer write detected",
-			"expected", len(data),
-			"written", bytesWritten,
-			"offset", offset)
+// append implements Append.
+// Caller must hold f.mu.
+func (f *File) append(data []byte) (uint32, int64, error) {
+	if f.failed != nil {
+		return 0, 0, f.failed
 	}
+	if f.shouldRotate() {
+		if err := f.rotate(); err != nil {
+			return 0, 0, fmt.Errorf("failed to rotate active file: %w", err)
+		}
+	}
+
+	// activeSize accounts for any unflushed data in the tail
+	offset := f.activeSize
+
+	f.readMu.Lock()
+	f.tail = append(f.tail, data...)
+	f.readMu.Unlock()
+	f.activeSize += int64(len(data))
+	f.unsynced = true
 
-	// Auto-flush if batch size or sync interval threshold reached
-	if int64(f.buffer.Size()) >= int64(f.cfg.BATCH_SIZE) ||
+	if f.syncMode == SyncPeriodic &&
 		time.Since(f.lastSyncTime) >= time.Duration(f.cfg.SYNC_INTERVAL)*time.Second {
-		slog.Debug("storage: batch size or sync interval reached, flushing buffer and syncing file",
-			"buffer_size", f.buffer.Size(),
-			"batch_size", f.cfg.BATCH_SIZE,
+		slog.Debug("storage: sync interval reached, flushing buffer and syncing file",
+			"buffered", len(f.tail),
 			"sync_interval", f.cfg.SYNC_INTERVAL,
 			"since_last_sync", time.Since(f.lastSyncTime),
 		)
 		if err := f.flushAndSync(); err != nil {
-			return 0, fmt.Errorf("failed to flush after append: %w", err)
+			return 0, 0, f.rollbackAppend(offset, fmt.Errorf("failed to sync after append: %w", err))
+		}
+	} else if int64(len(f.tail)) >= int64(f.cfg.BATCH_SIZE) {
+		if err := f.flushTail(); err != nil {
+			return 0, 0, f.rollbackAppend(offset, fmt.Errorf("failed to flush after append: %w", err))
+		}
+	}
+	return f.activeId, offset, nil
+}
+
+// rollbackAppend discards everything appended to the active file from
+// offset on after writing it out failed with cause, and returns cause.
+// Bytes still in the tail are dropped and bytes already written are
+// truncated away. If the truncation fails the file is marked failed, since
+// a later flush could no longer tell where the log ends, and the returned
+// error wraps ErrFailed.
+// Caller must hold f.mu.
+func (f *File) rollbackAppend(offset int64, cause error) error {
+	f.readMu.Lock()
+	defer f.readMu.Unlock()
+
+	if f.flushed > offset {
+		if err := f.file.Truncate(offset); err != nil {
+			f.failed = fmt.Errorf("%w: %v, and failed to discard it: %v", ErrFailed, cause, err)
+			f.flushStatus.Failed = f.failed
+			slog.Error("storage: failed to discard failed append, refusing further appends",
+				"file_id", f.activeId,
+				"offset", offset,
+				"error", err)
+			return f.failed
 		}
+		f.flushed = offset
 	}
-	return offset, nil
+	f.tail = f.tail[:offset-f.flushed]
+	f.activeSize = offset
+
+	slog.Warn("storage: failed append discarded",
+		"file_id", f.activeId,
+		"offset", offset,
+		"error", cause)
+	return cause
 }
 
-// ReadAt reads data from the file at the specified offset.
-// The size parameter specifies how many bytes to read.
+// ReadAt reads data from the given log file at the specified offset.
+// The size parameter specifies how many bytes to read. Flushed bytes are
+// read positionally and bytes still in the tail buffer are copied from
+// memory, so reads never wait for appends, flushes or fsyncs.
 // Returns the read data and any error encountered.
 // This method is thread-safe and can be called concurrently.
-func (f *File) ReadAt(offset int64, size uint32) ([]byte, error) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
+func (f *File) ReadAt(fileId uint32, offset int64, size uint32) ([]byte, error) {
+	f.readMu.RLock()
+	defer f.readMu.RUnlock()
 
 	slog.Debug("storage: reading data from file",
+		"file_id", fileId,
 		"offset", offset,
 		"size", size)
 
 	data := make([]byte, size)
-	bytesRead, err := f.file.ReadAt(data, offset)
+	handle := f.file
+	onDisk := data
+	if fileId == f.activeId {
+		// The part of the range past flushed is still in the tail
+		if end := offset + int64(size); end > f.flushed {
+			split := max(f.flushed-offset, 0)
+			if tailStart := offset + split - f.flushed; tailStart < int64(len(f.tail)) {
+				copied := copy(data[split:], f.tail[tailStart:])
+				if copied != len(data)-int(split) {
+					slog.Warn("storage: partial read detected",
+						"expected", size,
+						"read", int(split)+copied,
+						"file_id", fileId,
+						"offset", offset)
+				}
+			}
+			onDisk = data[:split]
+		}
+	} else {
+		segment, ok := f.sealed[fileId]
+		if !ok {
+			return nil, fmt.Errorf("segment %d not found", fileId)
+		}
+		handle = segment
+	}
+	if len(onDisk) == 0 {
+		return data, nil
+	}
+
+	bytesRead, err := handle.ReadAt(onDisk, offset)
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read data from file at offset %d: %w", offset, err)
+		return nil, fmt.Errorf("failed to read data from file %d at offset %d: %w", fileId, offset, err)
 	}
 
-	if bytesRead != int(size) && err != io.EOF {
+	if bytesRead != len(onDisk) {
 		slog.Warn("storage: partial read detected",
-			"expected", size,
+			"expected", len(onDisk),
 			"read", bytesRead,
+			"file_id", fileId,
 			"offset", offset)
 	}
 
@@ -220,23 +719,37 @@ func (f *File) ReadAt(offset int64, size uint32) ([]byte, error) {
 }
 
 // Close gracefully closes the file, flushing any remaining buffered data
-// before closing the underlying file handle. Returns an error if closing fails.
-// This method is thread-safe and should only be called once.
+// before closing the active and sealed file handles, and releases the lock
+// on the data directory. Returns an error if closing fails. This method is
+// thread-safe and should only be called once.
 func (f *File) Close() error {
+	close(f.stop)
+	f.flusherWg.Wait()
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	slog.Debug("storage: closing file handler")
 
-	// Flush any remaining data in the buffer before closing
-	if f.buffer != nil {
-		if err := f.flushAndSync(); err != nil {
-			slog.Error("storage: failed to flush buffer before close",
-				"error", err)
-			// Continue to close the file even if flush fails
-		}
+	// An append racing with Close must fail rather than buffer data that
+	// can never reach disk
+	f.failed = fmt.Errorf("%w: file closed", ErrFailed)
+
+	// Flush any remaining data in the tail before closing
+	if err := f.flushAndSync(); err != nil {
+		slog.Error("storage: failed to flush buffer before close",
+			"error", err)
+		// Continue to close the file even if flush fails
 	}
 
+	// Wait for reads in flight before their handles are closed
+	f.readMu.Lock()
+	defer f.readMu.Unlock()
+
+	f.closeSegments()
+
+	// The lock goes last, once nothing more can be written
+	defer f.lock.Close()
 	if err := f.file.Close(); err != nil {
 		return fmt.Errorf("failed to close file: %w", err)
 	}
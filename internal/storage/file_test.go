@@ -2,9 +2,14 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jassi-singh/aether-kv/internal/config"
 )
@@ -89,7 +94,7 @@ func TestFile_Append(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			offset, err := file.Append(tt.data)
+			_, offset, err := file.Append(tt.data)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("File.Append() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -111,7 +116,7 @@ func TestFile_ReadAt(t *testing.T) {
 
 	// Write some data first
 	testData := []byte("test data for reading")
-	offset, err := file.Append(testData)
+	fileId, offset, err := file.Append(testData)
 	if err != nil {
 		t.Fatalf("Failed to append data: %v", err)
 	}
@@ -143,7 +148,7 @@ func TestFile_ReadAt(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			data, err := file.ReadAt(tt.offset, tt.size)
+			data, err := file.ReadAt(fileId, tt.offset, tt.size)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("File.ReadAt() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -163,7 +168,7 @@ func TestFile_Close(t *testing.T) {
 	}
 
 	// Write some data
-	if _, err := file.Append([]byte("test")); err != nil {
+	if _, _, err := file.Append([]byte("test")); err != nil {
 		t.Fatalf("Failed to append: %v", err)
 	}
 
@@ -171,6 +176,9 @@ func TestFile_Close(t *testing.T) {
 	if err := file.Close(); err != nil {
 		t.Errorf("File.Close() error = %v", err)
 	}
+	if _, _, err := file.Append([]byte("late")); !errors.Is(err, ErrFailed) {
+		t.Errorf("File.Append() after Close() error = %v, want %v", err, ErrFailed)
+	}
 
 	// Verify file exists
 	filePath := filepath.Join(cfg.DATA_DIR, "active.log")
@@ -179,6 +187,31 @@ func TestFile_Close(t *testing.T) {
 	}
 }
 
+func TestNewFile_Locked(t *testing.T) {
+	cfg := setupTestConfig(t)
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// A second opener fails fast while the first holds the directory
+	if second, err := NewFile(cfg); !errors.Is(err, ErrLocked) {
+		if second != nil {
+			second.Close()
+		}
+		t.Fatalf("NewFile() of a locked directory error = %v, want %v", err, ErrLocked)
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("File.Close() error = %v", err)
+	}
+	reopened, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("NewFile() after Close() error = %v", err)
+	}
+	reopened.Close()
+}
+
 func TestFile_Flush(t *testing.T) {
 	cfg := setupTestConfig(t)
 	file, err := NewFile(cfg)
@@ -188,7 +221,7 @@ func TestFile_Flush(t *testing.T) {
 	defer file.Close()
 
 	// Write some data
-	if _, err := file.Append([]byte("test data")); err != nil {
+	if _, _, err := file.Append([]byte("test data")); err != nil {
 		t.Fatalf("Failed to append: %v", err)
 	}
 
@@ -197,3 +230,419 @@ func TestFile_Flush(t *testing.T) {
 		t.Errorf("File.Flush() error = %v", err)
 	}
 }
+
+func TestFile_OpenActive(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 16
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	// Still in the tail buffer, so it must be flushed first
+	if _, _, err := file.Append([]byte("first chunk of data")); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	handle, fileId, size, err := file.OpenActive()
+	if err != nil {
+		t.Fatalf("File.OpenActive() error = %v", err)
+	}
+	defer handle.Close()
+	if fileId != file.ActiveFileId() || size != int64(len("first chunk of data")) {
+		t.Errorf("File.OpenActive() = file %d size %d, want file %d size %d", fileId, size, file.ActiveFileId(), len("first chunk of data"))
+	}
+
+	// The handle still reads the file once rotation has sealed it
+	if _, _, err := file.Append([]byte("second chunk of data")); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if file.ActiveFileId() == fileId {
+		t.Fatalf("active file %d was not rotated", fileId)
+	}
+	data := make([]byte, size)
+	if _, err := handle.ReadAt(data, 0); err != nil || string(data) != "first chunk of data" {
+		t.Errorf("ReadAt() after rotation = %q, %v, want first chunk of data", data, err)
+	}
+}
+
+func TestLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"000000010.log", ActiveFileName, "000000002.log", "000000002.hint", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	names, err := LogFiles(dir)
+	if err != nil {
+		t.Fatalf("LogFiles() error = %v", err)
+	}
+	want := []string{"000000002.log", "000000010.log", ActiveFileName}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("LogFiles() = %v, want %v", names, want)
+	}
+}
+
+func TestFile_Rotation(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 16
+
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// Each append fills the active file past MAX_FILE_SIZE, so the next
+	// append must land in a new segment.
+	chunks := [][]byte{
+		[]byte("first chunk of data"),
+		[]byte("second chunk of data"),
+		[]byte("third chunk of data"),
+	}
+	fileIds := make([]uint32, len(chunks))
+	offsets := make([]int64, len(chunks))
+	for i, chunk := range chunks {
+		fileIds[i], offsets[i], err = file.Append(chunk)
+		if err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+		if offsets[i] != 0 {
+			t.Errorf("File.Append() offset = %d, want 0 in a fresh segment", offsets[i])
+		}
+		if i > 0 && fileIds[i] != fileIds[i-1]+1 {
+			t.Errorf("File.Append() file id = %d, want %d", fileIds[i], fileIds[i-1]+1)
+		}
+	}
+
+	for i, chunk := range chunks {
+		if err := file.Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+		data, err := file.ReadAt(fileIds[i], offsets[i], uint32(len(chunk)))
+		if err != nil {
+			t.Fatalf("File.ReadAt() error = %v", err)
+		}
+		if string(data) != string(chunk) {
+			t.Errorf("File.ReadAt() = %q, want %q", data, chunk)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	for _, fileId := range fileIds[:len(fileIds)-1] {
+		path := filepath.Join(cfg.DATA_DIR, SegmentFileName(fileId))
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("sealed segment %s missing: %v", path, err)
+		}
+	}
+
+	// Reopening must pick up the sealed segments and continue after them
+	reopened, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen file: %v", err)
+	}
+	defer reopened.Close()
+
+	segments := reopened.Segments()
+	if len(segments) != len(chunks) {
+		t.Fatalf("Segments() = %v, want %d entries", segments, len(chunks))
+	}
+	if active := reopened.ActiveFileId(); active != fileIds[len(fileIds)-1] {
+		t.Errorf("ActiveFileId() = %d, want %d", active, fileIds[len(fileIds)-1])
+	}
+}
+
+func TestFile_AppendBuffersUntilBatchSize(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.BATCH_SIZE = 64
+	cfg.SYNC_MODE = "none"
+
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	onDisk := func() int64 {
+		t.Helper()
+		stat, err := os.Stat(filepath.Join(cfg.DATA_DIR, ActiveFileName))
+		if err != nil {
+			t.Fatalf("Failed to stat active file: %v", err)
+		}
+		return stat.Size()
+	}
+
+	if _, _, err := file.Append(make([]byte, 40)); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if size := onDisk(); size != 0 {
+		t.Errorf("active file holds %d bytes below BATCH_SIZE, want 0", size)
+	}
+	// Sizing the log counts buffered appends without writing them out
+	if size, err := file.DiskSize(); err != nil || size != 40 {
+		t.Errorf("File.DiskSize() = %d, %v, want 40", size, err)
+	}
+	if size := onDisk(); size != 0 {
+		t.Errorf("active file holds %d bytes after DiskSize, want 0", size)
+	}
+	if _, err := file.SegmentSize(file.ActiveFileId()); err == nil {
+		t.Errorf("File.SegmentSize() of the active file succeeded, want error")
+	}
+	if _, _, err := file.Append(make([]byte, 40)); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if size := onDisk(); size < 64 {
+		t.Errorf("active file holds %d bytes after passing BATCH_SIZE, want at least 64", size)
+	}
+
+	if err := file.Sync(); err != nil {
+		t.Fatalf("File.Sync() error = %v", err)
+	}
+	if size := onDisk(); size != 80 {
+		t.Errorf("active file holds %d bytes after Sync, want 80", size)
+	}
+}
+
+func TestFile_AppendDiscardedOnFlushFailure(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.BATCH_SIZE = 8
+	cfg.SYNC_MODE = "none"
+
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	if _, _, err := file.Append([]byte("aaaa")); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+
+	// Writes fail while the active file is only open for reading
+	path := filepath.Join(cfg.DATA_DIR, ActiveFileName)
+	writable := file.file
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open active file: %v", err)
+	}
+	file.file = readOnly
+	if _, _, err := file.Append([]byte("bbbbbbbb")); err == nil {
+		t.Fatalf("Append() with a failing flush error = nil, want an error")
+	}
+	if file.activeSize != 4 || string(file.tail) != "aaaa" {
+		t.Errorf("after a failed append size = %d, tail = %q, want 4 and the earlier append", file.activeSize, file.tail)
+	}
+	if _, _, err := file.AppendSync([]byte("bb")); err == nil {
+		t.Fatalf("AppendSync() with a failing flush error = nil, want an error")
+	}
+	if file.activeSize != 4 || string(file.tail) != "aaaa" {
+		t.Errorf("after a failed AppendSync size = %d, tail = %q, want 4 and the earlier append", file.activeSize, file.tail)
+	}
+	file.file = writable
+	readOnly.Close()
+
+	// The failed append never reaches the file, and later ones take its place
+	fileId, offset, err := file.Append([]byte("cccc"))
+	if err != nil || offset != 4 {
+		t.Fatalf("Append() = %d, %v, want offset 4", offset, err)
+	}
+	if err := file.Sync(); err != nil {
+		t.Fatalf("File.Sync() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "aaaacccc" {
+		t.Errorf("active file = %q, %v, want aaaacccc", data, err)
+	}
+	if got, err := file.ReadAt(fileId, 4, 4); err != nil || string(got) != "cccc" {
+		t.Errorf("ReadAt() = %q, %v, want cccc", got, err)
+	}
+}
+
+func TestParseSyncMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    SyncMode
+		wantErr bool
+	}{
+		{value: "", want: SyncPeriodic},
+		{value: "always", want: SyncAlways},
+		{value: "batch", want: SyncBatch},
+		{value: "periodic", want: SyncPeriodic},
+		{value: "none", want: SyncNone},
+		{value: "ALWAYS", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSyncMode(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSyncMode(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+		if !tt.wantErr && tt.value != "" && got.String() != tt.value {
+			t.Errorf("%v.String() = %q, want %q", got, got.String(), tt.value)
+		}
+	}
+}
+
+func TestFile_BackgroundFlusher(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.SYNC_INTERVAL = 1
+
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+
+	// waitFor polls cond until it holds or the flusher has had ample time
+	waitFor := func(cond func() bool) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			if cond() {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+
+	// An idle append is synced without another write arriving
+	if _, _, err := file.Append([]byte("idle data")); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	synced := waitFor(func() bool {
+		stat, err := os.Stat(filepath.Join(cfg.DATA_DIR, ActiveFileName))
+		return err == nil && stat.Size() == int64(len("idle data"))
+	})
+	if !synced {
+		t.Error("background flusher did not write out idle data")
+	}
+
+	// Flush failures are recorded rather than lost
+	file.GetFile().Close()
+	if _, _, err := file.Append([]byte("doomed")); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	failed := waitFor(func() bool {
+		status := file.FlushStatus()
+		return status.Failures > 0 && status.LastError != nil
+	})
+	if !failed {
+		t.Errorf("FlushStatus() = %+v, want a recorded failure", file.FlushStatus())
+	}
+
+	if err := file.Close(); err == nil {
+		t.Error("File.Close() with closed active file succeeded, want error")
+	}
+}
+
+func TestFile_ReadFromTail(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.BATCH_SIZE = 32
+
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	// The first record is flushed once the second pushes the tail past
+	// BATCH_SIZE; the third stays in the tail
+	records := [][]byte{
+		[]byte("first record"),
+		[]byte("second record, long enough to flush"),
+		[]byte("third record"),
+	}
+	offsets := make([]int64, len(records))
+	for i, record := range records {
+		if _, offsets[i], err = file.Append(record); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+
+	stat, err := os.Stat(filepath.Join(cfg.DATA_DIR, ActiveFileName))
+	if err != nil {
+		t.Fatalf("Failed to stat active file: %v", err)
+	}
+	if stat.Size() != offsets[2] {
+		t.Fatalf("active file holds %d bytes, want %d with the last record in the tail", stat.Size(), offsets[2])
+	}
+
+	for i, record := range records {
+		data, err := file.ReadAt(0, offsets[i], uint32(len(record)))
+		if err != nil || !bytes.Equal(data, record) {
+			t.Errorf("File.ReadAt(record %d) = %q, %v, want %q", i, data, err, record)
+		}
+	}
+
+	// A range spanning the file and the tail is stitched together
+	data, err := file.ReadAt(0, offsets[1], uint32(len(records[1])+len(records[2])))
+	if want := append(append([]byte{}, records[1]...), records[2]...); err != nil || !bytes.Equal(data, want) {
+		t.Errorf("File.ReadAt(across tail) = %q, %v, want %q", data, err, want)
+	}
+
+	// Reads do not flush the tail
+	if stat, _ := os.Stat(filepath.Join(cfg.DATA_DIR, ActiveFileName)); stat.Size() != offsets[2] {
+		t.Errorf("active file holds %d bytes after reads, want %d", stat.Size(), offsets[2])
+	}
+}
+
+func TestFile_ConcurrentReadsAndAppends(t *testing.T) {
+	cfg := setupTestConfig(t)
+	cfg.BATCH_SIZE = 256
+	cfg.MAX_FILE_SIZE = 4096
+
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	type location struct {
+		fileId uint32
+		offset int64
+		data   []byte
+	}
+	var mu sync.Mutex
+	var written []location
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 500; i++ {
+			data := []byte(fmt.Sprintf("record-%04d", i))
+			fileId, offset, err := file.Append(data)
+			if err != nil {
+				t.Errorf("Failed to append: %v", err)
+				return
+			}
+			mu.Lock()
+			written = append(written, location{fileId: fileId, offset: offset, data: data})
+			mu.Unlock()
+		}
+	}()
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				mu.Lock()
+				if len(written) == 0 {
+					mu.Unlock()
+					continue
+				}
+				loc := written[(i*7+r)%len(written)]
+				mu.Unlock()
+
+				data, err := file.ReadAt(loc.fileId, loc.offset, uint32(len(loc.data)))
+				if err != nil || !bytes.Equal(data, loc.data) {
+					t.Errorf("File.ReadAt(%d, %d) = %q, %v, want %q", loc.fileId, loc.offset, data, err, loc.data)
+					return
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+}
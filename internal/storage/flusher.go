@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"log/slog"
+	"time"
+)
+
+// runFlusher writes out buffered appends every interval until Close, so
+// data written just before a quiet period is not left in the buffer. Under
+// SyncPeriodic the file is fsynced as well; under SyncNone the data is only
+// handed to the operating system.
+func (f *File) runFlusher(interval time.Duration) {
+	defer f.flusherWg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.backgroundFlush(interval)
+		}
+	}
+}
+
+// backgroundFlush flushes the buffer, and syncs the file under SyncPeriodic
+// unless a sync already happened within the last interval. The outcome is
+// recorded for FlushStatus.
+func (f *File) backgroundFlush(interval time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	switch {
+	case f.syncMode == SyncPeriodic && f.unsynced && time.Since(f.lastSyncTime) >= interval:
+		err = f.flushAndSync()
+	case len(f.tail) > 0:
+		err = f.flushTail()
+	}
+
+	if err != nil {
+		f.flushStatus.Failures++
+		slog.Error("storage: background flush failed",
+			"file_id", f.activeId,
+			"failures", f.flushStatus.Failures,
+			"error", err)
+	}
+	f.flushStatus.LastError = err
+}
+
+// FlushStatus returns the outcome of the background flushes so far.
+func (f *File) FlushStatus() FlushStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.flushStatus
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// HintExt is the file extension used by the hint file of a sealed segment.
+const HintExt = ".hint"
+
+// HintFileName returns the file name used for the hint file of the sealed
+// segment with the given file id.
+func HintFileName(fileId uint32) string {
+	return fmt.Sprintf("%09d%s", fileId, HintExt)
+}
+
+// WriteHint atomically replaces the hint file of a sealed segment with data.
+// The file is written under a temporary name and renamed into place, so a
+// crash never leaves a partially written hint behind.
+func (f *File) WriteHint(fileId uint32, data []byte) error {
+	f.mu.Lock()
+	_, ok := f.sealed[fileId]
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("segment %d is not sealed", fileId)
+	}
+
+	return writeHintFile(f.cfg.DATA_DIR, fileId, data)
+}
+
+// ReadHint returns the contents of the hint file of the given segment.
+// The returned error satisfies os.IsNotExist if the segment has no hint.
+func (f *File) ReadHint(fileId uint32) ([]byte, error) {
+	return os.ReadFile(filepath.Join(f.cfg.DATA_DIR, HintFileName(fileId)))
+}
+
+// SegmentSize returns the size of the given sealed segment. Sealed
+// segments never change, so neither appends nor buffered writes are
+// waited for.
+func (f *File) SegmentSize(fileId uint32) (int64, error) {
+	f.readMu.RLock()
+	defer f.readMu.RUnlock()
+
+	segment, ok := f.sealed[fileId]
+	if !ok {
+		return 0, fmt.Errorf("segment %d is not sealed", fileId)
+	}
+	stat, err := segment.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get stats for segment %d: %w", fileId, err)
+	}
+	return stat.Size(), nil
+}
+
+// DiskSize returns the total size of every log file. The active file counts
+// the appends still buffered in the tail, which are not flushed for this,
+// so asking for the size never forces a write-out.
+func (f *File) DiskSize() (int64, error) {
+	f.mu.Lock()
+	total := f.activeSize
+	f.mu.Unlock()
+
+	f.readMu.RLock()
+	defer f.readMu.RUnlock()
+	for id, segment := range f.sealed {
+		stat, err := segment.Stat()
+		if err != nil {
+			return 0, fmt.Errorf("failed to get stats for segment %d: %w", id, err)
+		}
+		total += stat.Size()
+	}
+	return total, nil
+}
+
+// WriteHint writes the hint file of an output segment into the merge
+// directory, to be installed alongside the segment when the merge commits.
+func (w *MergeWriter) WriteHint(fileId uint32, data []byte) error {
+	return writeHintFile(w.dir, fileId, data)
+}
+
+// writeHintFile writes a hint file into dir via a synced temporary file.
+func writeHintFile(dir string, fileId uint32, data []byte) error {
+	path := filepath.Join(dir, HintFileName(fileId))
+	tmpPath := path + ".tmp"
+	if err := writeFileSync(tmpPath, data); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write hint file %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to install hint file %s: %w", path, err)
+	}
+	return nil
+}
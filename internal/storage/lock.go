@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LockFileName is the file in the data directory that a File holds an
+// exclusive lock on while it is open.
+const LockFileName = "LOCK"
+
+// ErrLocked is returned by NewFile when another File, in this process or
+// another one, has the data directory open.
+var ErrLocked = errors.New("data directory is locked by another process")
+
+// lockDir takes an exclusive lock on the LOCK file in dir, creating it if
+// needed, without waiting. The lock is held until the returned file is
+// closed, which the operating system also does if the process dies, so a
+// crash never leaves the directory locked. Returns an error wrapping
+// ErrLocked if the lock is held elsewhere.
+func lockDir(dir string) (*os.File, error) {
+	path := filepath.Join(dir, LockFileName)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, errWouldBlock) {
+			return nil, fmt.Errorf("%s: %w", dir, ErrLocked)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return file, nil
+}
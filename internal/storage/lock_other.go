@@ -0,0 +1,17 @@
+//go:build !unix
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// errWouldBlock is never returned on platforms without flock.
+var errWouldBlock = errors.New("lock held")
+
+// lockFile does nothing on platforms without flock, so the data directory
+// is not protected against a second opener there.
+func lockFile(file *os.File) error {
+	return nil
+}
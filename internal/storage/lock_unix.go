@@ -0,0 +1,18 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// errWouldBlock is returned by lockFile when the lock is held elsewhere.
+var errWouldBlock = syscall.EWOULDBLOCK
+
+// lockFile takes an exclusive flock on file without waiting. Locks belong
+// to the open file, so a second open of the same directory conflicts even
+// within one process.
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MergeDirName is the subdirectory of DATA_DIR that merge output is
+	// written to before it is swapped in.
+	MergeDirName = "merge"
+	// mergeDoneFileName marks a merge directory whose output is complete.
+	// Each line is "keep <id>" for an output segment to install or
+	// "drop <id>" for an input segment that received no output.
+	mergeDoneFileName = "MERGED"
+)
+
+// MergeWriter writes the live records of a merge into fresh segment files
+// inside the merge directory. Output segments reuse the ids of the input
+// segments in ascending order, so merged data keeps sorting before every
+// segment written after the merge started. It is not safe for concurrent use.
+type MergeWriter struct {
+	dir     string
+	inputs  []uint32 // Input segment ids, ascending; output ids are drawn from these
+	next    int      // Index into inputs of the next output id to use
+	file    *os.File // Output segment currently being written
+	buffer  *bufio.Writer
+	size    int64    // Bytes written to the current output segment
+	outputs []uint32 // Ids of the output segments written so far
+	maxSize int64    // MAX_FILE_SIZE at which a new output segment is started
+}
+
+// NewMergeWriter prepares an empty merge directory and returns a writer whose
+// output will replace the given sealed segments. The ids must be sorted in
+// ascending order and must all belong to sealed segments.
+func (f *File) NewMergeWriter(inputs []uint32) (*MergeWriter, error) {
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("merge requires at least one input segment")
+	}
+
+	f.mu.Lock()
+	for _, id := range inputs {
+		if _, ok := f.sealed[id]; !ok {
+			f.mu.Unlock()
+			return nil, fmt.Errorf("segment %d is not sealed", id)
+		}
+	}
+	f.mu.Unlock()
+
+	dir := filepath.Join(f.cfg.DATA_DIR, MergeDirName)
+	if _, err := os.Stat(filepath.Join(dir, mergeDoneFileName)); err == nil {
+		return nil, fmt.Errorf("a committed merge in %s has not been installed, reopen the store to finish it", dir)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clear merge directory %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create merge directory %s: %w", dir, err)
+	}
+
+	return &MergeWriter{
+		dir:     dir,
+		inputs:  append([]uint32(nil), inputs...),
+		maxSize: int64(f.cfg.MAX_FILE_SIZE),
+	}, nil
+}
+
+// Append writes data to the current output segment, starting a new one first
+// if the current segment has reached MAX_FILE_SIZE and unused input ids
+// remain. The last output segment absorbs any overflow so the merge never
+// produces more segments than it consumes. Returns the file id and offset
+// where data was written.
+func (w *MergeWriter) Append(data []byte) (uint32, int64, error) {
+	if w.file == nil || (w.maxSize > 0 && w.size >= w.maxSize && w.next < len(w.inputs)) {
+		if err := w.openNext(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	fileId := w.outputs[len(w.outputs)-1]
+	offset := w.size
+	if _, err := w.buffer.Write(data); err != nil {
+		return 0, 0, fmt.Errorf("failed to write merge output for segment %d: %w", fileId, err)
+	}
+	w.size += int64(len(data))
+	return fileId, offset, nil
+}
+
+// Room returns the bytes that can still be appended before the current
+// output segment reaches MAX_FILE_SIZE, or -1 if there is no limit because
+// MAX_FILE_SIZE is unset or the last output segment absorbs the overflow.
+// The next Append starts a new segment when the current one is full, so
+// Room then returns the size of a whole segment.
+func (w *MergeWriter) Room() int64 {
+	if w.maxSize <= 0 {
+		return -1
+	}
+	if w.file == nil || w.size >= w.maxSize {
+		if w.next >= len(w.inputs) && w.file != nil {
+			return -1
+		}
+		return w.maxSize
+	}
+	return w.maxSize - w.size
+}
+
+// openNext finishes the current output segment and opens the next one.
+func (w *MergeWriter) openNext() error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+
+	fileId := w.inputs[w.next]
+	path := filepath.Join(w.dir, SegmentFileName(fileId))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create merge output %s: %w", path, err)
+	}
+
+	w.next++
+	w.file = file
+	w.buffer = bufio.NewWriter(file)
+	w.size = 0
+	w.outputs = append(w.outputs, fileId)
+	return nil
+}
+
+// finish flushes, syncs and closes the current output segment, if any.
+func (w *MergeWriter) finish() error {
+	if w.file == nil {
+		return nil
+	}
+	defer func() { w.file = nil }()
+
+	if err := w.buffer.Flush(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to flush merge output: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to sync merge output: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close merge output: %w", err)
+	}
+	return nil
+}
+
+// Outputs returns the ids of the output segments written so far.
+func (w *MergeWriter) Outputs() []uint32 {
+	return w.outputs
+}
+
+// Abort discards the output of a merge that has not been committed. It must
+// not be called once CommitMerge has been attempted, since a written done
+// marker means the output has to be installed.
+func (w *MergeWriter) Abort() {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	if err := os.RemoveAll(w.dir); err != nil {
+		slog.Error("storage: failed to remove merge directory",
+			"path", w.dir,
+			"error", err)
+	}
+}
+
+// CommitMerge makes the output of a merge durable and swaps it in for the
+// input segments. Once the done marker is written the merge is guaranteed to
+// complete, either here or by NewFile after a crash. Outputs are installed
+// in ascending id order before unused inputs are removed, so the data
+// directory is consistent at every step. Reads of the input segments must
+// not be in flight while this runs, since their handles are replaced.
+func (f *File) CommitMerge(w *MergeWriter) error {
+	if err := w.finish(); err != nil {
+		return err
+	}
+
+	var marker strings.Builder
+	for i, id := range w.inputs {
+		action := "drop"
+		if i < len(w.outputs) {
+			action = "keep"
+		}
+		fmt.Fprintf(&marker, "%s %d\n", action, id)
+	}
+	if err := writeFileSync(filepath.Join(w.dir, mergeDoneFileName), []byte(marker.String())); err != nil {
+		return fmt.Errorf("failed to write merge marker: %w", err)
+	}
+	// The marker and the outputs it names must survive a crash before any
+	// of them leaves the merge directory
+	if err := syncDir(w.dir); err != nil {
+		return fmt.Errorf("failed to sync merge directory %s: %w", w.dir, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readMu.Lock()
+	defer f.readMu.Unlock()
+
+	for _, id := range w.inputs {
+		f.unmapSegment(id)
+		if segment, ok := f.sealed[id]; ok {
+			if err := segment.Close(); err != nil {
+				slog.Warn("storage: failed to close merged segment",
+					"file_id", id,
+					"error", err)
+			}
+			delete(f.sealed, id)
+		}
+	}
+
+	if err := installMerge(f.cfg.DATA_DIR); err != nil {
+		return err
+	}
+
+	for _, id := range w.outputs {
+		path := filepath.Join(f.cfg.DATA_DIR, SegmentFileName(id))
+		segment, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open merged segment %s: %w", path, err)
+		}
+		f.sealed[id] = segment
+		f.mapSegment(id, segment)
+	}
+
+	slog.Info("storage: merge committed",
+		"inputs", len(w.inputs),
+		"outputs", len(w.outputs))
+	return nil
+}
+
+// recoverMerge finishes a merge interrupted after its done marker was
+// written, or discards the partial output of one interrupted before.
+func recoverMerge(dataDir string) error {
+	dir := filepath.Join(dataDir, MergeDirName)
+	if _, err := os.Stat(filepath.Join(dir, mergeDoneFileName)); err == nil {
+		slog.Warn("storage: completing interrupted merge",
+			"path", dir)
+		return installMerge(dataDir)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove incomplete merge directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// installMerge moves completed merge output and its hint files into the
+// data directory, removes input segments that received no output together
+// with their hints, syncs the data directory and deletes the merge
+// directory. Every step is idempotent so it can be resumed after a crash.
+func installMerge(dataDir string) error {
+	dir := filepath.Join(dataDir, MergeDirName)
+	markerPath := filepath.Join(dir, mergeDoneFileName)
+
+	marker, err := os.ReadFile(markerPath)
+	if err != nil {
+		return fmt.Errorf("failed to read merge marker: %w", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(marker)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid merge marker entry %q", line)
+		}
+		id, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid merge marker entry %q: %w", line, err)
+		}
+
+		fileId := uint32(id)
+		dst := filepath.Join(dataDir, SegmentFileName(fileId))
+		hintDst := filepath.Join(dataDir, HintFileName(fileId))
+		switch fields[0] {
+		case "keep":
+			// The output may already have been installed before a crash.
+			// The old hint goes first so it never describes the new segment.
+			src := filepath.Join(dir, SegmentFileName(fileId))
+			if _, err := os.Stat(src); err == nil {
+				if err := os.Remove(hintDst); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove stale hint file %s: %w", hintDst, err)
+				}
+				if err := os.Rename(src, dst); err != nil {
+					return fmt.Errorf("failed to install merged segment %s: %w", dst, err)
+				}
+			}
+			hintSrc := filepath.Join(dir, HintFileName(fileId))
+			if _, err := os.Stat(hintSrc); err == nil {
+				if err := os.Rename(hintSrc, hintDst); err != nil {
+					return fmt.Errorf("failed to install merged hint file %s: %w", hintDst, err)
+				}
+			}
+		case "drop":
+			if err := os.Remove(hintDst); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove merged hint file %s: %w", hintDst, err)
+			}
+			if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove merged segment %s: %w", dst, err)
+			}
+		default:
+			return fmt.Errorf("invalid merge marker entry %q", line)
+		}
+	}
+
+	// The renames and removals must be durable before the marker that
+	// would redo them is removed
+	if err := syncDir(dataDir); err != nil {
+		return fmt.Errorf("failed to sync data directory %s: %w", dataDir, err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove merge directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// writeFileSync writes data to path and syncs it before returning.
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
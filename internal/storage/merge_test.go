@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sealedFile returns a File whose first segments have been rotated out,
+// one per chunk, followed by an empty active file.
+func sealedFile(t *testing.T, chunks ...string) *File {
+	t.Helper()
+	cfg := setupTestConfig(t)
+	cfg.MAX_FILE_SIZE = 1
+
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	for _, chunk := range chunks {
+		if _, _, err := file.Append([]byte(chunk)); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	// Seal the last chunk as well, leaving the active file empty
+	file.mu.Lock()
+	if err := file.rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	file.mu.Unlock()
+	return file
+}
+
+func TestFile_CommitMerge(t *testing.T) {
+	file := sealedFile(t, "aaaa", "bbbb", "cccc")
+	defer file.Close()
+
+	inputs := file.Segments()
+	inputs = inputs[:len(inputs)-1]
+	if len(inputs) != 3 {
+		t.Fatalf("Segments() = %v, want 3 sealed segments", inputs)
+	}
+
+	writer, err := file.NewMergeWriter(inputs)
+	if err != nil {
+		t.Fatalf("NewMergeWriter() error = %v", err)
+	}
+	fileId, offset, err := writer.Append([]byte("merged"))
+	if err != nil {
+		t.Fatalf("MergeWriter.Append() error = %v", err)
+	}
+	if fileId != inputs[0] || offset != 0 {
+		t.Errorf("MergeWriter.Append() = %d, %d, want %d, 0", fileId, offset, inputs[0])
+	}
+	if err := file.CommitMerge(writer); err != nil {
+		t.Fatalf("CommitMerge() error = %v", err)
+	}
+
+	data, err := file.ReadAt(fileId, offset, uint32(len("merged")))
+	if err != nil || string(data) != "merged" {
+		t.Errorf("ReadAt() = %q, %v, want %q", data, err, "merged")
+	}
+	if segments := file.Segments(); len(segments) != 2 {
+		t.Errorf("Segments() = %v, want the merged segment and the active file", segments)
+	}
+	for _, id := range inputs[1:] {
+		path := filepath.Join(file.cfg.DATA_DIR, SegmentFileName(id))
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("input segment %s still exists after merge", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(file.cfg.DATA_DIR, MergeDirName)); !os.IsNotExist(err) {
+		t.Error("merge directory still exists after merge")
+	}
+}
+
+func TestNewFile_RecoversInterruptedMerge(t *testing.T) {
+	file := sealedFile(t, "aaaa", "bbbb")
+	cfg := file.cfg
+	inputs := file.Segments()
+	inputs = inputs[:len(inputs)-1]
+
+	writer, err := file.NewMergeWriter(inputs)
+	if err != nil {
+		t.Fatalf("NewMergeWriter() error = %v", err)
+	}
+	if _, _, err := writer.Append([]byte("merged")); err != nil {
+		t.Fatalf("MergeWriter.Append() error = %v", err)
+	}
+	if err := writer.finish(); err != nil {
+		t.Fatalf("Failed to finish merge output: %v", err)
+	}
+	// Simulate a crash right after the done marker was written
+	marker := []byte("keep 0\ndrop 1\n")
+	if err := writeFileSync(filepath.Join(writer.dir, mergeDoneFileName), marker); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	reopened, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen file: %v", err)
+	}
+	defer reopened.Close()
+
+	data, err := reopened.ReadAt(0, 0, uint32(len("merged")))
+	if err != nil || string(data) != "merged" {
+		t.Errorf("ReadAt() = %q, %v, want %q", data, err, "merged")
+	}
+	if segments := reopened.Segments(); len(segments) != 2 {
+		t.Errorf("Segments() = %v, want the merged segment and the active file", segments)
+	}
+}
+
+func TestNewFile_DiscardsIncompleteMerge(t *testing.T) {
+	file := sealedFile(t, "aaaa")
+	cfg := file.cfg
+
+	writer, err := file.NewMergeWriter([]uint32{0})
+	if err != nil {
+		t.Fatalf("NewMergeWriter() error = %v", err)
+	}
+	if _, _, err := writer.Append([]byte("partial")); err != nil {
+		t.Fatalf("MergeWriter.Append() error = %v", err)
+	}
+	if err := writer.finish(); err != nil {
+		t.Fatalf("Failed to finish merge output: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	reopened, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen file: %v", err)
+	}
+	defer reopened.Close()
+
+	data, err := reopened.ReadAt(0, 0, uint32(len("aaaa")))
+	if err != nil || string(data) != "aaaa" {
+		t.Errorf("ReadAt() = %q, %v, want %q", data, err, "aaaa")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DATA_DIR, MergeDirName)); !os.IsNotExist(err) {
+		t.Error("incomplete merge directory was not discarded")
+	}
+}
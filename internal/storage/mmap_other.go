@@ -0,0 +1,16 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// mmapFile always fails on platforms without mmap, so segments are read
+// with positional reads.
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+// munmapFile is never called on platforms without mmap.
+func munmapFile(data []byte) error {
+	return nil
+}
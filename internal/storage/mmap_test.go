@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jassi-singh/aether-kv/internal/config"
+)
+
+// mappedFile returns a File with MMAP_SEGMENTS set whose chunks have each
+// been sealed into their own segment.
+func mappedFile(t testing.TB, chunks ...string) *File {
+	t.Helper()
+	cfg := &config.Config{
+		DATA_DIR:      t.TempDir(),
+		HEADER_SIZE:   21,
+		BATCH_SIZE:    4096,
+		SYNC_INTERVAL: 5,
+		MAX_FILE_SIZE: 1,
+		MMAP_SEGMENTS: true,
+	}
+
+	file, err := NewFile(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	for _, chunk := range chunks {
+		if _, _, err := file.Append([]byte(chunk)); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+	}
+	file.mu.Lock()
+	if err := file.rotate(); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	file.mu.Unlock()
+	return file
+}
+
+// readView returns a copy of the bytes ReadView passes to its callback.
+func readView(file *File, fileId uint32, offset int64, size uint32) (string, error) {
+	var got string
+	err := file.ReadView(fileId, offset, size, func(data []byte) error {
+		got = string(data)
+		return nil
+	})
+	return got, err
+}
+
+func TestFile_MappedSegments(t *testing.T) {
+	file := mappedFile(t, "aaaa", "bbbb", "cccc")
+
+	if got := file.MappedSegments(); got != 3 {
+		t.Fatalf("MappedSegments() = %d, want 3", got)
+	}
+	for i, want := range []string{"aaaa", "bbbb", "cccc"} {
+		if got, err := readView(file, uint32(i), 0, 4); err != nil || got != want {
+			t.Errorf("ReadView(%d) = %q, %v, want %q", i, got, err, want)
+		}
+	}
+	// A range past the end of the mapping falls back to a positional read
+	if got, err := readView(file, 0, 2, 4); err != nil || got != "aa\x00\x00" {
+		t.Errorf("ReadView() past mapping = %q, %v, want %q", got, err, "aa\x00\x00")
+	}
+
+	// Segments replaced by a merge are unmapped and their output mapped
+	writer, err := file.NewMergeWriter([]uint32{0, 1, 2})
+	if err != nil {
+		t.Fatalf("NewMergeWriter() error = %v", err)
+	}
+	if _, _, err := writer.Append([]byte("merged")); err != nil {
+		t.Fatalf("MergeWriter.Append() error = %v", err)
+	}
+	if err := file.CommitMerge(writer); err != nil {
+		t.Fatalf("CommitMerge() error = %v", err)
+	}
+	if got := file.MappedSegments(); got != 1 {
+		t.Errorf("MappedSegments() after merge = %d, want 1", got)
+	}
+	if got, err := readView(file, 0, 0, 6); err != nil || got != "merged" {
+		t.Errorf("ReadView(0) after merge = %q, %v, want %q", got, err, "merged")
+	}
+
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := file.MappedSegments(); got != 0 {
+		t.Errorf("MappedSegments() after Close = %d, want 0", got)
+	}
+}
+
+func BenchmarkFile_ReadSealed(b *testing.B) {
+	const records, recordSize = 1024, 256
+
+	for _, mmap := range []bool{false, true} {
+		b.Run(fmt.Sprintf("mmap=%v", mmap), func(b *testing.B) {
+			chunk := make([]byte, records*recordSize)
+			file := mappedFile(b, string(chunk))
+			defer file.Close()
+			if !mmap {
+				file.readMu.Lock()
+				file.unmapSegment(0)
+				file.readMu.Unlock()
+			}
+
+			b.SetBytes(recordSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				offset := int64(i%records) * recordSize
+				if err := file.ReadView(0, offset, recordSize, func(data []byte) error { return nil }); err != nil {
+					b.Fatalf("ReadView() error = %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import "fmt"
+
+// SyncMode controls when appended data is fsynced to stable storage.
+type SyncMode int
+
+const (
+	// SyncPeriodic fsyncs once SYNC_INTERVAL has passed since the last sync.
+	// A crash can lose the writes of the last interval.
+	SyncPeriodic SyncMode = iota
+	// SyncAlways fsyncs every write on its own before acknowledging it.
+	SyncAlways
+	// SyncBatch acknowledges a write only once it is fsynced, but coalesces
+	// concurrent writes into one append and one fsync (group commit).
+	SyncBatch
+	// SyncNone never fsyncs on the write path and leaves writeback to the
+	// operating system. Only rotation and Close sync the log.
+	SyncNone
+)
+
+// syncModeNames maps each SyncMode to its SYNC_MODE configuration value.
+var syncModeNames = map[SyncMode]string{
+	SyncPeriodic: "periodic",
+	SyncAlways:   "always",
+	SyncBatch:    "batch",
+	SyncNone:     "none",
+}
+
+// String returns the SYNC_MODE configuration value of the mode.
+func (m SyncMode) String() string {
+	if name, ok := syncModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("SyncMode(%d)", int(m))
+}
+
+// ParseSyncMode parses a SYNC_MODE configuration value. An empty value
+// selects SyncPeriodic.
+func ParseSyncMode(s string) (SyncMode, error) {
+	if s == "" {
+		return SyncPeriodic, nil
+	}
+	for mode, name := range syncModeNames {
+		if name == s {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid sync mode %q: must be always, batch, periodic or none", s)
+}
@@ -0,0 +1,207 @@
+package aetherkv
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jassi-singh/aether-kv/internal/config"
+)
+
+// headerSize is the size in bytes of a record header in the on-disk format.
+const headerSize = 21
+
+// SyncMode controls when writes are fsynced to stable storage, trading
+// durability against write throughput.
+type SyncMode int
+
+const (
+	// SyncPeriodic acknowledges writes once buffered and fsyncs them once the
+	// sync interval has passed. A crash can lose the writes of the last
+	// interval. This is the default.
+	SyncPeriodic SyncMode = iota
+	// SyncAlways fsyncs every write on its own before acknowledging it.
+	SyncAlways
+	// SyncBatch acknowledges every write only once it is fsynced, but
+	// coalesces concurrent writes into a single write and fsync (group
+	// commit). It is as durable as SyncAlways and much faster under
+	// concurrent load.
+	SyncBatch
+	// SyncNone never fsyncs on the write path and leaves writeback to the
+	// operating system. Writes survive a process crash but not a power loss.
+	SyncNone
+)
+
+// syncModeNames maps each SyncMode to its SYNC_MODE configuration value.
+var syncModeNames = map[SyncMode]string{
+	SyncPeriodic: "periodic",
+	SyncAlways:   "always",
+	SyncBatch:    "batch",
+	SyncNone:     "none",
+}
+
+// String returns the name of the mode.
+func (m SyncMode) String() string {
+	if name, ok := syncModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("SyncMode(%d)", int(m))
+}
+
+// Option configures a DB opened with Open.
+type Option func(*options)
+
+// options holds the settings collected from the Options passed to Open.
+type options struct {
+	bufferSize     int
+	syncMode       SyncMode
+	syncInterval   time.Duration
+	maxFileSize    int64
+	maxFileAge     time.Duration
+	mergeThreshold int64
+	reapInterval   time.Duration
+	mmap           bool
+	cacheSize      int64
+}
+
+// defaultOptions returns the settings used when no Option overrides them.
+func defaultOptions() options {
+	return options{
+		bufferSize:   4096,
+		syncInterval: 5 * time.Second,
+		maxFileSize:  64 << 20,
+		reapInterval: time.Minute,
+	}
+}
+
+// WithBufferSize sets how many bytes of writes are buffered in memory before
+// they are flushed to the active log. Defaults to 4 KiB.
+func WithBufferSize(n int) Option {
+	return func(o *options) {
+		o.bufferSize = n
+	}
+}
+
+// WithSyncMode sets when writes are fsynced. Defaults to SyncPeriodic.
+func WithSyncMode(mode SyncMode) Option {
+	return func(o *options) {
+		o.syncMode = mode
+	}
+}
+
+// WithSyncInterval sets how often writes are fsynced under SyncPeriodic. It
+// is rounded down to whole seconds. Defaults to 5 seconds.
+func WithSyncInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.syncInterval = d
+	}
+}
+
+// WithMaxFileSize sets the size in bytes at which the active log is sealed
+// and a new one started. Zero disables size-based rotation. Defaults to
+// 64 MiB.
+func WithMaxFileSize(n int64) Option {
+	return func(o *options) {
+		o.maxFileSize = n
+	}
+}
+
+// WithMaxFileAge sets the age at which the active log is sealed even if it
+// is below the maximum size. It is rounded down to whole seconds. Zero, the
+// default, disables age-based rotation.
+func WithMaxFileAge(d time.Duration) Option {
+	return func(o *options) {
+		o.maxFileAge = d
+	}
+}
+
+// WithMergeThreshold sets how many dead bytes sealed segments may hold before
+// a background merge rewrites them. Zero, the default, disables automatic
+// merges; DB.Merge can still be called.
+func WithMergeThreshold(n int64) Option {
+	return func(o *options) {
+		o.mergeThreshold = n
+	}
+}
+
+// WithReapInterval sets the interval between sweeps that delete expired
+// keys. It is rounded down to whole seconds. Zero disables the sweeps;
+// expired keys still read as not found. Defaults to one minute.
+func WithReapInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.reapInterval = d
+	}
+}
+
+// WithMmap memory-maps sealed log files so reads of them are served from
+// the mapping instead of a system call per read. It suits read-heavy
+// workloads whose data fits comfortably in the page cache. Disabled by
+// default, and ignored on platforms without mmap.
+func WithMmap(enabled bool) Option {
+	return func(o *options) {
+		o.mmap = enabled
+	}
+}
+
+// WithCacheSize keeps up to n bytes of recently read values in memory so
+// repeated reads of hot keys skip decoding them from disk. Zero, the
+// default, disables the cache.
+func WithCacheSize(n int64) Option {
+	return func(o *options) {
+		o.cacheSize = n
+	}
+}
+
+// config validates the options and converts them to an engine configuration
+// for the given directory.
+func (o options) config(dir string) (*config.Config, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("directory cannot be empty")
+	}
+	if o.bufferSize < 1 || o.bufferSize > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid buffer size %d: must be between 1 and %d", o.bufferSize, uint32(math.MaxUint32))
+	}
+	if _, ok := syncModeNames[o.syncMode]; !ok {
+		return nil, fmt.Errorf("invalid sync mode %v", o.syncMode)
+	}
+	if o.syncInterval < time.Second {
+		return nil, fmt.Errorf("invalid sync interval %v: must be at least 1s", o.syncInterval)
+	}
+	if o.maxFileSize < 0 || o.maxFileSize > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid max file size %d: must be between 0 and %d", o.maxFileSize, uint32(math.MaxUint32))
+	}
+	if o.mergeThreshold < 0 || o.mergeThreshold > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid merge threshold %d: must be between 0 and %d", o.mergeThreshold, uint32(math.MaxUint32))
+	}
+	if o.cacheSize < 0 || o.cacheSize > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid cache size %d: must be between 0 and %d", o.cacheSize, uint32(math.MaxUint32))
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{name: "sync interval", value: o.syncInterval},
+		{name: "max file age", value: o.maxFileAge},
+		{name: "reap interval", value: o.reapInterval},
+	}
+	for _, d := range durations {
+		if d.value < 0 || d.value/time.Second > math.MaxUint32 {
+			return nil, fmt.Errorf("invalid %s %v: must be between 0 and %d seconds", d.name, d.value, uint32(math.MaxUint32))
+		}
+	}
+
+	return &config.Config{
+		DATA_DIR:        dir,
+		HEADER_SIZE:     headerSize,
+		BATCH_SIZE:      uint32(o.bufferSize),
+		SYNC_INTERVAL:   uint32(o.syncInterval / time.Second),
+		SYNC_MODE:       o.syncMode.String(),
+		MAX_FILE_SIZE:   uint32(o.maxFileSize),
+		MAX_FILE_AGE:    uint32(o.maxFileAge / time.Second),
+		MERGE_THRESHOLD: uint32(o.mergeThreshold),
+		REAP_INTERVAL:   uint32(o.reapInterval / time.Second),
+		MMAP_SEGMENTS:   o.mmap,
+		CACHE_SIZE:      uint32(o.cacheSize),
+	}, nil
+}